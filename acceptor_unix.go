@@ -18,6 +18,8 @@
 package gnet
 
 import (
+	"net"
+	"strings"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -46,27 +48,48 @@ func (el *eventloop) accept0(fd int, _ netpoll.IOEvent, _ netpoll.IOFlags) error
 			}
 		}
 
-		remoteAddr := socket.SockaddrToTCPOrUnixAddr(sa)
-		if el.engine.opts.TCPKeepAlive > 0 && el.listeners[fd].network == "tcp" {
-			err = socket.SetKeepAlivePeriod(nfd, int(el.engine.opts.TCPKeepAlive.Seconds()))
-			if err != nil {
-				el.getLogger().Errorf("failed to set TCP keepalive on fd=%d: %v", fd, err)
-			}
-		}
+		_ = el.accepted(fd, nfd, sa)
+	}
+}
 
-		el := el.engine.eventLoops.next(remoteAddr)
-		c := newTCPConn(nfd, el, sa, el.listeners[fd].addr, remoteAddr)
-		err = el.poller.Trigger(queue.HighPriority, el.register, c)
+// accepted sets up the connection that has been accepted from the listener fd, the main
+// reactor hands it over to one of the event-loops while others take care of it themselves.
+func (el *eventloop) accepted(fd, nfd int, sa unix.Sockaddr) error {
+	remoteAddr := socket.SockaddrToTCPOrUnixAddr(sa)
+	if ua, ok := remoteAddr.(*net.UnixAddr); ok {
+		ua.Net = el.listeners[fd].network
+	}
+	if el.engine.opts.TCPKeepAlive > 0 && el.listeners[fd].network == "tcp" {
+		err := socket.SetKeepAlivePeriod(nfd, int(el.engine.opts.TCPKeepAlive/time.Second))
 		if err != nil {
-			el.getLogger().Errorf("failed to enqueue the accepted socket fd=%d to poller: %v", c.fd, err)
-			_ = unix.Close(nfd)
-			c.release()
+			el.getLogger().Errorf("failed to set TCP keepalive on fd=%d: %v", fd, err)
 		}
 	}
+	if el.engine.socketBusyPoll > 0 && !strings.HasPrefix(el.listeners[fd].network, "unix") {
+		err := socket.SetBusyPoll(nfd, el.engine.socketBusyPoll)
+		if err != nil {
+			el.getLogger().Errorf("failed to set SO_BUSY_POLL on fd=%d: %v", nfd, err)
+		}
+	}
+
+	if el.idx >= 0 {
+		c := newTCPConn(nfd, el, sa, el.listeners[fd].addr, remoteAddr)
+		return el.register0(c)
+	}
+
+	el = el.engine.eventLoops.next(remoteAddr)
+	c := newTCPConn(nfd, el, sa, el.listeners[fd].addr, remoteAddr)
+	err := el.poller.Trigger(queue.HighPriority, el.register, c)
+	if err != nil {
+		el.getLogger().Errorf("failed to enqueue the accepted socket fd=%d to poller: %v", c.fd, err)
+		_ = unix.Close(nfd)
+		c.release()
+	}
+	return nil
 }
 
 func (el *eventloop) accept(fd int, ev netpoll.IOEvent, flags netpoll.IOFlags) error {
-	if el.listeners[fd].network == "udp" {
+	if network := el.listeners[fd].network; network == "udp" || network == "unixgram" {
 		return el.readUDP(fd, ev, flags)
 	}
 
@@ -84,14 +107,5 @@ func (el *eventloop) accept(fd int, ev netpoll.IOEvent, flags netpoll.IOFlags) e
 		}
 	}
 
-	remoteAddr := socket.SockaddrToTCPOrUnixAddr(sa)
-	if el.engine.opts.TCPKeepAlive > 0 && el.listeners[fd].network == "tcp" {
-		err = socket.SetKeepAlivePeriod(nfd, int(el.engine.opts.TCPKeepAlive/time.Second))
-		if err != nil {
-			el.getLogger().Errorf("failed to set TCP keepalive on fd=%d: %v", fd, err)
-		}
-	}
-
-	c := newTCPConn(nfd, el, sa, el.listeners[fd].addr, remoteAddr)
-	return el.register0(c)
+	return el.accepted(fd, nfd, sa)
 }
@@ -0,0 +1,559 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
+	"github.com/panjf2000/gnet/v2/pkg/logging"
+)
+
+const (
+	// DefaultPoolMaxConns is the default maximum number of connections to each upstream in ClientPool.
+	DefaultPoolMaxConns = 16
+	// DefaultPoolMinBackoff is the default delay of the first reconnect in ClientPool.
+	DefaultPoolMinBackoff = 100 * time.Millisecond
+	// DefaultPoolMaxBackoff is the default upper bound of the reconnect delay in ClientPool.
+	DefaultPoolMaxBackoff = 30 * time.Second
+)
+
+// PoolOptions are the options for ClientPool.
+type PoolOptions struct {
+	// Network is the network of the upstreams, the default value is "tcp".
+	Network string
+
+	// MinConns is the number of connections that are kept to each upstream once it has been used.
+	MinConns int
+
+	// MaxConns is the maximum number of connections to each upstream, the default value is
+	// DefaultPoolMaxConns, and it's never less than MinConns.
+	MaxConns int
+
+	// DialTimeout is the maximum amount of time a dial will wait for the connect to complete.
+	DialTimeout time.Duration
+
+	// MinBackoff is the delay of the first reconnect after a connection has failed to be
+	// dialed or closed, the delay doubles with every consecutive failure up to MaxBackoff
+	// and is randomized by a jitter. The default values are DefaultPoolMinBackoff and
+	// DefaultPoolMaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// HealthCheckInterval is the interval of the health probes, no probes are sent if it's
+	// not greater than 0 or HealthProbe is empty. HealthProbe is written through AsyncWrite
+	// to every idle connection each interval, and the connections that haven't received any
+	// data since the last probe are considered to be unhealthy and closed. The connections
+	// aren't checked out until the response of the probe is received, which is passed to
+	// EventHandler.OnTraffic as usual and supposed to be ignored by the handler.
+	HealthCheckInterval time.Duration
+	HealthProbe         []byte
+}
+
+func (opts *PoolOptions) normalize() {
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.MaxConns <= 0 {
+		opts.MaxConns = DefaultPoolMaxConns
+	}
+	if opts.MaxConns < opts.MinConns {
+		opts.MaxConns = opts.MinConns
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultPoolMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultPoolMaxBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff
+	}
+	if len(opts.HealthProbe) == 0 {
+		opts.HealthCheckInterval = 0
+	}
+}
+
+// backoff returns the delay of the reconnect after the given number of consecutive failures,
+// which is picked randomly from [d/2, d] where d grows exponentially with the failures.
+func (opts *PoolOptions) backoff(failures int) time.Duration {
+	d := opts.MaxBackoff
+	if shift := failures - 1; shift < 32 {
+		if b := opts.MinBackoff << shift; b > 0 && b < d {
+			d = b
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// PoolStats is the statistics of the connections to an upstream in ClientPool.
+type PoolStats struct {
+	Idle    int // connections that are ready to be checked out
+	Busy    int // connections that have been checked out
+	Dialing int // connections being dialed
+	Waiting int // callers of Checkout waiting for a connection
+}
+
+// ClientPool is a pool of client connections keyed by the address of the upstream,
+// it's built on Client and meant for request-response protocols: a connection is
+// checked out for a request and returned after the response has been received.
+//
+// The pool keeps at least PoolOptions.MinConns connections to each upstream it has
+// been asked for, and it dials a new connection for Checkout when there are no idle
+// ones until PoolOptions.MaxConns is reached. The connections that fail to be dialed
+// or get closed with errors are redialed with exponential backoff and jitter, while
+// the ones closed locally or by the upstream gracefully are redialed right away.
+//
+// The event handler works as it does with Client, except that Conn.Context is nil in
+// OnOpen rather than the context of the dial. Note that the checked-out connections
+// are supposed to be written with AsyncWrite or AsyncWritev outside event-loops.
+type ClientPool struct {
+	cli       *Client
+	opts      PoolOptions
+	mu        sync.Mutex
+	upstreams map[string]*upstream
+	conns     map[Conn]*pooledConn // opened connections keyed by the underlying connections
+	closed    bool
+	done      chan struct{}
+}
+
+// upstream is the set of connections to an address.
+type upstream struct {
+	address  string
+	idle     []*pooledConn
+	open     int // number of opened connections, including the idle ones
+	dialing  int
+	failures int         // number of consecutive failures since the last connection was opened
+	retry    *time.Timer // pending reconnect after backoff
+	waiters  []chan *pooledConn
+}
+
+type pooledConn struct {
+	up        *upstream
+	c         Conn
+	busy      bool
+	probing   bool // a health probe has been sent and no data has been received since then
+	unhealthy bool // the connection is being closed by the health check
+}
+
+// NewClientPool creates a ClientPool with the event handler and the options of the Client underneath.
+func NewClientPool(eh EventHandler, popts PoolOptions, opts ...Option) (*ClientPool, error) {
+	popts.normalize()
+	p := &ClientPool{
+		opts:      popts,
+		upstreams: make(map[string]*upstream),
+		conns:     make(map[Conn]*pooledConn),
+		done:      make(chan struct{}),
+	}
+	cli, err := NewClient(&poolEventHandler{EventHandler: eh, pool: p}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.cli = cli
+	return p, nil
+}
+
+// Start starts the pool and the Client underneath.
+func (p *ClientPool) Start() error {
+	if err := p.cli.Start(); err != nil {
+		return err
+	}
+	if p.opts.HealthCheckInterval > 0 {
+		go p.healthCheck()
+	}
+	return nil
+}
+
+// Stop closes all connections in the pool and stops the Client underneath,
+// the callers waiting in Checkout get ErrClientPoolClosed.
+func (p *ClientPool) Stop() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errorx.ErrClientPoolClosed
+	}
+	p.closed = true
+	for _, up := range p.upstreams {
+		if up.retry != nil {
+			up.retry.Stop()
+		}
+		for _, ch := range up.waiters {
+			ch <- nil
+		}
+		up.waiters = nil
+	}
+	p.mu.Unlock()
+	close(p.done)
+	return p.cli.Stop()
+}
+
+// Checkout takes a connection to address out of the pool, it waits for a connection
+// to be returned or dialed if there are no idle ones, until ctx is done.
+func (p *ClientPool) Checkout(ctx context.Context, address string) (Conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errorx.ErrClientPoolClosed
+	}
+	up := p.upstreams[address]
+	if up == nil {
+		up = &upstream{address: address}
+		p.upstreams[address] = up
+	}
+	for i := len(up.idle) - 1; i >= 0; i-- {
+		if pc := up.idle[i]; !pc.probing {
+			up.removeIdle(i)
+			pc.busy = true
+			p.mu.Unlock()
+			return pc.c, nil
+		}
+	}
+	ch := make(chan *pooledConn, 1)
+	up.waiters = append(up.waiters, ch)
+	pcs := p.replenish(up)
+	p.mu.Unlock()
+	p.dial(pcs)
+
+	select {
+	case pc := <-ch:
+		if pc == nil {
+			return nil, errorx.ErrClientPoolClosed
+		}
+		return pc.c, nil
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	for i, w := range up.waiters {
+		if w == ch {
+			up.waiters = append(up.waiters[:i], up.waiters[i+1:]...)
+			p.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	p.mu.Unlock()
+	// A connection has been handed over in the meantime, put it back.
+	if pc := <-ch; pc != nil {
+		p.Return(pc.c)
+	}
+	return nil, ctx.Err()
+}
+
+// Return puts a connection taken by Checkout back into the pool,
+// it's a no-op if the connection has been closed.
+func (p *ClientPool) Return(c Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pc := p.conns[poolKey(c)]; pc != nil && pc.busy {
+		p.release(pc)
+	}
+}
+
+// Stats returns the statistics of the connections to address.
+func (p *ClientPool) Stats(address string) (stats PoolStats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if up := p.upstreams[address]; up != nil {
+		stats.Idle = len(up.idle)
+		stats.Busy = up.open - len(up.idle)
+		stats.Dialing = up.dialing
+		stats.Waiting = len(up.waiters)
+	}
+	return
+}
+
+// release hands the connection over to the first waiter, or makes it idle if there is none.
+func (p *ClientPool) release(pc *pooledConn) {
+	up := pc.up
+	if len(up.waiters) > 0 {
+		ch := up.waiters[0]
+		up.waiters[0] = nil
+		up.waiters = up.waiters[1:]
+		pc.busy = true
+		ch <- pc
+		return
+	}
+	pc.busy = false
+	up.idle = append(up.idle, pc)
+}
+
+// removeIdle removes the i-th idle connection.
+func (up *upstream) removeIdle(i int) {
+	n := len(up.idle)
+	copy(up.idle[i:], up.idle[i+1:])
+	up.idle[n-1] = nil
+	up.idle = up.idle[:n-1]
+}
+
+// replenish counts the dials of the connections that are lacking, unless a reconnect
+// is pending, the dials are started by dial once the lock has been released.
+func (p *ClientPool) replenish(up *upstream) (pcs []*pooledConn) {
+	if p.closed || up.retry != nil {
+		return
+	}
+	want := up.open - len(up.idle) + len(up.waiters)
+	if want < p.opts.MinConns {
+		want = p.opts.MinConns
+	}
+	if want > p.opts.MaxConns {
+		want = p.opts.MaxConns
+	}
+	for n := want - up.open - up.dialing; n > 0; n-- {
+		pcs = append(pcs, &pooledConn{up: up})
+		up.dialing++
+	}
+	return
+}
+
+// dial dials the connections counted by replenish on a goroutine, since DialWithContext
+// resolves the address of the upstream before it returns, which mustn't block the callers
+// of the pool or the event-loop that closes a connection.
+func (p *ClientPool) dial(pcs []*pooledConn) {
+	if len(pcs) == 0 {
+		return
+	}
+	go func() {
+		for i, pc := range pcs {
+			pc := pc
+			err := p.cli.DialWithContext(context.Background(), p.opts.Network, pc.up.address, &DialOptions{
+				Timeout: p.opts.DialTimeout,
+				Context: pc,
+				Callback: func(_ Conn, err error) error {
+					if err != nil {
+						p.mu.Lock()
+						p.failed(pc)
+						p.mu.Unlock()
+					}
+					return nil
+				},
+			})
+			if err != nil {
+				// The rest of the dials aren't attempted, only one failure is recorded for them.
+				p.mu.Lock()
+				pc.up.dialing -= len(pcs) - i - 1
+				p.failed(pc)
+				p.mu.Unlock()
+				return
+			}
+		}
+	}()
+}
+
+// add puts the connection that has been dialed and opened into the pool.
+func (p *ClientPool) add(pc *pooledConn, c Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	up := pc.up
+	up.dialing--
+	if p.closed {
+		_ = c.Close()
+		return
+	}
+	up.open++
+	up.failures = 0
+	pc.c = c
+	p.conns[poolKey(c)] = pc
+	p.release(pc)
+}
+
+// remove removes the connection closed with err from the pool and redials it if needed,
+// with backoff if it has failed.
+func (p *ClientPool) remove(c Conn, err error) {
+	p.mu.Lock()
+	pcs := p.remove0(c, err)
+	p.mu.Unlock()
+	p.dial(pcs)
+}
+
+func (p *ClientPool) remove0(c Conn, err error) []*pooledConn {
+	key := poolKey(c)
+	pc := p.conns[key]
+	if pc == nil {
+		// The connection may be closed before it's opened, e.g. the TLS handshake failed.
+		ctx := c.Context()
+		if tc, ok := ctx.(*tlsConn); ok {
+			ctx = tc.ctx
+		}
+		if pc, ok := ctx.(*pooledConn); ok {
+			p.failed(pc)
+		}
+		return nil
+	}
+	delete(p.conns, key)
+	up := pc.up
+	up.open--
+	if !pc.busy {
+		for i, idle := range up.idle {
+			if idle == pc {
+				up.removeIdle(i)
+				break
+			}
+		}
+	}
+	pc.c = nil
+	if p.closed {
+		return nil
+	}
+	if pc.unhealthy || (err != nil && !errors.Is(err, io.EOF)) {
+		up.failures++
+		p.scheduleRetry(up)
+		return nil
+	}
+	return p.replenish(up)
+}
+
+// failed records the failure of dialing pc and schedules the reconnect.
+func (p *ClientPool) failed(pc *pooledConn) {
+	up := pc.up
+	up.dialing--
+	if p.closed {
+		return
+	}
+	up.failures++
+	p.scheduleRetry(up)
+}
+
+func (p *ClientPool) scheduleRetry(up *upstream) {
+	if up.retry != nil {
+		return
+	}
+	delay := p.opts.backoff(up.failures)
+	logging.Debugf("reconnect to %s in %v after %d failures", up.address, delay, up.failures)
+	up.retry = time.AfterFunc(delay, func() {
+		p.mu.Lock()
+		up.retry = nil
+		pcs := p.replenish(up)
+		p.mu.Unlock()
+		p.dial(pcs)
+	})
+}
+
+// traffic marks the connection healthy after a probe and hands it over to a waiter if any.
+func (p *ClientPool) traffic(c Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pc := p.conns[poolKey(c)]
+	if pc == nil || !pc.probing || pc.unhealthy {
+		return
+	}
+	pc.probing = false
+	if pc.busy || len(pc.up.waiters) == 0 {
+		return
+	}
+	for i, idle := range pc.up.idle {
+		if idle == pc {
+			pc.up.removeIdle(i)
+			p.release(pc)
+			return
+		}
+	}
+}
+
+// healthCheck probes the idle connections periodically and closes the unhealthy ones.
+func (p *ClientPool) healthCheck() {
+	ticker := time.NewTicker(p.opts.HealthCheckInterval)
+	defer ticker.Stop()
+	var probes, unhealthy []Conn
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		for _, up := range p.upstreams {
+			for _, pc := range up.idle {
+				if pc.unhealthy {
+					continue
+				}
+				if pc.probing {
+					pc.unhealthy = true
+					unhealthy = append(unhealthy, pc.c)
+					continue
+				}
+				pc.probing = true
+				probes = append(probes, pc.c)
+			}
+		}
+		p.mu.Unlock()
+
+		for i, c := range unhealthy {
+			logging.Warnf("close the unhealthy connection to %s", c.RemoteAddr())
+			_ = c.Close()
+			unhealthy[i] = nil
+		}
+		for i, c := range probes {
+			_ = c.AsyncWrite(p.opts.HealthProbe, func(c Conn, err error) error {
+				if err != nil {
+					return c.Close()
+				}
+				return nil
+			})
+			probes[i] = nil
+		}
+		probes, unhealthy = probes[:0], unhealthy[:0]
+	}
+}
+
+// poolKey returns the connection underneath the TLS connection, which identifies
+// the connection in all events.
+func poolKey(c Conn) Conn {
+	if tc, ok := c.(*tlsConn); ok {
+		return tc.raw
+	}
+	return c
+}
+
+// poolEventHandler tracks the lifecycle of the connections of ClientPool.
+type poolEventHandler struct {
+	EventHandler
+	pool *ClientPool
+}
+
+func (h *poolEventHandler) OnOpen(c Conn) (out []byte, action Action) {
+	pc, ok := c.Context().(*pooledConn)
+	if ok {
+		c.SetContext(nil)
+	}
+	out, action = h.EventHandler.OnOpen(c)
+	if ok {
+		if action == None {
+			h.pool.add(pc, c)
+		} else {
+			h.pool.mu.Lock()
+			h.pool.failed(pc)
+			h.pool.mu.Unlock()
+		}
+	}
+	return
+}
+
+func (h *poolEventHandler) OnClose(c Conn, err error) (action Action) {
+	h.pool.remove(c, err)
+	return h.EventHandler.OnClose(c, err)
+}
+
+func (h *poolEventHandler) OnTraffic(c Conn) (action Action) {
+	if h.pool.opts.HealthCheckInterval > 0 {
+		h.pool.traffic(c)
+	}
+	return h.EventHandler.OnTraffic(c)
+}
@@ -5,9 +5,11 @@ package gnet
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"math/rand"
 	"net"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -694,3 +696,377 @@ func (cli clientReadOnEOF) OnTraffic(c Conn) (action Action) {
 	}{data: data, err: err}
 	return None
 }
+
+func TestMultiLoopClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:9971")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(c, c)
+				_ = c.Close()
+			}()
+		}
+	}()
+
+	for _, lb := range []LoadBalancing{RoundRobin, LeastConnections} {
+		ev := &multiLoopClient{echoed: make(chan []byte, 64)}
+		cli, err := NewClient(ev, WithNumEventLoop(4), WithLoadBalancing(lb), WithTicker(true))
+		require.NoError(t, err)
+		require.NoError(t, cli.Start())
+		require.Equal(t, []int{0, 0, 0, 0}, cli.CountConnections())
+
+		conns := make([]Conn, 16)
+		for i := range conns {
+			conns[i], err = cli.Dial("tcp", ln.Addr().String())
+			require.NoError(t, err)
+		}
+		require.Equal(t, []int{4, 4, 4, 4}, cli.CountConnections())
+
+		for i, c := range conns {
+			data := []byte(strconv.Itoa(i))
+			require.NoError(t, c.AsyncWrite(data, nil))
+			select {
+			case echoed := <-ev.echoed:
+				require.Equal(t, data, echoed)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for the echo of connection %d", i)
+			}
+		}
+		require.Eventually(t, func() bool { return atomic.LoadInt32(&ev.ticks) > 0 }, 5*time.Second, 10*time.Millisecond)
+
+		for _, c := range conns[:4] {
+			require.NoError(t, c.Close())
+		}
+		require.Eventually(t, func() bool {
+			var n int
+			for _, count := range cli.CountConnections() {
+				n += count
+			}
+			return n == 12
+		}, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, cli.Stop())
+	}
+}
+
+type multiLoopClient struct {
+	*BuiltinEventEngine
+	ticks  int32
+	echoed chan []byte
+}
+
+func (ev *multiLoopClient) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	ev.echoed <- append([]byte{}, buf...)
+	return
+}
+
+func (ev *multiLoopClient) OnTick() (delay time.Duration, action Action) {
+	atomic.AddInt32(&ev.ticks, 1)
+	return 10 * time.Millisecond, None
+}
+
+// poolUpstream is an upstream server of ClientPool, which echoes the data back unless it's muted.
+type poolUpstream struct {
+	ln       net.Listener
+	accepted int32
+	muted    int32
+	probes   int32
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func startPoolUpstream(t *testing.T, addr string) *poolUpstream {
+	ln, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	s := &poolUpstream{ln: ln}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.accepted, 1)
+			s.mu.Lock()
+			s.conns = append(s.conns, c)
+			s.mu.Unlock()
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 1024)
+				for {
+					n, err := c.Read(buf)
+					if err != nil {
+						return
+					}
+					if bytes.Equal(buf[:n], []byte("PING")) {
+						atomic.AddInt32(&s.probes, 1)
+					}
+					if atomic.LoadInt32(&s.muted) == 1 {
+						continue
+					}
+					if _, err = c.Write(buf[:n]); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *poolUpstream) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		_ = c.Close()
+	}
+	s.conns = nil
+}
+
+type poolClient struct {
+	*BuiltinEventEngine
+	responses chan string
+}
+
+func (ev *poolClient) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	if !bytes.Equal(buf, []byte("PING")) {
+		ev.responses <- string(buf)
+	}
+	return
+}
+
+func TestClientPool(t *testing.T) {
+	t.Run("checkout-return", testClientPoolCheckout)
+	t.Run("reconnect", testClientPoolReconnect)
+	t.Run("clean-close", testClientPoolCleanClose)
+	t.Run("health-check", testClientPoolHealthCheck)
+	t.Run("probing", testClientPoolProbing)
+	t.Run("normalize", func(t *testing.T) {
+		opts := PoolOptions{HealthCheckInterval: time.Second}
+		opts.normalize()
+		require.Zero(t, opts.HealthCheckInterval, "no probes are sent without HealthProbe")
+		opts = PoolOptions{HealthCheckInterval: time.Second, HealthProbe: []byte("PING")}
+		opts.normalize()
+		require.Equal(t, time.Second, opts.HealthCheckInterval)
+	})
+	t.Run("backoff", func(t *testing.T) {
+		opts := PoolOptions{MinBackoff: 10 * time.Millisecond, MaxBackoff: time.Second}
+		for failures := 1; failures <= 100; failures++ {
+			d := time.Second
+			if failures <= 7 {
+				d = 10 * time.Millisecond << (failures - 1)
+			}
+			delay := opts.backoff(failures)
+			require.GreaterOrEqual(t, delay, d/2)
+			require.LessOrEqual(t, delay, d)
+		}
+	})
+}
+
+func testClientPoolCheckout(t *testing.T) {
+	upstream := startPoolUpstream(t, "127.0.0.1:0")
+	defer upstream.ln.Close()
+	addr := upstream.ln.Addr().String()
+
+	ev := &poolClient{responses: make(chan string, 16)}
+	pool, err := NewClientPool(ev, PoolOptions{MinConns: 2, MaxConns: 3})
+	require.NoError(t, err)
+	require.NoError(t, pool.Start())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := pool.Checkout(ctx, addr)
+	require.NoError(t, err)
+	require.NoError(t, c.AsyncWrite([]byte("request-1"), nil))
+	require.Equal(t, "request-1", <-ev.responses)
+	require.Eventually(t, func() bool {
+		return pool.Stats(addr) == PoolStats{Idle: 1, Busy: 1}
+	}, 5*time.Second, 10*time.Millisecond, "the pool should keep MinConns connections")
+
+	conns := []Conn{c}
+	for i := 0; i < 2; i++ {
+		c, err = pool.Checkout(ctx, addr)
+		require.NoError(t, err)
+		conns = append(conns, c)
+	}
+	require.Equal(t, PoolStats{Busy: 3}, pool.Stats(addr))
+
+	// All connections are busy and MaxConns has been reached.
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = pool.Checkout(timeoutCtx, addr)
+	timeoutCancel()
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, PoolStats{Busy: 3}, pool.Stats(addr))
+
+	// The returned connection is handed over to the waiter.
+	checkedOut := make(chan Conn)
+	go func() {
+		c, err := pool.Checkout(ctx, addr)
+		assert.NoError(t, err)
+		checkedOut <- c
+	}()
+	require.Eventually(t, func() bool { return pool.Stats(addr).Waiting == 1 }, 5*time.Second, time.Millisecond)
+	pool.Return(conns[1])
+	require.Equal(t, conns[1], <-checkedOut)
+	require.NoError(t, conns[1].AsyncWrite([]byte("request-2"), nil))
+	require.Equal(t, "request-2", <-ev.responses)
+
+	for _, c := range conns {
+		pool.Return(c)
+	}
+	require.Equal(t, PoolStats{Idle: 3}, pool.Stats(addr))
+	require.EqualValues(t, 3, atomic.LoadInt32(&upstream.accepted))
+
+	require.NoError(t, pool.Stop())
+	_, err = pool.Checkout(ctx, addr)
+	require.ErrorIs(t, err, errorx.ErrClientPoolClosed)
+}
+
+func testClientPoolReconnect(t *testing.T) {
+	// Reserve an address for the upstream that isn't up yet.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	ev := &poolClient{responses: make(chan string, 16)}
+	pool, err := NewClientPool(ev, PoolOptions{
+		MinConns:   2,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.NoError(t, pool.Start())
+	defer pool.Stop() //nolint:errcheck
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	checkedOut := make(chan Conn)
+	go func() {
+		c, err := pool.Checkout(ctx, addr)
+		assert.NoError(t, err)
+		checkedOut <- c
+	}()
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, 1, pool.Stats(addr).Waiting)
+
+	// The dials keep being retried until the upstream is up.
+	upstream := startPoolUpstream(t, addr)
+	defer upstream.ln.Close()
+	c := <-checkedOut
+	require.NoError(t, c.AsyncWrite([]byte("request"), nil))
+	require.Equal(t, "request", <-ev.responses)
+	pool.Return(c)
+	require.Eventually(t, func() bool {
+		return pool.Stats(addr) == PoolStats{Idle: 2}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The connections closed by the upstream are redialed.
+	upstream.closeConns()
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&upstream.accepted) == 4 && pool.Stats(addr) == PoolStats{Idle: 2}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func testClientPoolCleanClose(t *testing.T) {
+	upstream := startPoolUpstream(t, "127.0.0.1:0")
+	defer upstream.ln.Close()
+	addr := upstream.ln.Addr().String()
+
+	// The connections closed without errors are redialed without backoff.
+	ev := &poolClient{responses: make(chan string, 16)}
+	pool, err := NewClientPool(ev, PoolOptions{MinConns: 1, MinBackoff: time.Minute})
+	require.NoError(t, err)
+	require.NoError(t, pool.Start())
+	defer pool.Stop() //nolint:errcheck
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := pool.Checkout(ctx, addr)
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&upstream.accepted) == 2 && pool.Stats(addr) == PoolStats{Idle: 1}
+	}, 5*time.Second, 10*time.Millisecond, "the connection closed locally should be redialed")
+
+	upstream.closeConns()
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&upstream.accepted) == 3 && pool.Stats(addr) == PoolStats{Idle: 1}
+	}, 5*time.Second, 10*time.Millisecond, "the connection closed by the upstream should be redialed")
+}
+
+func testClientPoolProbing(t *testing.T) {
+	upstream := startPoolUpstream(t, "127.0.0.1:0")
+	defer upstream.ln.Close()
+	addr := upstream.ln.Addr().String()
+
+	ev := &poolClient{responses: make(chan string, 16)}
+	pool, err := NewClientPool(ev, PoolOptions{MaxConns: 1})
+	require.NoError(t, err)
+	require.NoError(t, pool.Start())
+	defer pool.Stop() //nolint:errcheck
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := pool.Checkout(ctx, addr)
+	require.NoError(t, err)
+	pool.Return(c)
+
+	// The connection isn't checked out while the probe is outstanding.
+	pool.mu.Lock()
+	pool.conns[poolKey(c)].probing = true
+	pool.mu.Unlock()
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = pool.Checkout(timeoutCtx, addr)
+	timeoutCancel()
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// It's handed over to the waiter once the response is received.
+	checkedOut := make(chan Conn)
+	go func() {
+		c, err := pool.Checkout(ctx, addr)
+		assert.NoError(t, err)
+		checkedOut <- c
+	}()
+	require.Eventually(t, func() bool { return pool.Stats(addr).Waiting == 1 }, 5*time.Second, time.Millisecond)
+	pool.traffic(c)
+	require.Equal(t, c, <-checkedOut)
+	require.Equal(t, PoolStats{Busy: 1}, pool.Stats(addr))
+}
+
+func testClientPoolHealthCheck(t *testing.T) {
+	upstream := startPoolUpstream(t, "127.0.0.1:0")
+	defer upstream.ln.Close()
+	addr := upstream.ln.Addr().String()
+
+	ev := &poolClient{responses: make(chan string, 16)}
+	pool, err := NewClientPool(ev, PoolOptions{
+		MinConns:            1,
+		MinBackoff:          10 * time.Millisecond,
+		HealthCheckInterval: 20 * time.Millisecond,
+		HealthProbe:         []byte("PING"),
+	})
+	require.NoError(t, err)
+	require.NoError(t, pool.Start())
+	defer pool.Stop() //nolint:errcheck
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := pool.Checkout(ctx, addr)
+	require.NoError(t, err)
+	pool.Return(c)
+
+	// The healthy connection responds to the probes and stays in the pool.
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&upstream.probes) >= 5 }, 5*time.Second, 10*time.Millisecond)
+	require.EqualValues(t, 1, atomic.LoadInt32(&upstream.accepted))
+
+	// The connection that stops responding is closed and redialed.
+	atomic.StoreInt32(&upstream.muted, 1)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&upstream.accepted) >= 2 }, 5*time.Second, 10*time.Millisecond)
+}
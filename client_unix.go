@@ -21,6 +21,9 @@ import (
 	"context"
 	"errors"
 	"net"
+	"net/netip"
+	"os"
+	"runtime"
 	"strconv"
 	"sync"
 	"syscall"
@@ -28,6 +31,7 @@ import (
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sys/unix"
 
+	"github.com/panjf2000/gnet/v2/internal/gfd"
 	"github.com/panjf2000/gnet/v2/internal/math"
 	"github.com/panjf2000/gnet/v2/internal/netpoll"
 	"github.com/panjf2000/gnet/v2/internal/queue"
@@ -40,10 +44,14 @@ import (
 // Client of gnet.
 type Client struct {
 	opts *Options
-	el   *eventloop
+	eng  *engine
 }
 
 // NewClient creates an instance of Client.
+//
+// The client runs one event-loop by default, it runs runtime.NumCPU() event-loops with
+// Options.Multicore or Options.NumEventLoop event-loops if it's specified, the connections
+// are spread across the event-loops in terms of Options.LB.
 func NewClient(eh EventHandler, opts ...Option) (cli *Client, err error) {
 	options := loadOptions(opts...)
 	cli = new(Client)
@@ -61,32 +69,6 @@ func NewClient(eh EventHandler, opts ...Option) (cli *Client, err error) {
 	}
 	logging.SetDefaultLoggerAndFlusher(logger, logFlusher)
 
-	var p *netpoll.Poller
-	if p, err = netpoll.OpenPoller(); err != nil {
-		return
-	}
-
-	shutdownCtx, shutdown := context.WithCancel(context.Background())
-	eng := engine{
-		listeners:    make(map[int]*listener),
-		opts:         options,
-		eventHandler: eh,
-		workerPool: struct {
-			*errgroup.Group
-			shutdownCtx context.Context
-			shutdown    context.CancelFunc
-			once        sync.Once
-		}{&errgroup.Group{}, shutdownCtx, shutdown, sync.Once{}},
-	}
-	if options.Ticker {
-		eng.ticker.ctx, eng.ticker.cancel = context.WithCancel(context.Background())
-	}
-	el := eventloop{
-		listeners: eng.listeners,
-		engine:    &eng,
-		poller:    p,
-	}
-
 	rbc := options.ReadBufferCap
 	switch {
 	case rbc <= 0:
@@ -106,52 +88,130 @@ func NewClient(eh EventHandler, opts ...Option) (cli *Client, err error) {
 		options.WriteBufferCap = math.CeilToPowerOfTwo(wbc)
 	}
 
-	el.buffer = make([]byte, options.ReadBufferCap)
-	el.connections.init()
-	el.eventHandler = eh
-	cli.el = &el
+	// Figure out the proper number of event-loop to run.
+	numEventLoop := 1
+	if options.Multicore {
+		numEventLoop = runtime.NumCPU()
+	}
+	if options.NumEventLoop > 0 {
+		numEventLoop = options.NumEventLoop
+	}
+	if numEventLoop > gfd.EventLoopIndexMax {
+		numEventLoop = gfd.EventLoopIndexMax
+	}
+
+	if options.TLSConfig != nil {
+		eh = newTLSEventHandler(eh, options, true)
+	}
+
+	shutdownCtx, shutdown := context.WithCancel(context.Background())
+	eng := &engine{
+		listeners:    make(map[int]*listener),
+		opts:         options,
+		eventHandler: eh,
+		workerPool: struct {
+			*errgroup.Group
+			shutdownCtx context.Context
+			shutdown    context.CancelFunc
+			once        sync.Once
+		}{&errgroup.Group{}, shutdownCtx, shutdown, sync.Once{}},
+	}
+	if options.TLSConfig == nil {
+		eng.tlsHandler = newTLSEventHandler(eh, options, true)
+	}
+	switch options.LB {
+	case RoundRobin:
+		eng.eventLoops = new(roundRobinLoadBalancer)
+	case LeastConnections:
+		eng.eventLoops = new(leastConnectionsLoadBalancer)
+	case SourceAddrHash:
+		eng.eventLoops = new(sourceAddrHashLoadBalancer)
+	}
+	if options.Ticker {
+		eng.ticker.ctx, eng.ticker.cancel = context.WithCancel(context.Background())
+	}
+
+	for i := 0; i < numEventLoop; i++ {
+		var p *netpoll.Poller
+		if p, err = openPoller(options); err != nil {
+			eng.closeEventLoops()
+			return nil, err
+		}
+		el := new(eventloop)
+		el.listeners = eng.listeners
+		el.engine = eng
+		el.poller = p
+		el.buffer = make([]byte, options.ReadBufferCap)
+		el.connections.init()
+		el.eventHandler = eh
+		eng.eventLoops.register(el)
+	}
+	cli.eng = eng
 	return
 }
 
-// Start starts the client event-loop, handing IO events.
+// Start starts the client event-loops, handing IO events.
 func (cli *Client) Start() error {
-	logging.Infof("Starting gnet client with 1 event-loop")
-	cli.el.eventHandler.OnBoot(Engine{cli.el.engine})
-	cli.el.engine.workerPool.Go(cli.el.run)
+	logging.Infof("Starting gnet client with %d event-loops", cli.eng.eventLoops.len())
+	cli.eng.eventHandler.OnBoot(Engine{cli.eng})
+	cli.eng.eventLoops.iterate(func(_ int, el *eventloop) bool {
+		cli.eng.workerPool.Go(el.run)
+		return true
+	})
 	// Start the ticker.
 	if cli.opts.Ticker {
-		go cli.el.ticker(cli.el.engine.ticker.ctx)
+		go cli.eng.eventLoops.index(0).ticker(cli.eng.ticker.ctx)
 	}
 	logging.Debugf("default logging level is %s", logging.LogLevel())
 	return nil
 }
 
-// Stop stops the client event-loop.
+// Stop stops the client event-loops.
 func (cli *Client) Stop() (err error) {
-	logging.Error(cli.el.poller.Trigger(queue.HighPriority, func(_ interface{}) error { return errorx.ErrEngineShutdown }, nil))
+	cli.eng.eventLoops.iterate(func(_ int, el *eventloop) bool {
+		logging.Error(el.poller.Trigger(queue.HighPriority, func(_ interface{}) error { return errorx.ErrEngineShutdown }, nil))
+		return true
+	})
 	// Stop the ticker.
 	if cli.opts.Ticker {
-		cli.el.engine.ticker.cancel()
+		cli.eng.ticker.cancel()
 	}
-	_ = cli.el.engine.workerPool.Wait()
-	logging.Error(cli.el.poller.Close())
-	cli.el.eventHandler.OnShutdown(Engine{cli.el.engine})
+	_ = cli.eng.workerPool.Wait()
+	cli.eng.closeEventLoops()
+	cli.eng.offload.release()
+	cli.eng.tlsOffload.release()
+	cli.eng.eventHandler.OnShutdown(Engine{cli.eng})
 	logging.Cleanup()
 	return
 }
 
+// CountConnections returns the number of active connections of each event-loop in the client,
+// indexed by the event-loops.
+func (cli *Client) CountConnections() []int {
+	counts := make([]int, cli.eng.eventLoops.len())
+	cli.eng.eventLoops.iterate(func(i int, el *eventloop) bool {
+		counts[i] = int(el.countConn())
+		return true
+	})
+	return counts
+}
+
 // Dial is like net.Dial().
 func (cli *Client) Dial(network, address string) (Conn, error) {
 	return cli.DialContext(network, address, nil)
 }
 
 // DialContext is like Dial but also accepts an empty interface ctx that can be obtained later via Conn.Context.
+//
+// With Options.TLSConfig, the returned Conn encrypts the data written to it, OnOpen fires
+// after the TLS handshake has completed, the host in address is used to verify the server
+// if the ServerName in the TLS config is empty.
 func (cli *Client) DialContext(network, address string, ctx interface{}) (Conn, error) {
 	c, err := net.Dial(network, address)
 	if err != nil {
 		return nil, err
 	}
-	return cli.EnrollContext(c, ctx)
+	return cli.enroll(c, ctx, tlsServerName(cli.opts.TLSConfig, "", address))
 }
 
 // Enroll converts a net.Conn to gnet.Conn and then adds it into Client.
@@ -161,6 +221,10 @@ func (cli *Client) Enroll(c net.Conn) (Conn, error) {
 
 // EnrollContext is like Enroll but also accepts an empty interface ctx that can be obtained later via Conn.Context.
 func (cli *Client) EnrollContext(c net.Conn, ctx interface{}) (Conn, error) {
+	return cli.enroll(c, ctx, "")
+}
+
+func (cli *Client) enroll(c net.Conn, ctx interface{}, serverName string) (Conn, error) {
 	defer c.Close()
 
 	sc, ok := c.(syscall.Conn)
@@ -198,14 +262,19 @@ func (cli *Client) EnrollContext(c net.Conn, ctx interface{}) (Conn, error) {
 		sockAddr unix.Sockaddr
 		gc       *conn
 	)
+	el := cli.eng.eventLoops.next(c.RemoteAddr())
 	switch c.(type) {
 	case *net.UnixConn:
 		if sockAddr, _, _, err = socket.GetUnixSockAddr(c.RemoteAddr().Network(), c.RemoteAddr().String()); err != nil {
 			return nil, err
 		}
+		if c.RemoteAddr().Network() == "unixgram" {
+			gc = newUDPConn(dupFD, el, c.LocalAddr(), sockAddr, true)
+			break
+		}
 		ua := c.LocalAddr().(*net.UnixAddr)
 		ua.Name = c.RemoteAddr().String() + "." + strconv.Itoa(dupFD)
-		gc = newTCPConn(dupFD, cli.el, sockAddr, c.LocalAddr(), c.RemoteAddr())
+		gc = newTCPConn(dupFD, el, sockAddr, c.LocalAddr(), c.RemoteAddr())
 	case *net.TCPConn:
 		if cli.opts.TCPNoDelay == TCPDelay {
 			if err = socket.SetNoDelay(dupFD, 0); err != nil {
@@ -220,27 +289,236 @@ func (cli *Client) EnrollContext(c net.Conn, ctx interface{}) (Conn, error) {
 		if sockAddr, _, _, _, err = socket.GetTCPSockAddr(c.RemoteAddr().Network(), c.RemoteAddr().String()); err != nil {
 			return nil, err
 		}
-		gc = newTCPConn(dupFD, cli.el, sockAddr, c.LocalAddr(), c.RemoteAddr())
+		gc = newTCPConn(dupFD, el, sockAddr, c.LocalAddr(), c.RemoteAddr())
 	case *net.UDPConn:
 		if sockAddr, _, _, _, err = socket.GetUDPSockAddr(c.RemoteAddr().Network(), c.RemoteAddr().String()); err != nil {
 			return nil, err
 		}
-		gc = newUDPConn(dupFD, cli.el, c.LocalAddr(), sockAddr, true)
+		gc = newUDPConn(dupFD, el, c.LocalAddr(), sockAddr, true)
 	default:
 		return nil, errorx.ErrUnsupportedProtocol
 	}
 	gc.ctx = ctx
+	gc.serverName = serverName
 
+	var uc Conn
 	connOpened := make(chan struct{})
 	ccb := &connWithCallback{c: gc, cb: func() {
+		uc = gc.upgraded()
 		close(connOpened)
 	}}
-	err = cli.el.poller.Trigger(queue.HighPriority, cli.el.register, ccb)
+	err = el.poller.Trigger(queue.HighPriority, el.register, ccb)
 	if err != nil {
 		gc.Close()
 		return nil, err
 	}
 
 	<-connOpened
-	return gc, nil
+	return uc, nil
+}
+
+// DialWithContext is like DialContext, but it dials in a non-blocking way: it issues
+// the connect on a non-blocking socket and returns right away, then the socket is
+// watched for writability in the client event-loop, where OnOpen fires after the
+// connect has completed successfully, and the outcome of the dial is passed to
+// opts.Callback eventually. This makes it possible to dial a large number of remotes
+// concurrently without one goroutine per dial.
+//
+// The host name in address is resolved before DialWithContext returns, only the connect
+// is asynchronous, the resolution is aborted as well if ctx is done or opts.Timeout elapses.
+// The dial fails if ctx is done or opts.Timeout elapses before the connect completes.
+// Only stream-oriented networks like "tcp" and "unix" are supported. On Windows, the dial
+// runs on a goroutine of its own instead.
+func (cli *Client) DialWithContext(ctx context.Context, network, address string, opts *DialOptions) error {
+	if opts == nil {
+		opts = &DialOptions{}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d := &dialer{callback: opts.Callback}
+	if opts.Timeout > 0 {
+		d.ctx, d.cancel = context.WithTimeout(ctx, opts.Timeout)
+	} else {
+		d.ctx, d.cancel = context.WithCancel(ctx)
+	}
+
+	var (
+		fd       int
+		sockOpts []socket.Option
+		addr     net.Addr
+		err      error
+	)
+	if cli.opts.SocketSendBuffer > 0 {
+		sockOpt := socket.Option{SetSockOpt: socket.SetSendBuffer, Opt: cli.opts.SocketSendBuffer}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	if cli.opts.SocketRecvBuffer > 0 {
+		sockOpt := socket.Option{SetSockOpt: socket.SetRecvBuffer, Opt: cli.opts.SocketRecvBuffer}
+		sockOpts = append(sockOpts, sockOpt)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		if cli.opts.TCPNoDelay == TCPNoDelay {
+			sockOpt := socket.Option{SetSockOpt: socket.SetNoDelay, Opt: 1}
+			sockOpts = append(sockOpts, sockOpt)
+		}
+		if cli.opts.TCPKeepAlive > 0 {
+			sockOpt := socket.Option{SetSockOpt: socket.SetKeepAlivePeriod, Opt: int(cli.opts.TCPKeepAlive.Seconds())}
+			sockOpts = append(sockOpts, sockOpt)
+		}
+		var raddr string
+		if raddr, err = resolveTCPAddr(d.ctx, network, address); err == nil {
+			fd, addr, err = socket.TCPSocket(network, raddr, false, sockOpts...)
+		}
+	case "unix":
+		fd, addr, err = socket.UnixSocket(network, address, false, sockOpts...)
+	default:
+		err = errorx.ErrUnsupportedProtocol
+	}
+	if err != nil && !errors.Is(err, unix.EINPROGRESS) {
+		d.cancel()
+		return err
+	}
+
+	var localAddr net.Addr
+	if _, ok := addr.(*net.UnixAddr); ok {
+		localAddr = &net.UnixAddr{Name: address + "." + strconv.Itoa(fd), Net: network}
+	} else if sa, e := unix.Getsockname(fd); e == nil {
+		localAddr = socket.SockaddrToTCPOrUnixAddr(sa)
+	}
+	el := cli.eng.eventLoops.next(addr)
+	c := newTCPConn(fd, el, nil, localAddr, addr)
+	c.ctx = opts.Context
+	c.serverName = tlsServerName(cli.opts.TLSConfig, opts.ServerName, address)
+
+	d.c = c
+	if err = el.poller.Trigger(queue.HighPriority, el.dial, d); err != nil {
+		d.cancel()
+		_ = unix.Close(fd)
+		return err
+	}
+	return nil
+}
+
+// lookupNetIP looks up the IP addresses of a host name, which is stubbed in tests.
+var lookupNetIP = net.DefaultResolver.LookupNetIP
+
+// resolveTCPAddr resolves the host name in address with ctx, the IPv4 address is preferred for "tcp"
+// like net.ResolveTCPAddr does, address is returned as it is if the host is an IP address or empty.
+func resolveTCPAddr(ctx context.Context, network, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if _, err = netip.ParseAddr(host); host == "" || err == nil {
+		return address, nil
+	}
+	ipNetwork := "ip"
+	switch network {
+	case "tcp4":
+		ipNetwork = "ip4"
+	case "tcp6":
+		ipNetwork = "ip6"
+	}
+	ips, err := lookupNetIP(ctx, ipNetwork, host)
+	if err != nil {
+		return "", err
+	}
+	ip := ips[0]
+	for _, a := range ips {
+		if a.Unmap().Is4() {
+			ip = a.Unmap()
+			break
+		}
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// dialer is a connection being dialed by Client.DialWithContext.
+type dialer struct {
+	c        *conn
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     func() bool // stops the abortion of the dial when ctx is done
+	callback AsyncCallback
+}
+
+// dial watches the connection being dialed for writability, which signals the completion of the connect.
+func (el *eventloop) dial(itf interface{}) error {
+	d := itf.(*dialer)
+	c := d.c
+	if err := d.ctx.Err(); err != nil {
+		_ = unix.Close(c.fd)
+		return el.dialed(d, nil, err)
+	}
+	c.pollAttachment.Callback = func(int, netpoll.IOEvent, netpoll.IOFlags) error {
+		return el.connected(d)
+	}
+	if err := el.poller.AddWrite(&c.pollAttachment, false); err != nil {
+		_ = unix.Close(c.fd)
+		return el.dialed(d, nil, err)
+	}
+	if el.dialing == nil {
+		el.dialing = make(map[int]*dialer)
+	}
+	el.dialing[c.fd] = d
+	d.stop = context.AfterFunc(d.ctx, func() {
+		_ = el.poller.Trigger(queue.HighPriority, el.abortDial, d)
+	})
+	return nil
+}
+
+// connected checks the result of the connect and opens the connection if it succeeded.
+func (el *eventloop) connected(d *dialer) error {
+	c := d.c
+	delete(el.dialing, c.fd)
+	d.stop()
+
+	errno, err := unix.GetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil {
+		err = os.NewSyscallError("getsockopt", err)
+	} else if errno != 0 {
+		err = os.NewSyscallError("connect", unix.Errno(errno))
+	}
+	if err == nil {
+		err = c.unwatchConnect()
+	}
+	if err != nil {
+		_ = unix.Close(c.fd)
+		return el.dialed(d, nil, err)
+	}
+
+	c.pollAttachment.Callback = c.processIO
+	if err = el.register0(c); err != nil {
+		_ = el.dialed(d, nil, err)
+		return err
+	}
+	return el.dialed(d, c, nil)
+}
+
+// abortDial gives up the dial when its context is done before the connect completes.
+func (el *eventloop) abortDial(itf interface{}) error {
+	d := itf.(*dialer)
+	if el.dialing[d.c.fd] != d {
+		return nil // the connect has completed
+	}
+	delete(el.dialing, d.c.fd)
+	_ = unix.Close(d.c.fd)
+	return el.dialed(d, nil, d.ctx.Err())
+}
+
+// dialed releases the resources of the dial and reports its outcome to the caller.
+func (el *eventloop) dialed(d *dialer, c *conn, err error) error {
+	d.cancel()
+	if d.callback == nil {
+		return nil
+	}
+	if c == nil {
+		_ = d.callback(nil, err)
+	} else {
+		_ = d.callback(c.upgraded(), nil)
+	}
+	return nil
 }
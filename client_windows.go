@@ -19,6 +19,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sync"
 
 	"golang.org/x/sync/errgroup"
@@ -29,7 +30,7 @@ import (
 
 type Client struct {
 	opts *Options
-	el   *eventloop
+	eng  *engine
 }
 
 func NewClient(eh EventHandler, opts ...Option) (cli *Client, err error) {
@@ -48,6 +49,19 @@ func NewClient(eh EventHandler, opts ...Option) (cli *Client, err error) {
 	}
 	logging.SetDefaultLoggerAndFlusher(logger, logFlusher)
 
+	// Figure out the proper number of event-loops/goroutines to run.
+	numEventLoop := 1
+	if options.Multicore {
+		numEventLoop = runtime.NumCPU()
+	}
+	if options.NumEventLoop > 0 {
+		numEventLoop = options.NumEventLoop
+	}
+
+	if options.TLSConfig != nil {
+		eh = newTLSEventHandler(eh, options, true)
+	}
+
 	shutdownCtx, shutdown := context.WithCancel(context.Background())
 	eng := &engine{
 		listeners: []*listener{},
@@ -60,22 +74,39 @@ func NewClient(eh EventHandler, opts ...Option) (cli *Client, err error) {
 		}{&errgroup.Group{}, shutdownCtx, shutdown, sync.Once{}},
 		eventHandler: eh,
 	}
-	cli.el = &eventloop{
-		ch:           make(chan interface{}, 1024),
-		eng:          eng,
-		connections:  make(map[*conn]struct{}),
-		eventHandler: eh,
+	if options.TLSConfig == nil {
+		eng.tlsHandler = newTLSEventHandler(eh, options, true)
 	}
+	switch options.LB {
+	case RoundRobin:
+		eng.eventLoops = new(roundRobinLoadBalancer)
+	case LeastConnections:
+		eng.eventLoops = new(leastConnectionsLoadBalancer)
+	case SourceAddrHash:
+		eng.eventLoops = new(sourceAddrHashLoadBalancer)
+	}
+	for i := 0; i < numEventLoop; i++ {
+		eng.eventLoops.register(&eventloop{
+			ch:           make(chan interface{}, 1024),
+			eng:          eng,
+			connections:  make(map[*conn]struct{}),
+			eventHandler: eh,
+		})
+	}
+	cli.eng = eng
 	return
 }
 
 func (cli *Client) Start() error {
-	cli.el.eventHandler.OnBoot(Engine{cli.el.eng})
-	cli.el.eng.workerPool.Go(cli.el.run)
+	cli.eng.eventHandler.OnBoot(Engine{cli.eng})
+	cli.eng.eventLoops.iterate(func(_ int, el *eventloop) bool {
+		cli.eng.workerPool.Go(el.run)
+		return true
+	})
 	if cli.opts.Ticker {
-		cli.el.eng.ticker.ctx, cli.el.eng.ticker.cancel = context.WithCancel(context.Background())
-		cli.el.eng.workerPool.Go(func() error {
-			cli.el.ticker(cli.el.eng.ticker.ctx)
+		cli.eng.ticker.ctx, cli.eng.ticker.cancel = context.WithCancel(context.Background())
+		cli.eng.workerPool.Go(func() error {
+			cli.eng.eventLoops.index(0).ticker(cli.eng.ticker.ctx)
 			return nil
 		})
 	}
@@ -84,16 +115,25 @@ func (cli *Client) Start() error {
 }
 
 func (cli *Client) Stop() (err error) {
-	cli.el.ch <- errorx.ErrEngineShutdown
+	cli.eng.closeEventLoops()
 	if cli.opts.Ticker {
-		cli.el.eng.ticker.cancel()
+		cli.eng.ticker.cancel()
 	}
-	_ = cli.el.eng.workerPool.Wait()
-	cli.el.eventHandler.OnShutdown(Engine{cli.el.eng})
+	_ = cli.eng.workerPool.Wait()
+	cli.eng.eventHandler.OnShutdown(Engine{cli.eng})
 	logging.Cleanup()
 	return
 }
 
+func (cli *Client) CountConnections() []int {
+	counts := make([]int, cli.eng.eventLoops.len())
+	cli.eng.eventLoops.iterate(func(i int, el *eventloop) bool {
+		counts[i] = int(el.countConn())
+		return true
+	})
+	return counts
+}
+
 var (
 	mu           sync.RWMutex
 	unixAddrDirs = make(map[string]string)
@@ -139,7 +179,32 @@ func (cli *Client) DialContext(network, addr string, ctx interface{}) (Conn, err
 			return nil, err
 		}
 	}
-	return cli.EnrollContext(c, ctx)
+	return cli.enroll(c, ctx, tlsServerName(cli.opts.TLSConfig, "", addr))
+}
+
+// DialWithContext dials on a goroutine of its own on Windows, and passes the outcome to opts.Callback.
+func (cli *Client) DialWithContext(ctx context.Context, network, addr string, opts *DialOptions) error {
+	if opts == nil {
+		opts = &DialOptions{}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	go func() {
+		var (
+			gc  Conn
+			err error
+		)
+		d := net.Dialer{Timeout: opts.Timeout}
+		c, err := d.DialContext(ctx, network, addr)
+		if err == nil {
+			gc, err = cli.enroll(c, opts.Context, tlsServerName(cli.opts.TLSConfig, opts.ServerName, addr))
+		}
+		if opts.Callback != nil {
+			_ = opts.Callback(gc, err)
+		}
+	}()
+	return nil
 }
 
 func (cli *Client) Enroll(nc net.Conn) (gc Conn, err error) {
@@ -147,6 +212,12 @@ func (cli *Client) Enroll(nc net.Conn) (gc Conn, err error) {
 }
 
 func (cli *Client) EnrollContext(nc net.Conn, ctx interface{}) (gc Conn, err error) {
+	return cli.enroll(nc, ctx, "")
+}
+
+func (cli *Client) enroll(nc net.Conn, ctx interface{}, serverName string) (gc Conn, err error) {
+	el := cli.eng.eventLoops.next(nc.RemoteAddr())
+	var uc Conn
 	connOpened := make(chan struct{})
 	switch v := nc.(type) {
 	case *net.TCPConn:
@@ -164,9 +235,10 @@ func (cli *Client) EnrollContext(nc net.Conn, ctx interface{}) (gc Conn, err err
 			}
 		}
 
-		c := newTCPConn(nc, cli.el)
+		c := newTCPConn(nc, el)
 		c.SetContext(ctx)
-		cli.el.ch <- &openConn{c: c, cb: func() { close(connOpened) }}
+		c.serverName = serverName
+		el.ch <- &openConn{c: c, cb: func() { uc = c.upgraded(); close(connOpened) }}
 		go func(c *conn, tc net.Conn, el *eventloop) {
 			var buffer [0x10000]byte
 			for {
@@ -177,12 +249,13 @@ func (cli *Client) EnrollContext(nc net.Conn, ctx interface{}) (gc Conn, err err
 				}
 				el.ch <- packTCPConn(c, buffer[:n])
 			}
-		}(c, nc, cli.el)
+		}(c, nc, el)
 		gc = c
 	case *net.UnixConn:
-		c := newTCPConn(nc, cli.el)
+		c := newTCPConn(nc, el)
 		c.SetContext(ctx)
-		cli.el.ch <- &openConn{c: c, cb: func() { close(connOpened) }}
+		c.serverName = serverName
+		el.ch <- &openConn{c: c, cb: func() { uc = c.upgraded(); close(connOpened) }}
 		go func(c *conn, uc net.Conn, el *eventloop) {
 			var buffer [0x10000]byte
 			for {
@@ -199,13 +272,13 @@ func (cli *Client) EnrollContext(nc net.Conn, ctx interface{}) (gc Conn, err err
 				}
 				el.ch <- packTCPConn(c, buffer[:n])
 			}
-		}(c, nc, cli.el)
+		}(c, nc, el)
 		gc = c
 	case *net.UDPConn:
-		c := newUDPConn(cli.el, nil, nc.LocalAddr(), nc.RemoteAddr())
+		c := newUDPConn(el, nil, nc.LocalAddr(), nc.RemoteAddr())
 		c.SetContext(ctx)
 		c.rawConn = nc
-		cli.el.ch <- &openConn{c: c, isDatagram: true, cb: func() { close(connOpened) }}
+		el.ch <- &openConn{c: c, isDatagram: true, cb: func() { close(connOpened) }}
 		go func(uc net.Conn, el *eventloop) {
 			var buffer [0x10000]byte
 			for {
@@ -213,17 +286,20 @@ func (cli *Client) EnrollContext(nc net.Conn, ctx interface{}) (gc Conn, err err
 				if err != nil {
 					return
 				}
-				c := newUDPConn(cli.el, nil, uc.LocalAddr(), uc.RemoteAddr())
+				c := newUDPConn(el, nil, uc.LocalAddr(), uc.RemoteAddr())
 				c.SetContext(ctx)
 				c.rawConn = uc
 				el.ch <- packUDPConn(c, buffer[:n])
 			}
-		}(nc, cli.el)
+		}(nc, el)
 		gc = c
 	default:
 		return nil, errorx.ErrUnsupportedProtocol
 	}
 
 	<-connOpened
+	if uc != nil {
+		gc = uc
+	}
 	return
 }
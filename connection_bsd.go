@@ -56,3 +56,8 @@ func (c *conn) processIO(_ int, filter netpoll.IOEvent, flags netpoll.IOFlags) (
 	}
 	return
 }
+
+// unwatchConnect stops watching the connection for writability after its connect has completed.
+func (c *conn) unwatchConnect() error {
+	return c.loop.poller.ModRead(&c.pollAttachment, false)
+}
@@ -19,6 +19,7 @@ package gnet
 
 import (
 	"io"
+	"os"
 
 	"golang.org/x/sys/unix"
 
@@ -27,6 +28,25 @@ import (
 
 func (c *conn) processIO(_ int, ev netpoll.IOEvent, _ netpoll.IOFlags) error {
 	el := c.loop
+	// The completion notifications of the zero-copy sends are queued up on the error queue of
+	// the socket, which is reported by EPOLLERR as well, so we consume them ahead of anything else
+	// and then find out whether there is a real error.
+	if ev&unix.EPOLLERR != 0 && c.zcEnabled {
+		if err := c.completeZeroCopy(); err != nil {
+			return el.close(c, err)
+		}
+		soErr, err := unix.GetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_ERROR)
+		if err == nil && soErr != 0 {
+			c.outboundBuffer.Release()
+			return el.close(c, os.NewSyscallError("write", unix.Errno(soErr)))
+		}
+		if ev &^= unix.EPOLLERR; ev == 0 {
+			return nil
+		}
+	}
+	if c.relay != nil {
+		return c.relay.process(c, ev)
+	}
 	// First check for any unexpected non-IO events.
 	// For these events we just close the connection directly.
 	if ev&netpoll.ErrEvents != 0 && ev&unix.EPOLLIN == 0 && ev&unix.EPOLLOUT == 0 {
@@ -68,3 +88,8 @@ func (c *conn) processIO(_ int, ev netpoll.IOEvent, _ netpoll.IOFlags) error {
 	}
 	return nil
 }
+
+// unwatchConnect stops watching the connection for writability after its connect has completed.
+func (c *conn) unwatchConnect() error {
+	return c.loop.poller.Delete(c.fd)
+}
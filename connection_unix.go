@@ -18,6 +18,7 @@
 package gnet
 
 import (
+	"errors"
 	"io"
 	"net"
 	"os"
@@ -35,23 +36,47 @@ import (
 	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
 	bsPool "github.com/panjf2000/gnet/v2/pkg/pool/byteslice"
+	"github.com/panjf2000/gnet/v2/pkg/tls"
 )
 
 type conn struct {
-	fd             int                    // file descriptor
-	gfd            gfd.GFD                // gnet file descriptor
-	ctx            interface{}            // user-defined context
-	remote         unix.Sockaddr          // remote socket address
-	localAddr      net.Addr               // local addr
-	remoteAddr     net.Addr               // remote addr
-	loop           *eventloop             // connected event-loop
-	outboundBuffer elastic.Buffer         // buffer for data that is eligible to be sent to the remote
-	pollAttachment netpoll.PollAttachment // connection attachment for poller
-	inboundBuffer  elastic.RingBuffer     // buffer for leftover data from the remote
-	buffer         []byte                 // buffer for the latest bytes
-	isDatagram     bool                   // UDP protocol
-	opened         bool                   // connection opened event fired
-	isEOF          bool                   // whether the connection has reached EOF
+	fd             int                     // file descriptor
+	gfd            gfd.GFD                 // gnet file descriptor
+	ctx            interface{}             // user-defined context
+	remote         unix.Sockaddr           // remote socket address
+	localAddr      net.Addr                // local addr
+	remoteAddr     net.Addr                // remote addr
+	loop           *eventloop              // connected event-loop
+	outboundBuffer elastic.Buffer          // buffer for data that is eligible to be sent to the remote
+	pollAttachment netpoll.PollAttachment  // connection attachment for poller
+	inboundBuffer  elastic.RingBuffer      // buffer for leftover data from the remote
+	buffer         []byte                  // buffer for the latest bytes
+	pktInfo        []byte                  // packet-info control message for sending UDP replies from the local address
+	inboundFDs     []int                   // file descriptors received from the remote over SCM_RIGHTS
+	outboundFDs    []pendingFDs            // file descriptors to be sent along with the data in outbound buffer
+	outboundMsgs   []int                   // sizes of the messages in outbound buffer for SOCK_SEQPACKET
+	outboundWrites []*pendingWrite         // file regions and zero-copy buffers to be sent in between the data in outbound buffer
+	zcInflight     []*pendingWrite         // buffers sent with MSG_ZEROCOPY that the kernel hasn't been done with
+	zcSeq          uint32                  // sequence number of the next zero-copy send
+	relay          *relay                  // relay that forwards the data between this connection and another
+	ringRecv       uint64                  // ID of the multishot recv in io_uring
+	serverName     string                  // name of the server to verify with client-side TLS
+	isDatagram     bool                    // UDP protocol
+	isUnix         bool                    // Unix domain socket
+	isPacket       bool                    // Unix domain socket of SOCK_SEQPACKET
+	opened         bool                    // connection opened event fired
+	isEOF          bool                    // whether the connection has reached EOF
+	zcProbed       bool                    // whether it has tried to enable MSG_ZEROCOPY on the socket
+	zcEnabled      bool                    // MSG_ZEROCOPY is enabled on the socket
+	ktls           bool                    // the record layer of TLS is offloaded to the kernel
+	closeNotify    bool                    // close_notify is to be sent through kTLS after the outbound buffer is flushed
+	inRing         bool                    // connection is driven by io_uring of the event-loop
+	ringWriting    bool                    // outbound data is being written by io_uring
+	ringDirty      bool                    // outbound data is waiting for the next submission of io_uring
+	goSeq          uint64                  // sequence number of the next function passed to Go
+	goNext         uint64                  // sequence number of the next result of Go to be written
+	goDone         map[uint64]*offloadTask // results of Go that have finished ahead of their turns
+	tlsHandler     *tlsEventHandler        // handler of the TLS started by StartTLS, nil for the plaintext connection
 }
 
 func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr, localAddr, remoteAddr net.Addr) (c *conn) {
@@ -63,6 +88,10 @@ func newTCPConn(fd int, el *eventloop, sa unix.Sockaddr, localAddr, remoteAddr n
 		remoteAddr:     remoteAddr,
 		pollAttachment: netpoll.PollAttachment{FD: fd},
 	}
+	if ua, ok := localAddr.(*net.UnixAddr); ok {
+		c.isUnix = true
+		c.isPacket = ua.Net == "unixpacket"
+	}
 	c.pollAttachment.Callback = c.processIO
 	c.outboundBuffer.Reset(el.engine.opts.WriteBufferCap)
 	return
@@ -90,6 +119,23 @@ func (c *conn) release() {
 	c.isEOF = false
 	c.ctx = nil
 	c.buffer = nil
+	closeFDs(c.inboundFDs)
+	c.inboundFDs = nil
+	for _, pending := range c.outboundFDs {
+		closeFDs(pending.fds)
+	}
+	c.outboundFDs = nil
+	c.outboundMsgs = nil
+	c.outboundWrites = nil
+	c.zcInflight = nil
+	c.zcSeq = 0
+	c.zcProbed = false
+	c.zcEnabled = false
+	c.ktls = false
+	c.closeNotify = false
+	c.goSeq = 0
+	c.goNext = 0
+	c.goDone = nil
 	if addr, ok := c.localAddr.(*net.TCPAddr); ok && len(c.loop.listeners) == 0 && len(addr.Zone) > 0 {
 		bsPool.Put(bs.StringToBytes(addr.Zone))
 	}
@@ -107,7 +153,10 @@ func (c *conn) release() {
 	if !c.isDatagram {
 		c.remote = nil
 		c.inboundBuffer.Done()
-		c.outboundBuffer.Release()
+		// The outbound buffer being written by io_uring is released after the write completes.
+		if !c.ringWriting {
+			c.outboundBuffer.Release()
+		}
 	}
 }
 
@@ -115,12 +164,16 @@ func (c *conn) open(buf []byte) error {
 	if c.isDatagram && c.remote == nil {
 		return unix.Send(c.fd, buf, 0)
 	}
+	if c.inRing {
+		_, _ = c.outboundBuffer.Write(buf)
+		return c.loop.ring.write(c)
+	}
 
 	for {
 		n, err := unix.Write(c.fd, buf)
 		if err != nil {
 			if err == unix.EAGAIN {
-				_, _ = c.outboundBuffer.Write(buf)
+				_, _ = c.bufferOutbound(buf)
 				break
 			}
 			return err
@@ -137,12 +190,18 @@ func (c *conn) open(buf []byte) error {
 func (c *conn) write(data []byte) (n int, err error) {
 	isET := c.loop.engine.opts.EdgeTriggeredIO
 	n = len(data)
+	// The data is written by io_uring in a batch with the data of other connections.
+	if c.inRing {
+		_, _ = c.outboundBuffer.Write(data)
+		err = c.loop.ring.write(c)
+		return
+	}
 	// If there is pending data in outbound buffer,
 	// the current data ought to be appended to the
 	// outbound buffer for maintaining the sequence
 	// of network packets.
-	if !c.outboundBuffer.IsEmpty() {
-		_, _ = c.outboundBuffer.Write(data)
+	if c.outboundPending() {
+		_, _ = c.bufferOutbound(data)
 		if isET {
 			err = c.loop.write(c)
 		}
@@ -155,7 +214,7 @@ loop:
 		// A temporary error occurs, append the data to outbound buffer,
 		// writing it back to the remote in the next round for LT mode.
 		if err == unix.EAGAIN {
-			_, err = c.outboundBuffer.Write(data)
+			_, err = c.bufferOutbound(data)
 			if !isET {
 				err = c.loop.poller.ModReadWrite(&c.pollAttachment, false)
 			}
@@ -173,7 +232,7 @@ loop:
 	}
 	// Failed to send all data back to the remote, buffer the leftover data for the next round.
 	if len(data) > 0 {
-		_, _ = c.outboundBuffer.Write(data)
+		_, _ = c.bufferOutbound(data)
 		err = c.loop.poller.ModReadWrite(&c.pollAttachment, false)
 	}
 
@@ -186,13 +245,18 @@ func (c *conn) writev(bs [][]byte) (n int, err error) {
 	for _, b := range bs {
 		n += len(b)
 	}
+	if c.inRing {
+		_, _ = c.outboundBuffer.Writev(bs)
+		err = c.loop.ring.write(c)
+		return
+	}
 
 	// If there is pending data in outbound buffer,
 	// the current data ought to be appended to the
 	// outbound buffer for maintaining the sequence
 	// of network packets.
-	if !c.outboundBuffer.IsEmpty() {
-		_, _ = c.outboundBuffer.Writev(bs)
+	if c.outboundPending() {
+		_, _ = c.bufferOutboundv(bs)
 		if isET {
 			err = c.loop.write(c)
 		}
@@ -206,7 +270,7 @@ loop:
 		// A temporary error occurs, append the data to outbound buffer,
 		// writing it back to the remote in the next round for LT mode.
 		if err == unix.EAGAIN {
-			_, err = c.outboundBuffer.Writev(bs)
+			_, err = c.bufferOutboundv(bs)
 			if !isET {
 				err = c.loop.poller.ModReadWrite(&c.pollAttachment, false)
 			}
@@ -237,13 +301,416 @@ loop:
 
 	// Failed to send all data back to the remote, buffer the leftover data for the next round.
 	if remaining > 0 {
-		_, _ = c.outboundBuffer.Writev(bs)
+		_, _ = c.bufferOutboundv(bs)
 		err = c.loop.poller.ModReadWrite(&c.pollAttachment, false)
 	}
 
 	return
 }
 
+// pendingFDs represents the file descriptors waiting to be sent with the data in outbound buffer.
+type pendingFDs struct {
+	pos int   // number of bytes ahead of the data that carries fds in outbound buffer
+	fds []int // duplicated file descriptors, closed after they are sent
+}
+
+func closeFDs(fds []int) {
+	for _, fd := range fds {
+		_ = unix.Close(fd)
+	}
+}
+
+func (c *conn) writeWithFDs(data []byte, fds []int) (n int, err error) {
+	isET := c.loop.engine.opts.EdgeTriggeredIO
+	n = len(data)
+	// The file descriptors can't be sent until the pending data in
+	// outbound buffer has been sent for maintaining the sequence
+	// of network packets, queue up the copies of them.
+	if c.outboundPending() {
+		if err = c.queueFDs(data, fds); err != nil {
+			return 0, err
+		}
+		if isET {
+			err = c.loop.write(c)
+		}
+		return
+	}
+
+	sent, err := socket.SendmsgWithRights(c.fd, data, fds)
+	if err != nil {
+		if errors.Is(err, unix.EAGAIN) {
+			if err = c.queueFDs(data, fds); err != nil {
+				return 0, err
+			}
+			if !isET {
+				err = c.loop.poller.ModReadWrite(&c.pollAttachment, false)
+			}
+			return
+		}
+		if err := c.loop.close(c, err); err != nil {
+			logging.Errorf("failed to close connection(fd=%d,remote=%+v) on conn.writeWithFDs: %v",
+				c.fd, c.remoteAddr, err)
+		}
+		return 0, err
+	}
+	// The file descriptors have been sent along with the first byte,
+	// the leftover data can be written as usual.
+	if sent < len(data) {
+		_, err = c.write(data[sent:])
+	}
+	return
+}
+
+func (c *conn) queueFDs(data []byte, fds []int) error {
+	dups := make([]int, 0, len(fds))
+	for _, fd := range fds {
+		dupFD, err := socket.Dup(fd)
+		if err != nil {
+			closeFDs(dups)
+			return os.NewSyscallError("fcntl", err)
+		}
+		dups = append(dups, dupFD)
+	}
+	c.outboundFDs = append(c.outboundFDs, pendingFDs{pos: c.outboundBuffer.Buffered(), fds: dups})
+	_, _ = c.bufferOutbound(data)
+	return nil
+}
+
+// bufferOutbound appends data to the outbound buffer, the boundary of
+// the data is kept as a message for SOCK_SEQPACKET.
+func (c *conn) bufferOutbound(data []byte) (int, error) {
+	if c.isPacket && len(data) > 0 {
+		c.outboundMsgs = append(c.outboundMsgs, len(data))
+	}
+	return c.outboundBuffer.Write(data)
+}
+
+// bufferOutboundv appends bs to the outbound buffer, bs is kept as
+// a single message for SOCK_SEQPACKET just like writev(2) does.
+func (c *conn) bufferOutboundv(bs [][]byte) (int, error) {
+	if c.isPacket {
+		var size int
+		for _, b := range bs {
+			size += len(b)
+		}
+		if size > 0 {
+			c.outboundMsgs = append(c.outboundMsgs, size)
+		}
+	}
+	return c.outboundBuffer.Writev(bs)
+}
+
+// writeOutbound writes the data in outbound buffer when there are file descriptors or
+// files queued up or message boundaries to keep, the file descriptors are sent along with
+// the first byte of their data, the data stops at the next file, and each message is sent
+// by a separate system call.
+func (c *conn) writeOutbound(iov [][]byte) (n int, err error) {
+	size := -1
+	if len(c.outboundMsgs) > 0 {
+		size = c.outboundMsgs[0]
+	}
+	var fds []int
+	if len(c.outboundFDs) > 0 {
+		if pos := c.outboundFDs[0].pos; pos > 0 {
+			if size < 0 || pos < size {
+				size = pos
+			}
+		} else {
+			fds = c.outboundFDs[0].fds
+			if len(c.outboundFDs) > 1 && (size < 0 || c.outboundFDs[1].pos < size) {
+				size = c.outboundFDs[1].pos
+			}
+		}
+	}
+	if len(c.outboundWrites) > 0 {
+		if pos := c.outboundWrites[0].pos; size < 0 || pos < size {
+			size = pos
+		}
+	}
+	if size >= 0 {
+		iov = truncateIOV(iov, size)
+	}
+
+	if fds == nil {
+		n, err = gio.Writev(c.fd, iov)
+	} else if n, err = unix.SendmsgBuffers(c.fd, iov, unix.UnixRights(fds...), nil, 0); err == nil {
+		closeFDs(fds)
+		c.outboundFDs[0] = pendingFDs{}
+		c.outboundFDs = c.outboundFDs[1:]
+	}
+	// writev(2) returns -1 on failure.
+	if n < 0 {
+		n = 0
+	}
+	for i := range c.outboundFDs {
+		c.outboundFDs[i].pos -= n
+	}
+	if len(c.outboundFDs) == 0 {
+		c.outboundFDs = nil
+	}
+	for _, pw := range c.outboundWrites {
+		pw.pos -= n
+	}
+	for left := n; left > 0 && len(c.outboundMsgs) > 0; {
+		if left < c.outboundMsgs[0] {
+			c.outboundMsgs[0] -= left
+			break
+		}
+		left -= c.outboundMsgs[0]
+		c.outboundMsgs = c.outboundMsgs[1:]
+	}
+	if len(c.outboundMsgs) == 0 {
+		c.outboundMsgs = nil
+	}
+	return
+}
+
+// outboundPending reports whether there is any data, file or zero-copy buffer waiting to be sent.
+func (c *conn) outboundPending() bool {
+	return !c.outboundBuffer.IsEmpty() || len(c.outboundWrites) > 0
+}
+
+// maxSendFileSize is the maximum number of bytes sent by a single sendfile(2).
+const maxSendFileSize = 1 << 30
+
+// pendingWrite represents a file region or a user-owned buffer waiting to be sent
+// after the data ahead of it in outbound buffer.
+type pendingWrite struct {
+	pos      int      // number of bytes ahead of it in outbound buffer
+	file     *os.File // file to be sent, it's retained until the file region has been sent
+	fd       int      // file descriptor of file
+	buf      []byte   // buffer to be sent with MSG_ZEROCOPY, nil for a file region
+	offset   int64    // offset of the next byte to be sent
+	remain   int64    // number of bytes that have not been sent
+	seq      uint32   // sequence number of the first zero-copy send of buf
+	sends    uint32   // number of zero-copy sends of buf
+	acked    uint32   // number of zero-copy sends of buf that have been completed by the kernel
+	callback AsyncCallback
+}
+
+func (c *conn) asyncPendingWrite(itf interface{}) (err error) {
+	pw := itf.(*pendingWrite)
+	if !c.opened {
+		if pw.callback != nil {
+			_ = pw.callback(c, net.ErrClosed)
+		}
+		return net.ErrClosed
+	}
+
+	// It has to wait until the pending data in outbound buffer has been
+	// sent for maintaining the sequence of network packets.
+	pw.pos = c.outboundBuffer.Buffered()
+	c.outboundWrites = append(c.outboundWrites, pw)
+	if len(c.outboundWrites) > 1 || pw.pos > 0 {
+		return
+	}
+
+	switch err = c.sendPending(); err {
+	case nil:
+	case unix.EAGAIN:
+		err = nil
+		if !c.loop.engine.opts.EdgeTriggeredIO {
+			err = c.loop.poller.ModReadWrite(&c.pollAttachment, false)
+		}
+	default:
+		err = c.loop.close(c, err)
+	}
+	return
+}
+
+func (c *conn) asyncWriteZeroCopy(itf interface{}) error {
+	// Try to enable MSG_ZEROCOPY on the first use, the buffers are sent by
+	// regular writes if the socket doesn't support it.
+	if c.opened && !c.zcProbed {
+		c.zcProbed = true
+		c.zcEnabled = socket.SetZeroCopy(c.fd) == nil
+	}
+	return c.asyncPendingWrite(itf)
+}
+
+// sendPending sends the file region or the buffer at the head of the queue until all of it
+// has been sent, and invokes the callback after that, the callback of a buffer sent with
+// MSG_ZEROCOPY is deferred until the kernel is done with the buffer.
+func (c *conn) sendPending() (err error) {
+	pw := c.outboundWrites[0]
+	if pw.buf != nil {
+		err = c.sendZeroCopy(pw)
+	} else {
+		err = c.sendFile(pw)
+	}
+	if err != nil {
+		return
+	}
+
+	c.outboundWrites[0] = nil
+	c.outboundWrites = c.outboundWrites[1:]
+	if len(c.outboundWrites) == 0 {
+		c.outboundWrites = nil
+	}
+	if pw.acked < pw.sends {
+		return
+	}
+	if pw.sends > 0 {
+		// The kernel had been done with the buffer before the last bytes were sent by a regular write,
+		// it's the latest buffer that was put into the in-flight list.
+		c.zcInflight[len(c.zcInflight)-1] = nil
+		c.zcInflight = c.zcInflight[:len(c.zcInflight)-1]
+	}
+	if pw.callback != nil {
+		_ = pw.callback(c, nil)
+	}
+	return
+}
+
+// sendFile sends the file region with sendfile(2).
+func (c *conn) sendFile(pw *pendingWrite) error {
+	for pw.remain > 0 {
+		size := pw.remain
+		if size > maxSendFileSize {
+			size = maxSendFileSize
+		}
+		// Some platforms don't advance the offset, keep track of it by ourselves.
+		offset := pw.offset
+		n, err := unix.Sendfile(c.fd, pw.fd, &offset, int(size))
+		if n > 0 {
+			pw.offset += int64(n)
+			pw.remain -= int64(n)
+		}
+		switch {
+		case err == unix.EINTR:
+		case err == unix.EAGAIN:
+			return err
+		case err != nil:
+			return os.NewSyscallError("sendfile", err)
+		case n == 0 && pw.remain > 0:
+			// The file is shorter than the region that was requested.
+			return io.ErrUnexpectedEOF
+		}
+	}
+	return nil
+}
+
+// sendZeroCopy sends the buffer with MSG_ZEROCOPY when it's enabled on the socket,
+// a regular write is used instead if it's not or the kernel fails to pin more pages
+// for the socket.
+func (c *conn) sendZeroCopy(pw *pendingWrite) error {
+	for pw.remain > 0 {
+		var (
+			n   int
+			err error
+		)
+		b := pw.buf[pw.offset:]
+		if c.zcEnabled {
+			if n, err = socket.SendZeroCopy(c.fd, b); err == unix.ENOBUFS {
+				n, err = unix.Write(c.fd, b)
+			} else if n > 0 {
+				if pw.sends == 0 {
+					// Track the buffer from the first send since the completions may
+					// arrive before the whole buffer has been sent.
+					pw.seq = c.zcSeq
+					c.zcInflight = append(c.zcInflight, pw)
+				}
+				pw.sends++
+				c.zcSeq++
+			}
+		} else {
+			n, err = unix.Write(c.fd, b)
+		}
+		if n > 0 {
+			pw.offset += int64(n)
+			pw.remain -= int64(n)
+		}
+		switch err {
+		case nil, unix.EINTR:
+		case unix.EAGAIN:
+			return err
+		default:
+			return os.NewSyscallError("write", err)
+		}
+	}
+	return nil
+}
+
+// completeZeroCopy reads the completion notifications of the zero-copy sends from the
+// error queue of the socket, and invokes the callbacks of the buffers that the kernel
+// is done with.
+func (c *conn) completeZeroCopy() error {
+	var done []*pendingWrite
+	err := socket.ReadZeroCopyCompletions(c.fd, func(lo, hi uint32) {
+		inflight := c.zcInflight[:0]
+		for _, pw := range c.zcInflight {
+			if pw.ack(lo, hi); pw.acked < pw.sends || pw.remain > 0 {
+				inflight = append(inflight, pw)
+			} else {
+				done = append(done, pw)
+			}
+		}
+		for i := len(inflight); i < len(c.zcInflight); i++ {
+			c.zcInflight[i] = nil
+		}
+		c.zcInflight = inflight
+	})
+	for _, pw := range done {
+		if pw.callback != nil {
+			_ = pw.callback(c, nil)
+		}
+	}
+	return err
+}
+
+// ack counts the zero-copy sends of the buffer within the range [lo, hi] of sequence
+// numbers, which may wrap around.
+func (pw *pendingWrite) ack(lo, hi uint32) {
+	// Make the sequence numbers relative to the first send of the buffer.
+	n := pw.sends
+	lo, hi = lo-pw.seq, hi-pw.seq
+	if lo <= hi {
+		if lo < n {
+			pw.acked += min(hi, n-1) - lo + 1
+		}
+		return
+	}
+	// The range covers the first send: [lo, 1<<32) and [0, hi].
+	pw.acked += min(hi, n-1) + 1
+	if lo < n {
+		pw.acked += n - lo
+	}
+}
+
+// abortWrites invokes the callbacks of the file regions and buffers that will never be sent,
+// as well as the buffers sent with MSG_ZEROCOPY that the kernel may still be using.
+func (c *conn) abortWrites(err error) {
+	if err == nil {
+		err = net.ErrClosed
+	}
+	writes := c.zcInflight
+	if len(c.outboundWrites) > 0 {
+		// The buffer at the head of the queue may have been in flight already.
+		if head := c.outboundWrites[0]; head.sends > 0 {
+			writes = writes[:len(writes)-1]
+		}
+		writes = append(writes, c.outboundWrites...)
+	}
+	c.zcInflight = nil
+	c.outboundWrites = nil
+	for _, pw := range writes {
+		if pw.callback != nil {
+			_ = pw.callback(c, err)
+		}
+	}
+}
+
+func truncateIOV(iov [][]byte, size int) [][]byte {
+	for i, b := range iov {
+		if len(b) >= size {
+			iov[i] = b[:size]
+			return iov[:i+1]
+		}
+		size -= len(b)
+	}
+	return iov
+}
+
 type asyncWriteHook struct {
 	callback AsyncCallback
 	data     []byte
@@ -286,10 +753,26 @@ func (c *conn) asyncWritev(itf interface{}) (err error) {
 	return
 }
 
+// runInLoop runs fn in the event-loop that owns the connection, fn gets net.ErrClosed if the
+// connection has been closed by then. It's meant for the connections layered on top of conn,
+// e.g. tlsConn, which must not touch conn outside the event-loop.
+func (c *conn) runInLoop(fn func(err error) error) error {
+	return c.loop.poller.Trigger(queue.HighPriority, func(_ interface{}) error {
+		if !c.opened {
+			return fn(net.ErrClosed)
+		}
+		return fn(nil)
+	}, nil)
+}
+
 func (c *conn) sendTo(buf []byte) error {
 	if c.remote == nil {
 		return unix.Send(c.fd, buf, 0)
 	}
+	if c.pktInfo != nil {
+		_, err := unix.SendmsgN(c.fd, buf, c.pktInfo, c.remote, 0)
+		return err
+	}
 	return unix.Sendto(c.fd, buf, 0, c.remote)
 }
 
@@ -405,6 +888,24 @@ func (c *conn) Write(p []byte) (int, error) {
 	return c.write(p)
 }
 
+func (c *conn) WriteWithFDs(p []byte, fds []int) (int, error) {
+	if !c.isUnix {
+		return 0, errorx.ErrUnsupportedOp
+	}
+	if len(fds) == 0 {
+		return c.write(p)
+	}
+	if len(p) == 0 {
+		return 0, errorx.ErrFDsWithoutData
+	}
+	return c.writeWithFDs(p, fds)
+}
+
+func (c *conn) ReadFDs() (fds []int) {
+	fds, c.inboundFDs = c.inboundFDs, nil
+	return
+}
+
 func (c *conn) Writev(bs [][]byte) (int, error) {
 	if c.isDatagram {
 		return 0, errorx.ErrUnsupportedOp
@@ -464,6 +965,13 @@ func (c *conn) SetNoDelay(noDelay bool) error {
 	}(noDelay))
 }
 
+func (c *conn) PeerCred() (pid, uid, gid int, err error) {
+	if !c.isUnix {
+		return -1, -1, -1, errorx.ErrUnsupportedOp
+	}
+	return socket.GetPeerCred(c.fd)
+}
+
 func (c *conn) SetKeepAlivePeriod(d time.Duration) error {
 	return socket.SetKeepAlivePeriod(c.fd, int(d.Seconds()))
 }
@@ -490,6 +998,32 @@ func (c *conn) AsyncWritev(bs [][]byte, callback AsyncCallback) error {
 	return c.loop.poller.Trigger(queue.HighPriority, c.asyncWritev, &asyncWritevHook{callback, bs})
 }
 
+func (c *conn) SendFile(f *os.File, offset, n int64, callback AsyncCallback) error {
+	if c.isDatagram || c.isPacket || c.inRing {
+		return errorx.ErrUnsupportedOp
+	}
+	if n <= 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		n = fi.Size() - offset
+	}
+	if offset < 0 || n < 0 {
+		return errorx.ErrInvalidFileRegion
+	}
+	pw := &pendingWrite{file: f, fd: int(f.Fd()), offset: offset, remain: n, callback: callback}
+	return c.loop.poller.Trigger(queue.HighPriority, c.asyncPendingWrite, pw)
+}
+
+func (c *conn) AsyncWriteZeroCopy(buf []byte, callback AsyncCallback) error {
+	if c.isDatagram || c.isPacket || c.inRing {
+		return errorx.ErrUnsupportedOp
+	}
+	pw := &pendingWrite{buf: buf, remain: int64(len(buf)), callback: callback}
+	return c.loop.poller.Trigger(queue.HighPriority, c.asyncWriteZeroCopy, pw)
+}
+
 func (c *conn) Wake(callback AsyncCallback) error {
 	return c.loop.poller.Trigger(queue.LowPriority, func(_ interface{}) (err error) {
 		err = c.loop.wake(c)
@@ -510,6 +1044,13 @@ func (c *conn) CloseWithCallback(callback AsyncCallback) error {
 	}, nil)
 }
 
+func (c *conn) StartTLS(config *tls.Config) error {
+	if c.isDatagram {
+		return errorx.ErrUnsupportedOp
+	}
+	return c.loop.engine.tlsHandler.startTLS(c, config)
+}
+
 func (c *conn) Close() error {
 	return c.loop.poller.Trigger(queue.LowPriority, func(_ interface{}) (err error) {
 		err = c.loop.close(c, nil)
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"os"
 	"syscall"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 	"github.com/panjf2000/gnet/v2/pkg/buffer/elastic"
 	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
 	bbPool "github.com/panjf2000/gnet/v2/pkg/pool/bytebuffer"
+	"github.com/panjf2000/gnet/v2/pkg/tls"
 )
 
 type netErr struct {
@@ -51,12 +53,14 @@ type openConn struct {
 type conn struct {
 	pc            net.PacketConn
 	ctx           interface{}        // user-defined context
+	serverName    string             // name of the server to verify with client-side TLS
 	loop          *eventloop         // owner event-loop
 	buffer        *bbPool.ByteBuffer // reuse memory of inbound data as a temporary buffer
 	rawConn       net.Conn           // original connection
 	localAddr     net.Addr           // local server addr
 	remoteAddr    net.Addr           // remote addr
 	inboundBuffer elastic.RingBuffer // buffer for data from the remote
+	tlsHandler    *tlsEventHandler   // handler of the TLS started by StartTLS, nil for the plaintext connection
 }
 
 func packTCPConn(c *conn, buf []byte) *tcpConn {
@@ -250,6 +254,14 @@ func (c *conn) Write(p []byte) (int, error) {
 	return c.pc.WriteTo(p, c.remoteAddr)
 }
 
+func (c *conn) WriteWithFDs(_ []byte, _ []int) (int, error) {
+	return 0, errorx.ErrUnsupportedOp
+}
+
+func (c *conn) ReadFDs() []int {
+	return nil
+}
+
 func (c *conn) Writev(bs [][]byte) (int, error) {
 	if c.rawConn != nil {
 		bb := bbPool.Get()
@@ -411,6 +423,10 @@ func (c *conn) SetNoDelay(noDelay bool) error {
 	return tc.SetNoDelay(noDelay)
 }
 
+func (c *conn) PeerCred() (pid, uid, gid int, err error) {
+	return -1, -1, -1, errorx.ErrUnsupportedOp
+}
+
 func (c *conn) SetKeepAlivePeriod(d time.Duration) error {
 	if c.rawConn == nil {
 		return net.ErrClosed
@@ -460,6 +476,40 @@ func (c *conn) AsyncWritev(bs [][]byte, cb AsyncCallback) error {
 	})
 }
 
+func (c *conn) SendFile(_ *os.File, _, _ int64, _ AsyncCallback) error {
+	return errorx.ErrUnsupportedOp
+}
+
+func (c *conn) AsyncWriteZeroCopy(_ []byte, _ AsyncCallback) error {
+	return errorx.ErrUnsupportedOp
+}
+
+func (c *conn) Go(_ func() []byte) error {
+	return errorx.ErrUnsupportedOp
+}
+
+func (c *conn) offload(_ func() []byte, _ func([]byte) (int, error)) error {
+	return errorx.ErrUnsupportedOp
+}
+
+// offloadHandshake runs the steps of TLS handshakes in the event-loop as offloading isn't supported on Windows.
+func (c *conn) offloadHandshake(step func(), _ func() Action) {
+	step()
+}
+
+// runInLoop runs fn in the event-loop that owns the connection, fn gets net.ErrClosed if the
+// connection has been closed by then. It's meant for the connections layered on top of conn,
+// e.g. tlsConn, which must not touch conn outside the event-loop.
+func (c *conn) runInLoop(fn func(err error) error) error {
+	c.loop.ch <- func() error {
+		if c.rawConn == nil {
+			return fn(net.ErrClosed)
+		}
+		return fn(nil)
+	}
+	return nil
+}
+
 func (c *conn) Wake(cb AsyncCallback) error {
 	if cb == nil {
 		cb = func(c Conn, err error) error { return nil }
@@ -479,6 +529,13 @@ func (c *conn) Wake(cb AsyncCallback) error {
 	return nil
 }
 
+func (c *conn) StartTLS(config *tls.Config) error {
+	if c.pc != nil {
+		return errorx.ErrUnsupportedOp
+	}
+	return c.loop.eng.tlsHandler.startTLS(c, config)
+}
+
 func (c *conn) Close() error {
 	c.loop.ch <- func() error {
 		err := c.loop.close(c, nil)
@@ -19,16 +19,18 @@ package gnet
 
 import (
 	"context"
+	"net"
 	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/panjf2000/gnet/v2/internal/gfd"
-	"github.com/panjf2000/gnet/v2/internal/netpoll"
 	"github.com/panjf2000/gnet/v2/internal/queue"
+	"github.com/panjf2000/gnet/v2/internal/socket"
 	"github.com/panjf2000/gnet/v2/pkg/errors"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
 )
@@ -50,7 +52,12 @@ type engine struct {
 		shutdown    context.CancelFunc
 		once        sync.Once
 	}
-	eventHandler EventHandler // user eventHandler
+	offload      offloader        // goroutine pool for Conn.Go
+	tlsOffload   offloader        // goroutine pool for the offloaded steps of TLS handshakes
+	tlsHandler   *tlsEventHandler // handler of the connections upgraded by Conn.StartTLS
+	eventHandler EventHandler     // user eventHandler
+	// socketBusyPoll is the value of SO_BUSY_POLL in microseconds set on the accepted TCP sockets, 0 if it's disabled.
+	socketBusyPoll int
 }
 
 func (eng *engine) isInShutdown() bool {
@@ -70,6 +77,9 @@ func (eng *engine) shutdown(err error) {
 
 func (eng *engine) closeEventLoops() {
 	eng.eventLoops.iterate(func(_ int, el *eventloop) bool {
+		if el.ring != nil {
+			el.ring.close()
+		}
 		for _, ln := range el.listeners {
 			ln.close()
 		}
@@ -77,6 +87,9 @@ func (eng *engine) closeEventLoops() {
 		return true
 	})
 	if eng.ingress != nil {
+		if eng.ingress.ring != nil {
+			eng.ingress.ring.close()
+		}
 		for _, ln := range eng.listeners {
 			ln.close()
 		}
@@ -95,6 +108,11 @@ func (eng *engine) runEventLoops(numEventLoop int) error {
 		if i > 0 {
 			lns = make(map[int]*listener, len(eng.listeners))
 			for _, l := range eng.listeners {
+				// Unix domain sockets can't be bound to the same address more than once,
+				// leave them to the first event-loop.
+				if strings.HasPrefix(l.network, "unix") {
+					continue
+				}
 				ln, err := initListener(l.network, l.address, eng.opts)
 				if err != nil {
 					return err
@@ -102,7 +120,7 @@ func (eng *engine) runEventLoops(numEventLoop int) error {
 				lns[ln.fd] = ln
 			}
 		}
-		p, err := netpoll.OpenPoller()
+		p, err := openPoller(eng.opts)
 		if err != nil {
 			return err
 		}
@@ -111,10 +129,17 @@ func (eng *engine) runEventLoops(numEventLoop int) error {
 		el.engine = eng
 		el.poller = p
 		el.buffer = make([]byte, eng.opts.ReadBufferCap)
+		el.pktInfo = make([]byte, socket.PktInfoBufferSize)
 		el.connections.init()
 		el.eventHandler = eng.eventHandler
+		eng.openRing(el)
 		for _, ln := range lns {
-			if err = el.poller.AddRead(ln.packPollAttachment(el.accept), false); err != nil {
+			if el.ring.supports(ln.network) {
+				err = el.ring.accept(ln.fd)
+			} else {
+				err = el.poller.AddRead(ln.packPollAttachment(el.accept), false)
+			}
+			if err != nil {
 				return err
 			}
 		}
@@ -144,7 +169,7 @@ func (eng *engine) runEventLoops(numEventLoop int) error {
 
 func (eng *engine) activateReactors(numEventLoop int) error {
 	for i := 0; i < numEventLoop; i++ {
-		p, err := netpoll.OpenPoller()
+		p, err := openPoller(eng.opts)
 		if err != nil {
 			return err
 		}
@@ -153,8 +178,10 @@ func (eng *engine) activateReactors(numEventLoop int) error {
 		el.engine = eng
 		el.poller = p
 		el.buffer = make([]byte, eng.opts.ReadBufferCap)
+		el.pktInfo = make([]byte, socket.PktInfoBufferSize)
 		el.connections.init()
 		el.eventHandler = eng.eventHandler
+		eng.openRing(el)
 		eng.eventLoops.register(el)
 	}
 
@@ -164,7 +191,7 @@ func (eng *engine) activateReactors(numEventLoop int) error {
 		return true
 	})
 
-	p, err := netpoll.OpenPoller()
+	p, err := openPoller(eng.opts)
 	if err != nil {
 		return err
 	}
@@ -174,8 +201,14 @@ func (eng *engine) activateReactors(numEventLoop int) error {
 	el.engine = eng
 	el.poller = p
 	el.eventHandler = eng.eventHandler
+	eng.openRing(el)
 	for _, ln := range eng.listeners {
-		if err = el.poller.AddRead(ln.packPollAttachment(el.accept0), true); err != nil {
+		if el.ring.supports(ln.network) {
+			err = el.ring.accept(ln.fd)
+		} else {
+			err = el.poller.AddRead(ln.packPollAttachment(el.accept0), true)
+		}
+		if err != nil {
 			return err
 		}
 	}
@@ -195,6 +228,33 @@ func (eng *engine) activateReactors(numEventLoop int) error {
 	return nil
 }
 
+// openRing sets up io_uring for the event-loop if it's enabled, the event-loop
+// falls back to the poller when io_uring is unavailable.
+func (eng *engine) openRing(el *eventloop) {
+	if !eng.opts.IOURing {
+		return
+	}
+	if err := el.openRing(); err != nil {
+		eng.opts.Logger.Warnf("failed to set up io_uring, falling back to the poller: %v", err)
+	}
+}
+
+func (eng *engine) pollStats() (stats PollStats, err error) {
+	add := func(el *eventloop) {
+		busy, idle := el.poller.Stats()
+		stats.Busy += busy
+		stats.Idle += idle
+	}
+	eng.eventLoops.iterate(func(_ int, el *eventloop) bool {
+		add(el)
+		return true
+	})
+	if eng.ingress != nil {
+		add(eng.ingress)
+	}
+	return
+}
+
 func (eng *engine) start(numEventLoop int) error {
 	if eng.opts.ReusePort {
 		return eng.runEventLoops(numEventLoop)
@@ -233,11 +293,77 @@ func (eng *engine) stop(s Engine) {
 		eng.opts.Logger.Errorf("engine shutdown error: %v", err)
 	}
 
+	// Put the engine into the shutdown state before the pollers are closed and the goroutine
+	// pools are released, so that the functions finishing afterwards know the event-loops are gone.
+	atomic.StoreInt32(&eng.inShutdown, 1)
+
 	// Close all listeners and pollers of event-loops.
 	eng.closeEventLoops()
 
-	// Put the engine into the shutdown state.
-	atomic.StoreInt32(&eng.inShutdown, 1)
+	// Release the goroutine pools for Conn.Go and TLS handshakes.
+	eng.offload.release()
+	eng.tlsOffload.release()
+}
+
+// iterateUDPListeners calls f on every UDP listener of the engine, including the ones
+// created for each event-loop with SO_REUSEPORT, and returns the first error.
+func (eng *engine) iterateUDPListeners(f func(fd int) error) error {
+	fds := make(map[int]struct{})
+	collect := func(lns map[int]*listener) {
+		for fd, ln := range lns {
+			if strings.HasPrefix(ln.network, "udp") {
+				fds[fd] = struct{}{}
+			}
+		}
+	}
+	collect(eng.listeners)
+	eng.eventLoops.iterate(func(_ int, el *eventloop) bool {
+		collect(el.listeners)
+		return true
+	})
+	if len(fds) == 0 {
+		return errors.ErrUnsupportedOp
+	}
+	for fd := range fds {
+		if err := f(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (eng *engine) joinGroup(ifIndex int, group, source net.IP) error {
+	return eng.iterateUDPListeners(func(fd int) error {
+		if source == nil {
+			return socket.JoinMulticastGroup(fd, ifIndex, group)
+		}
+		return socket.JoinSourceSpecificGroup(fd, ifIndex, group, source)
+	})
+}
+
+func (eng *engine) leaveGroup(ifIndex int, group, source net.IP) error {
+	return eng.iterateUDPListeners(func(fd int) error {
+		if source == nil {
+			return socket.LeaveMulticastGroup(fd, ifIndex, group)
+		}
+		return socket.LeaveSourceSpecificGroup(fd, ifIndex, group, source)
+	})
+}
+
+// probeSocketBusyPoll tries SO_BUSY_POLL on the TCP listeners once, which fails with EPERM without
+// CAP_NET_ADMIN if usec exceeds net.core.busy_read, and returns usec if it works, otherwise it's disabled
+// instead of being retried and logged for every accepted connection.
+func (eng *engine) probeSocketBusyPoll(usec int) int {
+	for _, ln := range eng.listeners {
+		if ln.network != "tcp" {
+			continue
+		}
+		if err := socket.SetBusyPoll(ln.fd, usec); err != nil {
+			eng.opts.Logger.Errorf("SO_BUSY_POLL is disabled on the accepted sockets, failed to set it: %v", err)
+			return 0
+		}
+	}
+	return usec
 }
 
 func run(eventHandler EventHandler, listeners []*listener, options *Options, addrs []string) error {
@@ -272,6 +398,12 @@ func run(eventHandler EventHandler, listeners []*listener, options *Options, add
 		}{&errgroup.Group{}, shutdownCtx, shutdown, sync.Once{}},
 		eventHandler: eventHandler,
 	}
+	if options.TLSConfig == nil {
+		eng.tlsHandler = newTLSEventHandler(eventHandler, options, false)
+	}
+	if options.SocketBusyPoll && options.BusyPollDuration > 0 {
+		eng.socketBusyPoll = eng.probeSocketBusyPoll(int(options.BusyPollDuration / time.Microsecond))
+	}
 	switch options.LB {
 	case RoundRobin:
 		eng.eventLoops = new(roundRobinLoadBalancer)
@@ -17,6 +17,7 @@ package gnet
 import (
 	"context"
 	"errors"
+	"net"
 	"runtime"
 	"strings"
 	"sync"
@@ -45,7 +46,8 @@ type engine struct {
 		shutdown    context.CancelFunc
 		once        sync.Once
 	}
-	eventHandler EventHandler // user eventHandler
+	tlsHandler   *tlsEventHandler // handler of the connections upgraded by Conn.StartTLS
+	eventHandler EventHandler     // user eventHandler
 }
 
 func (eng *engine) isInShutdown() bool {
@@ -151,6 +153,9 @@ func run(eventHandler EventHandler, listeners []*listener, options *Options, add
 			once        sync.Once
 		}{&errgroup.Group{}, shutdownCtx, shutdown, sync.Once{}},
 	}
+	if options.TLSConfig == nil {
+		eng.tlsHandler = newTLSEventHandler(eventHandler, options, false)
+	}
 
 	switch options.LB {
 	case RoundRobin:
@@ -190,6 +195,18 @@ func run(eventHandler EventHandler, listeners []*listener, options *Options, add
 	return nil
 }
 
+func (eng *engine) pollStats() (PollStats, error) {
+	return PollStats{}, errorx.ErrUnsupportedOp
+}
+
+func (eng *engine) joinGroup(_ int, _, _ net.IP) error {
+	return errorx.ErrUnsupportedOp
+}
+
+func (eng *engine) leaveGroup(_ int, _, _ net.IP) error {
+	return errorx.ErrUnsupportedOp
+}
+
 /*
 func (eng *engine) sendCmd(_ *asyncCmd, _ bool) error {
 	return errorx.ErrUnsupportedOp
@@ -23,8 +23,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
-	"strings"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -32,6 +32,7 @@ import (
 	gio "github.com/panjf2000/gnet/v2/internal/io"
 	"github.com/panjf2000/gnet/v2/internal/netpoll"
 	"github.com/panjf2000/gnet/v2/internal/queue"
+	"github.com/panjf2000/gnet/v2/internal/socket"
 	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
 )
@@ -42,11 +43,36 @@ type eventloop struct {
 	cache        bytes.Buffer      // temporary buffer for scattered bytes
 	engine       *engine           // engine in loop
 	poller       *netpoll.Poller   // epoll or kqueue
+	ring         *ioRing           // io_uring that drives TCP, nil if it's disabled or unavailable
 	buffer       []byte            // read packet buffer whose capacity is set by user, default value is 64KB
+	pktInfo      []byte            // buffer for the packet-info control messages of UDP datagrams
+	rights       []byte            // buffer for the SCM_RIGHTS control messages of Unix domain sockets
+	tlsRecord    []byte            // buffer for the control messages of the record types of kTLS
 	connections  connMatrix        // loop connections storage
+	dialing      map[int]*dialer   // connections being dialed by Client.DialWithContext
+	handshakes   tlsHandshakes     // TLS handshakes whose steps are offloaded
+	closing      bool              // whether the connections are being closed on shutdown
 	eventHandler EventHandler      // user eventHandler
 }
 
+// openPoller instantiates a poller in terms of the poller mode and busy-polling of opts.
+func openPoller(opts *Options) (p *netpoll.Poller, err error) {
+	mode := opts.PollerMode
+	if mode == PollerAuto {
+		mode = defaultPollerMode
+	}
+	if mode == PollerUltimate {
+		p, err = netpoll.OpenPoller(netpoll.DispatchByAttachment)
+	} else {
+		p, err = netpoll.OpenPoller(netpoll.DispatchByFD)
+	}
+	if err != nil {
+		return
+	}
+	p.SetBusyPoll(opts.BusyPollDuration)
+	return
+}
+
 func (el *eventloop) getLogger() logging.Logger {
 	return el.engine.opts.Logger
 }
@@ -57,10 +83,18 @@ func (el *eventloop) countConn() int32 {
 
 func (el *eventloop) closeConns() {
 	// Close loops and all outstanding connections
+	el.closing = true
 	el.connections.iterate(func(c *conn) bool {
 		_ = el.close(c, nil)
 		return true
 	})
+	// Abort the dials that are still in progress.
+	for fd, d := range el.dialing {
+		delete(el.dialing, fd)
+		d.stop()
+		_ = unix.Close(fd)
+		_ = el.dialed(d, nil, errorx.ErrEngineShutdown)
+	}
 }
 
 type connWithCallback struct {
@@ -79,11 +113,16 @@ func (el *eventloop) register(itf interface{}) error {
 }
 
 func (el *eventloop) register0(c *conn) error {
-	addEvents := el.poller.AddRead
-	if el.engine.opts.EdgeTriggeredIO {
-		addEvents = el.poller.AddReadWrite
+	var err error
+	switch {
+	case el.ring != nil && !c.isUnix && !c.isDatagram:
+		err = el.ring.attach(c)
+	case el.engine.opts.EdgeTriggeredIO:
+		err = el.poller.AddReadWrite(&c.pollAttachment, true)
+	default:
+		err = el.poller.AddRead(&c.pollAttachment, false)
 	}
-	if err := addEvents(&c.pollAttachment, el.engine.opts.EdgeTriggeredIO); err != nil {
+	if err != nil {
 		_ = unix.Close(c.fd)
 		c.release()
 		return err
@@ -105,7 +144,7 @@ func (el *eventloop) open(c *conn) error {
 		}
 	}
 
-	if !c.outboundBuffer.IsEmpty() && !el.engine.opts.EdgeTriggeredIO {
+	if !c.outboundBuffer.IsEmpty() && !el.engine.opts.EdgeTriggeredIO && !c.inRing {
 		if err := el.poller.ModReadWrite(&c.pollAttachment, false); err != nil {
 			return err
 		}
@@ -120,8 +159,18 @@ func (el *eventloop) read(c *conn) error {
 	}
 
 	isET := el.engine.opts.EdgeTriggeredIO
+	var (
+		n   int
+		err error
+	)
 loop:
-	n, err := unix.Read(c.fd, el.buffer)
+	if c.isUnix {
+		n, err = el.readWithFDs(c)
+	} else if c.ktls {
+		n, err = el.readTLSRecord(c)
+	} else {
+		n, err = unix.Read(c.fd, el.buffer)
+	}
 	if err != nil || n == 0 {
 		if err == unix.EAGAIN {
 			return nil
@@ -133,7 +182,7 @@ loop:
 	}
 
 	c.buffer = el.buffer[:n]
-	action := el.eventHandler.OnTraffic(c)
+	action := el.handler(c).OnTraffic(c)
 	switch action {
 	case None:
 	case Close:
@@ -151,13 +200,29 @@ loop:
 	return nil
 }
 
+// readWithFDs reads data from the Unix domain socket along with the file descriptors
+// passed over SCM_RIGHTS, which are queued up in the connection for the event handler.
+func (el *eventloop) readWithFDs(c *conn) (int, error) {
+	if el.rights == nil {
+		el.rights = make([]byte, socket.RightsBufferSize)
+	}
+	n, fds, err := socket.RecvmsgWithRights(c.fd, el.buffer, el.rights)
+	if len(fds) > 0 {
+		c.inboundFDs = append(c.inboundFDs, fds...)
+	}
+	return n, err
+}
+
 // The default value of UIO_MAXIOV/IOV_MAX is 1024 on Linux and most BSD-like OSs.
 const iovMax = 1024
 
 func (el *eventloop) write(c *conn) error {
-	if c.outboundBuffer.IsEmpty() {
+	if !c.outboundPending() {
 		return nil
 	}
+	if c.inRing {
+		return el.ring.write(c)
+	}
 
 	isET := el.engine.opts.EdgeTriggeredIO
 	var (
@@ -165,30 +230,43 @@ func (el *eventloop) write(c *conn) error {
 		err error
 	)
 loop:
-	iov, _ := c.outboundBuffer.Peek(-1)
-	if len(iov) > 1 {
+	if len(c.outboundWrites) > 0 && c.outboundWrites[0].pos == 0 {
+		switch err = c.sendPending(); err {
+		case nil:
+		case unix.EAGAIN:
+			return nil
+		default:
+			return el.close(c, err)
+		}
+	} else {
+		iov, _ := c.outboundBuffer.Peek(-1)
 		if len(iov) > iovMax {
 			iov = iov[:iovMax]
 		}
-		n, err = gio.Writev(c.fd, iov)
-	} else {
-		n, err = unix.Write(c.fd, iov[0])
-	}
-	_, _ = c.outboundBuffer.Discard(n)
-	switch err {
-	case nil:
-	case unix.EAGAIN:
-		return nil
-	default:
-		return el.close(c, os.NewSyscallError("write", err))
+		switch {
+		case len(c.outboundFDs) > 0 || len(c.outboundMsgs) > 0 || len(c.outboundWrites) > 0:
+			n, err = c.writeOutbound(iov)
+		case len(iov) > 1:
+			n, err = gio.Writev(c.fd, iov)
+		default:
+			n, err = unix.Write(c.fd, iov[0])
+		}
+		_, _ = c.outboundBuffer.Discard(n)
+		switch err {
+		case nil:
+		case unix.EAGAIN:
+			return nil
+		default:
+			return el.close(c, os.NewSyscallError("write", err))
+		}
 	}
-	if isET && !c.outboundBuffer.IsEmpty() {
+	if isET && c.outboundPending() {
 		goto loop
 	}
 
 	// All data have been sent, it's no need to monitor the writable events for LT mode,
 	// remove the writable event from poller to help the future event-loops if necessary.
-	if !isET && c.outboundBuffer.IsEmpty() {
+	if !isET && !c.outboundPending() {
 		_ = el.poller.ModRead(&c.pollAttachment, false)
 	}
 
@@ -196,7 +274,7 @@ loop:
 }
 
 func (el *eventloop) close(c *conn, err error) (rerr error) {
-	if addr := c.localAddr; addr != nil && strings.HasPrefix(c.localAddr.Network(), "udp") {
+	if c.isDatagram {
 		rerr = el.poller.Delete(c.fd)
 		if _, ok := el.listeners[c.fd]; !ok {
 			rerr = unix.Close(c.fd)
@@ -213,20 +291,47 @@ func (el *eventloop) close(c *conn, err error) (rerr error) {
 		return // ignore stale connections
 	}
 
+	if c.relay != nil {
+		c.relay.leave(c, nil)
+	}
+
 	// Send residual data in buffer back to the remote before actually closing the connection.
-	for !c.outboundBuffer.IsEmpty() {
+	// The data behind a pending file or zero-copy buffer is dropped since it won't be sent,
+	// so is the data while io_uring is writing the outbound buffer, which is retained until
+	// the write completes, see ioRing.written.
+	for !c.ringWriting && !c.outboundBuffer.IsEmpty() && (len(c.outboundWrites) == 0 || c.outboundWrites[0].pos > 0) {
 		iov, _ := c.outboundBuffer.Peek(0)
 		if len(iov) > iovMax {
 			iov = iov[:iovMax]
 		}
-		if n, e := gio.Writev(c.fd, iov); e != nil {
+		var (
+			n int
+			e error
+		)
+		if len(c.outboundFDs) > 0 || len(c.outboundMsgs) > 0 || len(c.outboundWrites) > 0 {
+			n, e = c.writeOutbound(iov)
+		} else {
+			n, e = gio.Writev(c.fd, iov)
+		}
+		if e != nil {
 			break
-		} else { //nolint:revive
-			_, _ = c.outboundBuffer.Discard(n)
 		}
+		_, _ = c.outboundBuffer.Discard(n)
 	}
+	// close_notify deferred by kTLS is sent only if it won't be followed by the data
+	// in the outbound buffer, otherwise the connection ends in a truncation anyway.
+	if c.closeNotify && !c.ringWriting && !c.outboundPending() {
+		sendCloseNotify(c.fd)
+	}
+	c.abortWrites(err)
 
-	err0, err1 := el.poller.Delete(c.fd), unix.Close(c.fd)
+	var err0 error
+	if c.inRing {
+		err0 = el.ring.detach(c)
+	} else {
+		err0 = el.poller.Delete(c.fd)
+	}
+	err1 := unix.Close(c.fd)
 	if err0 != nil {
 		rerr = fmt.Errorf("failed to delete fd=%d from poller in event-loop(%d): %v", c.fd, el.idx, err0)
 	}
@@ -240,7 +345,7 @@ func (el *eventloop) close(c *conn, err error) (rerr error) {
 	}
 
 	el.connections.delConn(c)
-	if el.eventHandler.OnClose(c, err) == Shutdown {
+	if el.handler(c).OnClose(c, err) == Shutdown {
 		rerr = errorx.ErrEngineShutdown
 	}
 	c.release()
@@ -253,7 +358,7 @@ func (el *eventloop) wake(c *conn) error {
 		return nil // ignore stale connections
 	}
 
-	action := el.eventHandler.OnTraffic(c)
+	action := el.handler(c).OnTraffic(c)
 
 	return el.handleAction(c, action)
 }
@@ -307,17 +412,23 @@ func (el *eventloop) handleAction(c *conn, action Action) error {
 }
 
 func (el *eventloop) readUDP(fd int, _ netpoll.IOEvent, _ netpoll.IOFlags) error {
-	n, sa, err := unix.Recvfrom(fd, el.buffer, 0)
+	n, oobn, _, sa, err := unix.Recvmsg(fd, el.buffer, el.pktInfo, 0)
 	if err != nil {
 		if err == unix.EAGAIN {
 			return nil
 		}
 		return fmt.Errorf("failed to read UDP packet from fd=%d in event-loop(%d), %v",
-			fd, el.idx, os.NewSyscallError("recvfrom", err))
+			fd, el.idx, os.NewSyscallError("recvmsg", err))
 	}
 	var c *conn
 	if ln, ok := el.listeners[fd]; ok {
 		c = newUDPConn(fd, el, ln.addr, sa, false)
+		// Expose the address that the datagram was sent to instead of the wildcard address
+		// of listener, and reply from that address on multi-homed hosts.
+		if dst, pktInfo := socket.ParsePktInfo(el.pktInfo[:oobn]); dst != nil {
+			c.localAddr = &net.UDPAddr{IP: dst, Port: ln.addr.(*net.UDPAddr).Port}
+			c.pktInfo = pktInfo
+		}
 	} else {
 		c = el.connections.getConn(fd)
 	}
@@ -5,9 +5,11 @@ package gnet
 
 import (
 	"context"
+	"io"
 	"net"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -139,6 +141,70 @@ func (s *benchmarkServerGC) OnBoot(eng Engine) (action Action) {
 	return
 }
 
+func BenchmarkPollerMode(b *testing.B) {
+	b.Run("Default", func(b *testing.B) {
+		benchmarkPollerMode(b, "tcp://127.0.0.1:9002", PollerDefault)
+	})
+	b.Run("Ultimate", func(b *testing.B) {
+		benchmarkPollerMode(b, "tcp://127.0.0.1:9003", PollerUltimate)
+	})
+}
+
+type benchmarkPollerModeServer struct {
+	*BuiltinEventEngine
+	eng    Engine
+	initOk chan struct{}
+}
+
+func (s *benchmarkPollerModeServer) OnBoot(eng Engine) (action Action) {
+	s.eng = eng
+	close(s.initOk)
+	return
+}
+
+func (s *benchmarkPollerModeServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(buf)
+	return
+}
+
+func benchmarkPollerMode(b *testing.B, protoAddr string, mode PollerMode) {
+	ts := &benchmarkPollerModeServer{initOk: make(chan struct{})}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(ts, protoAddr, WithPollerMode(mode), WithMulticore(true))
+	}()
+	<-ts.initOk
+
+	addr := strings.TrimPrefix(protoAddr, "tcp://")
+	msg := make([]byte, 64)
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, len(msg))
+		for pb.Next() {
+			if _, err = c.Write(msg); err != nil {
+				b.Error(err)
+				return
+			}
+			if _, err = io.ReadFull(c, buf); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+
+	assert.NoError(b, ts.eng.Stop(context.Background()))
+	assert.NoError(b, <-errCh)
+}
+
 // TestServeGC generate fake data asynchronously, if you need to test, manually open the comment.
 func TestServeGC(t *testing.T) {
 	t.Run("gc-loop", func(t *testing.T) {
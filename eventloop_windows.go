@@ -34,6 +34,7 @@ type eventloop struct {
 	cache        bytes.Buffer       // temporary buffer for scattered bytes
 	connCount    int32              // number of active connections in event-loop
 	connections  map[*conn]struct{} // TCP connection map: fd -> conn
+	closing      bool               // whether the connections are being closed on shutdown
 	eventHandler EventHandler       // user eventHandler
 }
 
@@ -52,6 +53,7 @@ func (el *eventloop) countConn() int32 {
 func (el *eventloop) run() (err error) {
 	defer func() {
 		el.eng.shutdown(err)
+		el.closing = true
 		for c := range el.connections {
 			_ = el.close(c, nil)
 		}
@@ -116,7 +118,7 @@ func (el *eventloop) read(c *conn) error {
 	if _, ok := el.connections[c]; !ok {
 		return nil // ignore stale wakes.
 	}
-	action := el.eventHandler.OnTraffic(c)
+	action := el.handler(c).OnTraffic(c)
 	switch action {
 	case None:
 	case Close:
@@ -183,7 +185,7 @@ func (el *eventloop) wake(c *conn) error {
 	if _, ok := el.connections[c]; !ok {
 		return nil // ignore stale wakes.
 	}
-	action := el.eventHandler.OnTraffic(c)
+	action := el.handler(c).OnTraffic(c)
 	return el.handleAction(c, action)
 }
 
@@ -205,7 +207,7 @@ func (el *eventloop) close(c *conn, err error) error {
 
 	delete(el.connections, c)
 	el.incConn(-1)
-	action := el.eventHandler.OnClose(c, err)
+	action := el.handler(c).OnClose(c, err)
 	if err := c.rawConn.Close(); err != nil {
 		el.getLogger().Errorf("failed to close connection(%s), error:%v", c.remoteAddr.String(), err)
 	}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"io"
 	"net"
+	"os"
 	"runtime"
 	"strings"
 	"sync"
@@ -27,6 +28,7 @@ import (
 	"github.com/panjf2000/gnet/v2/pkg/buffer/ring"
 	"github.com/panjf2000/gnet/v2/pkg/errors"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
+	"github.com/panjf2000/gnet/v2/pkg/tls"
 )
 
 // Action is an action that occurs after the completion of an event.
@@ -73,6 +75,41 @@ func (e Engine) CountConnections() (count int) {
 	return
 }
 
+// PollStats is the time that the event-loops of an Engine have spent on being busy and idle.
+type PollStats struct {
+	// Busy is the time spent on processing I/O events and asynchronous tasks,
+	// including polling for I/O events without blocking.
+	Busy time.Duration
+
+	// Idle is the time spent on blocking for I/O events.
+	Idle time.Duration
+}
+
+// PollStats returns the time that all event-loops of this Engine have spent on being busy and idle,
+// it helps with tuning Options.BusyPollDuration.
+//
+// Note that it's not supported on Windows.
+func (e Engine) PollStats() (stats PollStats, err error) {
+	if err = e.Validate(); err != nil {
+		return
+	}
+	return e.eng.pollStats()
+}
+
+// TLSHandshakeFailures returns the number of the failed TLS handshakes of this Engine by reason,
+// including the ones of the connections upgraded by Conn.StartTLS.
+func (e Engine) TLSHandshakeFailures() (failures map[TLSHandshakeFailure]uint64, err error) {
+	if err = e.Validate(); err != nil {
+		return
+	}
+	if h, ok := e.eng.eventHandler.(*tlsEventHandler); ok {
+		failures = h.handshakeFailures()
+	} else {
+		failures = e.eng.tlsHandler.handshakeFailures()
+	}
+	return
+}
+
 // Dup returns a copy of the underlying file descriptor of listener.
 // It is the caller's responsibility to close dupFD when finished.
 // Closing listener does not affect dupFD, and closing dupFD does not affect listener.
@@ -89,6 +126,53 @@ func (e Engine) Dup() (fd int, err error) {
 	return
 }
 
+// JoinGroup joins the UDP listeners of this Engine to the multicast group on the network interface
+// of ifIndex, the operating system will choose the interface if ifIndex is 0. It can be called
+// several times with different interfaces to receive the datagrams of the group on all of them.
+//
+// Note that it must be called after the engine has been started, e.g. in OnTick or from another goroutine,
+// and it's not supported on Windows.
+func (e Engine) JoinGroup(ifIndex int, group net.IP) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	return e.eng.joinGroup(ifIndex, group, nil)
+}
+
+// LeaveGroup drops the membership of the multicast group joined by JoinGroup on the network interface of ifIndex.
+func (e Engine) LeaveGroup(ifIndex int, group net.IP) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	return e.eng.leaveGroup(ifIndex, group, nil)
+}
+
+// JoinSourceSpecificGroup is like JoinGroup, but only the datagrams sent from source
+// to the group will be received, which is known as source-specific multicast (SSM).
+//
+// Note that it's only supported on Linux for the time being.
+func (e Engine) JoinSourceSpecificGroup(ifIndex int, group, source net.IP) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	if source == nil {
+		return errors.ErrInvalidNetworkAddress
+	}
+	return e.eng.joinGroup(ifIndex, group, source)
+}
+
+// LeaveSourceSpecificGroup drops the membership of the source-specific multicast group
+// joined by JoinSourceSpecificGroup on the network interface of ifIndex.
+func (e Engine) LeaveSourceSpecificGroup(ifIndex int, group, source net.IP) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	if source == nil {
+		return errors.ErrInvalidNetworkAddress
+	}
+	return e.eng.leaveGroup(ifIndex, group, source)
+}
+
 // Stop gracefully shuts down this Engine without interrupting any active event-loops,
 // it waits indefinitely for connections and event-loops to be closed and then shuts down.
 func (e Engine) Stop(ctx context.Context) error {
@@ -199,6 +283,13 @@ type Reader interface {
 	// Discard advances the inbound buffer with next n bytes, returning the number of bytes discarded.
 	Discard(n int) (discarded int, err error)
 
+	// ReadFDs returns the file descriptors received from the remote over SCM_RIGHTS so far
+	// and removes them from the connection, the caller takes over the ownership of them and
+	// must close them when finished. The file descriptors that haven't been taken away are
+	// closed along with the connection. It only works for Unix domain sockets and returns nil
+	// on other connections.
+	ReadFDs() (fds []int)
+
 	// InboundBuffered returns the number of bytes that can be read from the current buffer.
 	InboundBuffered() (n int)
 }
@@ -212,6 +303,15 @@ type Writer interface {
 	// you must invoke it within any method in EventHandler.
 	Writev(bs [][]byte) (n int, err error)
 
+	// WriteWithFDs writes p to remote and passes the file descriptors fds over SCM_RIGHTS
+	// along with p on Unix domain sockets, it's not concurrency-safe, you must invoke it
+	// within any method in EventHandler. The file descriptors are duplicated if they can't be
+	// sent right away, so the caller is free to close fds after it returns.
+	//
+	// ErrUnsupportedOp is returned for connections other than Unix domain sockets,
+	// and p must not be empty unless fds is.
+	WriteWithFDs(p []byte, fds []int) (n int, err error)
+
 	// Flush writes any buffered data to the underlying connection, it's not concurrency-safe,
 	// you must invoke it within any method in EventHandler.
 	Flush() (err error)
@@ -235,6 +335,30 @@ type Writer interface {
 	// you don't have to invoke it within any method in EventHandler,
 	// usually you would call it in an individual goroutine.
 	AsyncWritev(bs [][]byte, callback AsyncCallback) (err error)
+
+	// SendFile writes n bytes of f starting at offset to remote asynchronously with sendfile(2),
+	// the file data is sent directly by the kernel without being copied into the outbound buffer.
+	// It's concurrency-safe and the file region is sent after the data that has been written
+	// ahead of it, callback is invoked once the whole file region has been sent or the connection
+	// has been closed. n <= 0 means sending the rest of f from offset, and f must remain open until
+	// callback is invoked.
+	//
+	// ErrUnsupportedOp is returned for UDP, SOCK_SEQPACKET, connections driven by io_uring and TLS connections
+	// unless their record layer has been offloaded to the kernel, see Options.KernelTLS.
+	SendFile(f *os.File, offset, n int64, callback AsyncCallback) (err error)
+
+	// AsyncWriteZeroCopy writes buf to remote asynchronously without copying it into the outbound
+	// buffer, buf is sent with MSG_ZEROCOPY on Linux so that the kernel transmits it from the user
+	// memory directly, which pays off for large buffers, usually 10KB or more. It's concurrency-safe
+	// and buf is sent after the data that has been written ahead of it.
+	//
+	// buf must not be modified until callback is invoked, which happens once the kernel is done with
+	// buf. If the connection is closed before that, callback is invoked with a non-nil error, and the
+	// pages of buf may remain pinned by the kernel until the pending data is discarded. The buffers
+	// are sent by regular writes when MSG_ZEROCOPY is unavailable, e.g. on Unix domain sockets.
+	//
+	// ErrUnsupportedOp is returned for UDP, SOCK_SEQPACKET, TLS connections and connections driven by io_uring.
+	AsyncWriteZeroCopy(buf []byte, callback AsyncCallback) (err error)
 }
 
 // AsyncCallback is a callback which will be invoked after the asynchronous functions has finished executing.
@@ -242,6 +366,30 @@ type Writer interface {
 // Note that the parameter gnet.Conn is already released under UDP protocol, thus it's not allowed to be accessed.
 type AsyncCallback func(c Conn, err error) error
 
+// DialOptions are the options for Client.DialWithContext.
+type DialOptions struct {
+	// Timeout is the maximum amount of time a dial will wait for the connect to complete,
+	// the dial is only bounded by the context if it's not greater than 0.
+	Timeout time.Duration
+
+	// Context is an empty interface that can be obtained later via Conn.Context.
+	Context interface{}
+
+	// ServerName is used to verify the certificate of the server and sent as SNI when
+	// the client works with Options.TLSConfig, it overrides the ServerName in the config.
+	// It's also used by Conn.StartTLS unless the ServerName in the config passed to it is set.
+	// The host in the address is used if neither of them is specified.
+	ServerName string
+
+	// Callback is invoked with the connection after OnOpen has fired, or with the error
+	// after the dial has failed, timed out or been canceled, in which case the connection
+	// is nil and OnOpen/OnClose never fire.
+	//
+	// With Options.TLSConfig, Callback is invoked once the connect has completed, whereas
+	// OnOpen fires after the TLS handshake has completed later on.
+	Callback AsyncCallback
+}
+
 // Socket is a set of functions which manipulate the underlying file descriptor of a connection.
 //
 // Note that the methods in this interface are concurrency-safe for concurrent use,
@@ -293,6 +441,11 @@ type Socket interface {
 	// algorithm).
 	// The default is true (no delay), meaning that data is sent as soon as possible after a Write.
 	SetNoDelay(noDelay bool) error
+
+	// PeerCred returns the process ID, user ID and group ID of the peer process of a Unix domain socket,
+	// which were taken at the time of connect(2), a value is -1 if it's not available on the current platform.
+	// ErrUnsupportedOp is returned for connections other than Unix domain sockets.
+	PeerCred() (pid, uid, gid int, err error)
 	// CloseRead() error
 	// CloseWrite() error
 }
@@ -319,6 +472,18 @@ type Conn interface {
 	// you must invoke it within any method in EventHandler.
 	RemoteAddr() (addr net.Addr)
 
+	// Go runs fn on the goroutine pool of the engine, which is meant for the code in OnTraffic
+	// that has to call blocking services, and writes the bytes returned by fn back to the connection
+	// through its event-loop. The results of the functions passed to Go are written in the order of
+	// the calls to Go on each connection, even if they finish out of order. The results are dropped
+	// if the connection has been closed by then.
+	//
+	// The capacity of the pool and the number of functions that can be waiting for the workers are set
+	// by Options.WorkerPoolSize and Options.WorkerQueueSize, ErrWorkerQueueFull is returned if the queue
+	// is full. Go is not supported by UDP and on Windows, and it's not concurrency-safe, you must invoke
+	// it within any method in EventHandler.
+	Go(fn func() []byte) (err error)
+
 	// Wake triggers a OnTraffic event for the current connection, it's concurrency-safe.
 	Wake(callback AsyncCallback) (err error)
 
@@ -330,6 +495,20 @@ type Conn interface {
 	// Close closes the current connection, implements net.Conn, it's concurrency-safe.
 	Close() (err error)
 
+	// StartTLS upgrades the plaintext connection to TLS with config in the middle of the stream,
+	// which is meant for the protocols that negotiate in cleartext and then switch to TLS on the
+	// same connection, e.g. STARTTLS of SMTP and IMAP. The connection is the server side of TLS for
+	// the engine, and the client side for Client, whose server name is config.ServerName, or else
+	// DialOptions.ServerName or the host of the dialed address. The handshake is resumed with the
+	// data that has been buffered, and the subsequent OnTraffic receive the TLSConn, which fires once
+	// the handshake has completed even without any data to tell so, while OnOpen doesn't fire again.
+	// The data must not be written through the plaintext connection after it's called.
+	//
+	// errors.ErrTLSStarted is returned if the connection is served by TLS already, e.g. the engine
+	// works with Options.TLSConfig. StartTLS is not supported by UDP, and it's not concurrency-safe,
+	// you must invoke it within any method in EventHandler.
+	StartTLS(config *tls.Config) (err error)
+
 	// SetDeadline implements net.Conn.
 	SetDeadline(t time.Time) (err error)
 
@@ -470,8 +649,8 @@ func createListeners(addrs []string, opts ...Option) ([]*listener, *Options, err
 		if err != nil {
 			return nil, nil, err
 		}
-		hasUDP = hasUDP || strings.HasPrefix(proto, "udp")
-		hasUnix = hasUnix || proto == "unix"
+		hasUDP = hasUDP || strings.HasPrefix(proto, "udp") || proto == "unixgram"
+		hasUnix = hasUnix || strings.HasPrefix(proto, "unix")
 	}
 
 	// SO_REUSEPORT enables duplicate address and port bindings across various
@@ -525,13 +704,18 @@ func createListeners(addrs []string, opts ...Option) ([]*listener, *Options, err
 // like `tcp://192.168.0.10:9851` or `unix://socket`.
 // Valid network schemes:
 //
-//	tcp   - bind to both IPv4 and IPv6
-//	tcp4  - IPv4
-//	tcp6  - IPv6
-//	udp   - bind to both IPv4 and IPv6
-//	udp4  - IPv4
-//	udp6  - IPv6
-//	unix  - Unix Domain Socket
+//	tcp        - bind to both IPv4 and IPv6
+//	tcp4       - IPv4
+//	tcp6       - IPv6
+//	udp        - bind to both IPv4 and IPv6
+//	udp4       - IPv4
+//	udp6       - IPv6
+//	unix       - Unix Domain Socket
+//	unixgram   - Unix Domain Socket of datagram, handled like UDP
+//	unixpacket - Unix Domain Socket of sequenced packets, each read is a whole message
+//
+// Unix Domain Sockets whose names start with "@" are bound to the abstract namespace on Linux,
+// which doesn't create files in the filesystem, e.g. `unix://@gnet`.
 //
 // The "tcp" network scheme is assumed when one is not specified.
 func Run(eventHandler EventHandler, protoAddr string, opts ...Option) error {
@@ -548,7 +732,7 @@ func Run(eventHandler EventHandler, protoAddr string, opts ...Option) error {
 
 	// upgrade to TLS EventHandler
 	if options.TLSConfig != nil {
-		eventHandler = &tlsEventHandler{EventHandler: eventHandler, tlsConfig: options.TLSConfig}
+		eventHandler = newTLSEventHandler(eventHandler, options, false)
 	}
 
 	return run(eventHandler, listeners, options, []string{protoAddr})
@@ -569,7 +753,7 @@ func Rotate(eventHandler EventHandler, addrs []string, opts ...Option) error {
 
 	// upgrade to TLS EventHandler
 	if options.TLSConfig != nil {
-		eventHandler = &tlsEventHandler{EventHandler: eventHandler, tlsConfig: options.TLSConfig}
+		eventHandler = newTLSEventHandler(eventHandler, options, false)
 	}
 
 	return run(eventHandler, listeners, options, addrs)
@@ -626,7 +810,7 @@ func parseProtoAddr(protoAddr string) (string, string, error) {
 	pair := strings.SplitN(protoAddr, "://", 2)
 	proto, addr := pair[0], pair[1]
 	switch proto {
-	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix":
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix", "unixgram", "unixpacket":
 	default:
 		return "", "", errors.ErrUnsupportedProtocol
 	}
@@ -1,9 +1,6 @@
 // created by cgo -cdefs and then converted to Go
 // cgo -cdefs defs_linux.go defs3_linux.go
 
-//go:build poll_opt
-// +build poll_opt
-
 package netpoll
 
 type epollevent struct {
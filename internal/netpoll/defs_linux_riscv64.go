@@ -2,9 +2,6 @@
 // for the Go runtime.
 // go tool cgo -godefs defs_linux.go defs1_linux.go defs2_linux.go
 
-//go:build poll_opt
-// +build poll_opt
-
 package netpoll
 
 type epollevent struct {
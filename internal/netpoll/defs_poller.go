@@ -17,14 +17,82 @@
 
 package netpoll
 
+import (
+	"sync/atomic"
+	"time"
+)
+
 // IOFlags represents the flags of IO events.
 type IOFlags = uint16
 
 // PollEventHandler is the callback for I/O events notified by the poller.
 type PollEventHandler func(int, IOEvent, IOFlags) error
 
+// DispatchMode decides how the poller dispatches I/O events.
+type DispatchMode uint8
+
+const (
+	// DispatchByFD passes the file descriptors of I/O events to the callback of Polling,
+	// which looks up the owners of the file descriptors.
+	DispatchByFD DispatchMode = iota
+	// DispatchByAttachment stores the PollAttachment in the kernel along with the file descriptor
+	// and invokes its Callback for I/O events directly, the PollAttachment must be kept alive
+	// until the file descriptor is removed from the poller.
+	DispatchByAttachment
+)
+
 // PollAttachment is the user data which is about to be stored in "void *ptr" of epoll_data or "void *udata" of kevent.
 type PollAttachment struct {
 	FD       int
 	Callback PollEventHandler
 }
+
+// pollStats keeps track of the time that the poller spends on being busy and idle,
+// and decides when the poller should stop busy-polling and block for events.
+type pollStats struct {
+	busyPoll  time.Duration // duration of polling without blocking after the last event
+	busy      int64         // nanoseconds spent on processing events and polling without blocking
+	idle      int64         // nanoseconds spent on blocking for events
+	mark      time.Time     // end of the last period that has been accounted
+	lastEvent time.Time     // when the last events arrived
+}
+
+// SetBusyPoll makes the poller keep polling for events without blocking for d after
+// the last events arrived, it must be called before Polling.
+func (s *pollStats) SetBusyPoll(d time.Duration) {
+	s.busyPoll = d
+}
+
+// Stats returns the time that the poller has spent on being busy and idle,
+// it's safe to call it from any goroutine.
+func (s *pollStats) Stats() (busy, idle time.Duration) {
+	return time.Duration(atomic.LoadInt64(&s.busy)), time.Duration(atomic.LoadInt64(&s.idle))
+}
+
+func (s *pollStats) start() {
+	s.mark = time.Now()
+}
+
+// beforeBlock accounts the time since the last wait as busy before blocking for events.
+func (s *pollStats) beforeBlock() {
+	now := time.Now()
+	atomic.AddInt64(&s.busy, int64(now.Sub(s.mark)))
+	s.mark = now
+}
+
+// afterWait accounts the time of the wait that has returned n events and reports
+// whether the poller should keep polling without blocking.
+func (s *pollStats) afterWait(blocking bool, n int) bool {
+	now := time.Now()
+	if blocking {
+		atomic.AddInt64(&s.idle, int64(now.Sub(s.mark)))
+	} else {
+		atomic.AddInt64(&s.busy, int64(now.Sub(s.mark)))
+	}
+	s.mark = now
+	if n > 0 {
+		s.lastEvent = now
+		return true
+	}
+	return !blocking && now.Sub(s.lastEvent) < s.busyPoll
+}
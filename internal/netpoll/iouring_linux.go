@@ -0,0 +1,496 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netpoll
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+// The subset of the definitions in <linux/io_uring.h> that is used by Ring.
+const (
+	ioringOffSQRing = 0
+	ioringOffSQEs   = 0x10000000
+
+	ioringSetupCQSize = 1 << 3
+	ioringSetupClamp  = 1 << 4
+
+	ioringFeatSingleMmap = 1 << 0
+	ioringFeatNoDrop     = 1 << 1
+
+	ioringEnterGetEvents = 1 << 0
+
+	ioringSQCQOverflow = 1 << 1
+
+	ioringRegisterPbufRing = 22
+
+	ioringOpWritev      = 2
+	ioringOpAccept      = 13
+	ioringOpAsyncCancel = 14
+	ioringOpRecv        = 27
+
+	iosqeBufferSelect = 1 << 5
+
+	ioringAcceptMultishot = 1 << 0
+	ioringRecvMultishot   = 1 << 1
+
+	ioringAsyncCancelAll = 1 << 0
+	ioringAsyncCancelFD  = 1 << 1
+
+	ioringCQEBufferShift = 16
+)
+
+const (
+	// CQEFBuffer indicates that the upper 16 bits of the flags of a completion
+	// is the ID of the provided buffer that holds the data.
+	CQEFBuffer = 1 << 0
+	// CQEFMore indicates that the multishot request will post more completions.
+	CQEFMore = 1 << 1
+)
+
+type sqRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type cqRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	userAddr    uint64
+}
+
+type ioURingParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        sqRingOffsets
+	cqOff        cqRingOffsets
+}
+
+type ioURingSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufGroup    uint16
+	personality uint16
+	fileIndex   int32
+	addr3       uint64
+	pad         uint64
+}
+
+type ioURingCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type ioURingBuf struct {
+	addr uint64
+	len  uint32
+	bid  uint16
+	resv uint16 // the tail of the ring lies in the resv field of the first buffer
+}
+
+type ioURingBufReg struct {
+	ringAddr    uint64
+	ringEntries uint32
+	bgid        uint16
+	flags       uint16
+	resv        [3]uint64
+}
+
+// Ring is an io_uring instance with a submission queue and a completion queue,
+// which is not safe for concurrent use and ought to be driven by one event-loop.
+type Ring struct {
+	fd       int
+	ringMem  []byte
+	sqeMem   []byte
+	sqHead   *uint32
+	sqTail   *uint32
+	sqFlags  *uint32
+	sqMask   uint32
+	sqSize   uint32
+	sqes     []ioURingSQE
+	sqeTail  uint32 // tail of the prepared submissions that haven't been published to the kernel
+	cqHead   *uint32
+	cqTail   *uint32
+	cqMask   uint32
+	cqes     []ioURingCQE
+	bufRings []*BufRing
+}
+
+// minKernelVersion is the first kernel release that supports the multishot recv
+// along with the provided buffer rings, on which Ring depends.
+var minKernelVersion = [2]int{6, 0}
+
+func kernelSupportsIOURing() bool {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return false
+	}
+	release := unix.ByteSliceToString(uts.Release[:])
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	minor, err := strconv.Atoi(strings.TrimFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return false
+	}
+	return major > minKernelVersion[0] || (major == minKernelVersion[0] && minor >= minKernelVersion[1])
+}
+
+// OpenRing creates an io_uring instance with the given number of submission queue entries,
+// the completion queue is four times the size of the submission queue.
+// ErrIOURingUnavailable is returned if the running kernel doesn't offer the required features.
+func OpenRing(entries uint32) (*Ring, error) {
+	if !kernelSupportsIOURing() {
+		return nil, errors.ErrIOURingUnavailable
+	}
+
+	var params ioURingParams
+	params.flags = ioringSetupCQSize | ioringSetupClamp
+	params.cqEntries = entries * 4
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("io_uring_setup", errno)
+	}
+	r := &Ring{fd: int(fd)}
+	if params.features&ioringFeatSingleMmap == 0 || params.features&ioringFeatNoDrop == 0 {
+		_ = r.Close()
+		return nil, errors.ErrIOURingUnavailable
+	}
+
+	size := params.sqOff.array + params.sqEntries*4
+	if cqSize := params.cqOff.cqes + params.cqEntries*uint32(unsafe.Sizeof(ioURingCQE{})); cqSize > size {
+		size = cqSize
+	}
+	var err error
+	r.ringMem, err = unix.Mmap(r.fd, ioringOffSQRing, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		_ = r.Close()
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	r.sqeMem, err = unix.Mmap(r.fd, ioringOffSQEs, int(params.sqEntries)*int(unsafe.Sizeof(ioURingSQE{})),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		_ = r.Close()
+		return nil, os.NewSyscallError("mmap", err)
+	}
+
+	base := unsafe.Pointer(&r.ringMem[0])
+	r.sqHead = (*uint32)(unsafe.Add(base, params.sqOff.head))
+	r.sqTail = (*uint32)(unsafe.Add(base, params.sqOff.tail))
+	r.sqFlags = (*uint32)(unsafe.Add(base, params.sqOff.flags))
+	r.sqMask = *(*uint32)(unsafe.Add(base, params.sqOff.ringMask))
+	r.sqSize = params.sqEntries
+	r.sqes = unsafe.Slice((*ioURingSQE)(unsafe.Pointer(&r.sqeMem[0])), params.sqEntries)
+	r.sqeTail = *r.sqTail
+	// The submissions are always prepared in order, map each slot of the array to its own entry once and for all.
+	array := unsafe.Slice((*uint32)(unsafe.Add(base, params.sqOff.array)), params.sqEntries)
+	for i := range array {
+		array[i] = uint32(i)
+	}
+	r.cqHead = (*uint32)(unsafe.Add(base, params.cqOff.head))
+	r.cqTail = (*uint32)(unsafe.Add(base, params.cqOff.tail))
+	r.cqMask = *(*uint32)(unsafe.Add(base, params.cqOff.ringMask))
+	r.cqes = unsafe.Slice((*ioURingCQE)(unsafe.Add(base, params.cqOff.cqes)), params.cqEntries)
+
+	return r, nil
+}
+
+// Fd returns the file descriptor of the ring, which becomes readable when there are completions.
+func (r *Ring) Fd() int {
+	return r.fd
+}
+
+// Close tears down the ring, all the outstanding requests are canceled by the kernel.
+func (r *Ring) Close() error {
+	err := os.NewSyscallError("close", unix.Close(r.fd))
+	for _, br := range r.bufRings {
+		_ = unix.Munmap(br.mem)
+	}
+	r.bufRings = nil
+	if r.sqeMem != nil {
+		_ = unix.Munmap(r.sqeMem)
+		r.sqeMem = nil
+	}
+	if r.ringMem != nil {
+		_ = unix.Munmap(r.ringMem)
+		r.ringMem = nil
+	}
+	return err
+}
+
+func (r *Ring) enter(toSubmit, minComplete, flags uint32) (int, error) {
+	for {
+		n, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd),
+			uintptr(toSubmit), uintptr(minComplete), uintptr(flags), 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return int(n), errno
+		}
+		return int(n), nil
+	}
+}
+
+// getSQE returns a zeroed submission queue entry, the pending submissions are
+// submitted to make room for it if the submission queue is full.
+func (r *Ring) getSQE() (*ioURingSQE, error) {
+	if r.sqeTail-atomic.LoadUint32(r.sqHead) >= r.sqSize {
+		if err := r.Submit(); err != nil {
+			return nil, err
+		}
+		if r.sqeTail-atomic.LoadUint32(r.sqHead) >= r.sqSize {
+			return nil, os.NewSyscallError("io_uring_enter", unix.EBUSY)
+		}
+	}
+	sqe := &r.sqes[r.sqeTail&r.sqMask]
+	*sqe = ioURingSQE{}
+	r.sqeTail++
+	return sqe, nil
+}
+
+// Pending returns the number of the prepared submissions that haven't been submitted.
+func (r *Ring) Pending() int {
+	return int(r.sqeTail - atomic.LoadUint32(r.sqHead))
+}
+
+// Submit submits all the prepared requests to the kernel with a single system call.
+// The submissions are left in the queue if the kernel is too busy to take them,
+// they will be submitted next time.
+func (r *Ring) Submit() error {
+	atomic.StoreUint32(r.sqTail, r.sqeTail)
+	for {
+		pending := r.sqeTail - atomic.LoadUint32(r.sqHead)
+		if pending == 0 {
+			return nil
+		}
+		n, err := r.enter(pending, 0, 0)
+		switch err {
+		case nil:
+			if n == 0 {
+				return nil
+			}
+		case unix.EAGAIN, unix.EBUSY:
+			return nil
+		default:
+			return os.NewSyscallError("io_uring_enter", err)
+		}
+	}
+}
+
+// Reap calls fn with every completion in the completion queue until it's empty or fn
+// returns false. Each completion is consumed before calling fn, so it's allowed to reap
+// the ring again in fn.
+func (r *Ring) Reap(fn func(userData uint64, res int32, flags uint32) bool) {
+	for {
+		head := atomic.LoadUint32(r.cqHead)
+		if head == atomic.LoadUint32(r.cqTail) {
+			// The completions that couldn't fit in the completion queue are kept by the kernel,
+			// flush them into the completion queue and go on.
+			if atomic.LoadUint32(r.sqFlags)&ioringSQCQOverflow == 0 {
+				return
+			}
+			if _, err := r.enter(0, 0, ioringEnterGetEvents); err != nil {
+				return
+			}
+			continue
+		}
+		cqe := r.cqes[head&r.cqMask]
+		atomic.StoreUint32(r.cqHead, head+1)
+		if !fn(cqe.userData, cqe.res, cqe.flags) {
+			return
+		}
+	}
+}
+
+// PrepareAcceptMultishot prepares a multishot accept on the listener fd, which posts
+// a completion with a non-blocking and close-on-exec socket for each connection.
+func (r *Ring) PrepareAcceptMultishot(fd int, userData uint64) error {
+	sqe, err := r.getSQE()
+	if err != nil {
+		return err
+	}
+	sqe.opcode = ioringOpAccept
+	sqe.fd = int32(fd)
+	sqe.ioprio = ioringAcceptMultishot
+	sqe.opFlags = unix.SOCK_NONBLOCK | unix.SOCK_CLOEXEC
+	sqe.userData = userData
+	return nil
+}
+
+// PrepareRecvMultishot prepares a multishot recv on the socket fd, which posts a completion
+// for each chunk of data that is put in a buffer picked from the given buffer ring.
+func (r *Ring) PrepareRecvMultishot(fd int, br *BufRing, userData uint64) error {
+	sqe, err := r.getSQE()
+	if err != nil {
+		return err
+	}
+	sqe.opcode = ioringOpRecv
+	sqe.fd = int32(fd)
+	sqe.flags = iosqeBufferSelect
+	sqe.ioprio = ioringRecvMultishot
+	sqe.bufGroup = br.bgid
+	sqe.userData = userData
+	return nil
+}
+
+// PrepareWritev prepares a writev on fd, iovecs and the memory they point to must stay
+// untouched until the completion arrives.
+func (r *Ring) PrepareWritev(fd int, iovecs []unix.Iovec, userData uint64) error {
+	sqe, err := r.getSQE()
+	if err != nil {
+		return err
+	}
+	sqe.opcode = ioringOpWritev
+	sqe.fd = int32(fd)
+	sqe.addr = uint64(uintptr(unsafe.Pointer(&iovecs[0])))
+	sqe.len = uint32(len(iovecs))
+	sqe.userData = userData
+	return nil
+}
+
+// PrepareCancelFD prepares the cancellation of all the requests on fd.
+func (r *Ring) PrepareCancelFD(fd int, userData uint64) error {
+	sqe, err := r.getSQE()
+	if err != nil {
+		return err
+	}
+	sqe.opcode = ioringOpAsyncCancel
+	sqe.fd = int32(fd)
+	sqe.opFlags = ioringAsyncCancelAll | ioringAsyncCancelFD
+	sqe.userData = userData
+	return nil
+}
+
+// BufRing is a ring of buffers provided to the kernel, from which the kernel picks
+// a buffer for each chunk of data received by the requests with buffer selection.
+type BufRing struct {
+	mem     []byte
+	bufs    []ioURingBuf
+	data    []byte
+	size    int
+	mask    uint16
+	bgid    uint16
+	tail    uint16
+	pending bool
+}
+
+// RegisterBufRing registers a ring of entries buffers of the given size with the group ID bgid,
+// all buffers are handed to the kernel at once. entries must be a power of 2 and no more than 32768.
+func (r *Ring) RegisterBufRing(bgid uint16, entries, size int) (*BufRing, error) {
+	mem, err := unix.Mmap(-1, 0, entries*int(unsafe.Sizeof(ioURingBuf{})),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANONYMOUS|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	reg := ioURingBufReg{
+		ringAddr:    uint64(uintptr(unsafe.Pointer(&mem[0]))),
+		ringEntries: uint32(entries),
+		bgid:        bgid,
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(r.fd), ioringRegisterPbufRing,
+		uintptr(unsafe.Pointer(&reg)), 1, 0, 0)
+	if errno != 0 {
+		_ = unix.Munmap(mem)
+		return nil, os.NewSyscallError("io_uring_register", errno)
+	}
+	br := &BufRing{
+		mem:  mem,
+		bufs: unsafe.Slice((*ioURingBuf)(unsafe.Pointer(&mem[0])), entries),
+		data: make([]byte, entries*size),
+		size: size,
+		mask: uint16(entries - 1),
+		bgid: bgid,
+	}
+	for i := 0; i < entries; i++ {
+		br.Recycle(uint16(i))
+	}
+	br.Commit()
+	r.bufRings = append(r.bufRings, br)
+	return br, nil
+}
+
+// BufferID returns the ID of the buffer that was picked for the completion with flags.
+func BufferID(flags uint32) uint16 {
+	return uint16(flags >> ioringCQEBufferShift)
+}
+
+// Buffer returns the buffer with the given ID.
+func (br *BufRing) Buffer(bid uint16) []byte {
+	off := int(bid) * br.size
+	return br.data[off : off+br.size : off+br.size]
+}
+
+// Recycle puts the buffer with the given ID back to the ring, it's not visible
+// to the kernel until Commit is called.
+func (br *BufRing) Recycle(bid uint16) {
+	buf := &br.bufs[br.tail&br.mask]
+	buf.addr = uint64(uintptr(unsafe.Pointer(&br.Buffer(bid)[0])))
+	buf.len = uint32(br.size)
+	buf.bid = bid
+	br.tail++
+	br.pending = true
+}
+
+// Commit hands the recycled buffers over to the kernel.
+func (br *BufRing) Commit() {
+	if !br.pending {
+		return
+	}
+	br.pending = false
+	// The 16-bit tail shares a 32-bit word with the ID of the first buffer,
+	// publish it along with the ID with an atomic store.
+	word := [2]uint16{br.bufs[0].bid, br.tail}
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&br.bufs[0].bid)), *(*uint32)(unsafe.Pointer(&word)))
+}
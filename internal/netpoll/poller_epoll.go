@@ -1,4 +1,5 @@
-// Copyright (c) 2021 The Gnet Authors. All rights reserved.
+// Copyright (c) 2019 Andy Pan
+// Copyright (c) 2017 Joshua J Baker
 //
 // Licensed under the Apache License, Version 2.0 (the "License");
 // you may not use this file except in compliance with the License.
@@ -12,8 +13,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:build linux && poll_opt
-// +build linux,poll_opt
+//go:build linux
+// +build linux
 
 package netpoll
 
@@ -33,31 +34,33 @@ import (
 // Poller represents a poller which is in charge of monitoring file-descriptors.
 type Poller struct {
 	fd                          int             // epoll fd
-	epa                         *PollAttachment // PollAttachment for waking events
+	efd                         int             // eventfd
 	efdBuf                      []byte          // efd buffer to read an 8-byte integer
+	epa                         *PollAttachment // PollAttachment of eventfd
+	mode                        DispatchMode
 	wakeupCall                  int32
 	asyncTaskQueue              queue.AsyncTaskQueue // queue with low priority
 	urgentAsyncTaskQueue        queue.AsyncTaskQueue // queue with high priority
 	highPriorityEventsThreshold int32                // threshold of high-priority events
+	pollStats
 }
 
-// OpenPoller instantiates a poller.
-func OpenPoller() (poller *Poller, err error) {
-	poller = new(Poller)
+// OpenPoller instantiates a poller which dispatches I/O events in the given mode.
+func OpenPoller(mode DispatchMode) (poller *Poller, err error) {
+	poller = &Poller{mode: mode}
 	if poller.fd, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC); err != nil {
 		poller = nil
 		err = os.NewSyscallError("epoll_create1", err)
 		return
 	}
-	var efd int
-	if efd, err = unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC); err != nil {
+	if poller.efd, err = unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC); err != nil {
 		_ = poller.Close()
 		poller = nil
 		err = os.NewSyscallError("eventfd", err)
 		return
 	}
 	poller.efdBuf = make([]byte, 8)
-	poller.epa = &PollAttachment{FD: efd}
+	poller.epa = &PollAttachment{FD: poller.efd}
 	if err = poller.AddRead(poller.epa, true); err != nil {
 		_ = poller.Close()
 		poller = nil
@@ -74,7 +77,7 @@ func (p *Poller) Close() error {
 	if err := os.NewSyscallError("close", unix.Close(p.fd)); err != nil {
 		return err
 	}
-	return os.NewSyscallError("close", unix.Close(p.epa.FD))
+	return os.NewSyscallError("close", unix.Close(p.efd))
 }
 
 // Make the endianness of bytes compatible with more linux OSs under different processor-architectures,
@@ -102,9 +105,9 @@ func (p *Poller) Trigger(priority queue.EventPriority, fn queue.TaskFunc, arg in
 	}
 	if atomic.CompareAndSwapInt32(&p.wakeupCall, 0, 1) {
 		for {
-			_, err = unix.Write(p.epa.FD, b)
+			_, err = unix.Write(p.efd, b)
 			if err == unix.EAGAIN {
-				_, _ = unix.Read(p.epa.FD, p.efdBuf)
+				_, _ = unix.Read(p.efd, p.efdBuf)
 				continue
 			}
 			break
@@ -114,14 +117,25 @@ func (p *Poller) Trigger(priority queue.EventPriority, fn queue.TaskFunc, arg in
 }
 
 // Polling blocks the current goroutine, waiting for network-events.
-func (p *Poller) Polling() error {
+//
+// The events are passed to callback along with the file descriptors in DispatchByFD mode,
+// or to the Callback of the PollAttachment of each file descriptor in DispatchByAttachment mode.
+func (p *Poller) Polling(callback PollEventHandler) error {
 	el := newEventList(InitPollEventsCap)
 	var doChores bool
 
 	msec := -1
+	p.start()
 	for {
+		if msec != 0 {
+			p.beforeBlock()
+		}
 		n, err := epollWait(p.fd, el.events, msec)
+		spin := p.afterWait(msec != 0, n)
 		if n == 0 || (n < 0 && err == unix.EINTR) {
+			if spin {
+				continue
+			}
 			msec = -1
 			runtime.Gosched()
 			continue
@@ -133,11 +147,11 @@ func (p *Poller) Polling() error {
 
 		for i := 0; i < n; i++ {
 			ev := &el.events[i]
-			pollAttachment := *(**PollAttachment)(unsafe.Pointer(&ev.data))
-			if pollAttachment.FD == p.epa.FD { // poller is awakened to run tasks in queues.
+			fd, handler := p.dispatch(ev, callback)
+			if fd == p.efd { // poller is awakened to run tasks in queues.
 				doChores = true
 			} else {
-				switch err = pollAttachment.Callback(pollAttachment.FD, ev.events, 0); err {
+				switch err = handler(fd, ev.events, 0); err {
 				case nil:
 				case errors.ErrAcceptSocket, errors.ErrEngineShutdown:
 					return err
@@ -176,9 +190,9 @@ func (p *Poller) Polling() error {
 			atomic.StoreInt32(&p.wakeupCall, 0)
 			if (!p.asyncTaskQueue.IsEmpty() || !p.urgentAsyncTaskQueue.IsEmpty()) && atomic.CompareAndSwapInt32(&p.wakeupCall, 0, 1) {
 				for {
-					_, err = unix.Write(p.epa.FD, b)
+					_, err = unix.Write(p.efd, b)
 					if err == unix.EAGAIN {
-						_, _ = unix.Read(p.epa.FD, p.efdBuf)
+						_, _ = unix.Read(p.efd, p.efdBuf)
 						continue
 					}
 					if err != nil {
@@ -197,6 +211,28 @@ func (p *Poller) Polling() error {
 	}
 }
 
+// event packs the file descriptor or the PollAttachment into the user data of epoll_event
+// in terms of the mode of the poller.
+func (p *Poller) event(pa *PollAttachment, events uint32) (ev epollevent) {
+	ev.events = events
+	if p.mode == DispatchByAttachment {
+		*(**PollAttachment)(unsafe.Pointer(&ev.data)) = pa
+	} else {
+		*(*int32)(unsafe.Pointer(&ev.data)) = int32(pa.FD)
+	}
+	return
+}
+
+// dispatch unpacks the user data of epoll_event, returning the file descriptor
+// and the handler of the I/O event.
+func (p *Poller) dispatch(ev *epollevent, callback PollEventHandler) (int, PollEventHandler) {
+	if p.mode == DispatchByAttachment {
+		pa := *(**PollAttachment)(unsafe.Pointer(&ev.data))
+		return pa.FD, pa.Callback
+	}
+	return int(*(*int32)(unsafe.Pointer(&ev.data))), callback
+}
+
 const (
 	readEvents      = unix.EPOLLIN | unix.EPOLLPRI | unix.EPOLLRDHUP
 	writeEvents     = unix.EPOLLOUT | unix.EPOLLRDHUP
@@ -205,56 +241,46 @@ const (
 
 // AddReadWrite registers the given file-descriptor with readable and writable events to the poller.
 func (p *Poller) AddReadWrite(pa *PollAttachment, edgeTriggered bool) error {
-	var ev epollevent
-	ev.events = readWriteEvents
+	ev := p.event(pa, readWriteEvents)
 	if edgeTriggered {
 		ev.events |= unix.EPOLLET
 	}
-	*(**PollAttachment)(unsafe.Pointer(&ev.data)) = pa
 	return os.NewSyscallError("epoll_ctl add", epollCtl(p.fd, unix.EPOLL_CTL_ADD, pa.FD, &ev))
 }
 
 // AddRead registers the given file-descriptor with readable event to the poller.
 func (p *Poller) AddRead(pa *PollAttachment, edgeTriggered bool) error {
-	var ev epollevent
-	ev.events = readEvents
+	ev := p.event(pa, readEvents)
 	if edgeTriggered {
 		ev.events |= unix.EPOLLET
 	}
-	*(**PollAttachment)(unsafe.Pointer(&ev.data)) = pa
 	return os.NewSyscallError("epoll_ctl add", epollCtl(p.fd, unix.EPOLL_CTL_ADD, pa.FD, &ev))
 }
 
 // AddWrite registers the given file-descriptor with writable event to the poller.
 func (p *Poller) AddWrite(pa *PollAttachment, edgeTriggered bool) error {
-	var ev epollevent
-	ev.events = writeEvents
+	ev := p.event(pa, writeEvents)
 	if edgeTriggered {
 		ev.events |= unix.EPOLLET
 	}
-	*(**PollAttachment)(unsafe.Pointer(&ev.data)) = pa
 	return os.NewSyscallError("epoll_ctl add", epollCtl(p.fd, unix.EPOLL_CTL_ADD, pa.FD, &ev))
 }
 
 // ModRead renews the given file-descriptor with readable event in the poller.
 func (p *Poller) ModRead(pa *PollAttachment, edgeTriggered bool) error {
-	var ev epollevent
-	ev.events = readEvents
+	ev := p.event(pa, readEvents)
 	if edgeTriggered {
 		ev.events |= unix.EPOLLET
 	}
-	*(**PollAttachment)(unsafe.Pointer(&ev.data)) = pa
 	return os.NewSyscallError("epoll_ctl mod", epollCtl(p.fd, unix.EPOLL_CTL_MOD, pa.FD, &ev))
 }
 
 // ModReadWrite renews the given file-descriptor with readable and writable events in the poller.
 func (p *Poller) ModReadWrite(pa *PollAttachment, edgeTriggered bool) error {
-	var ev epollevent
-	ev.events = readWriteEvents
+	ev := p.event(pa, readWriteEvents)
 	if edgeTriggered {
 		ev.events |= unix.EPOLLET
 	}
-	*(**PollAttachment)(unsafe.Pointer(&ev.data)) = pa
 	return os.NewSyscallError("epoll_ctl mod", epollCtl(p.fd, unix.EPOLL_CTL_MOD, pa.FD, &ev))
 }
 
@@ -1,4 +1,4 @@
-// Copyright (c) 2021 The Gnet Authors. All rights reserved.
+// Copyright (c) 2019 The Gnet Authors. All rights reserved.
 //
 // Licensed under the Apache License, Version 2.0 (the "License");
 // you may not use this file except in compliance with the License.
@@ -12,9 +12,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:build (freebsd || dragonfly || netbsd || openbsd || darwin) && poll_opt
+//go:build freebsd || dragonfly || netbsd || openbsd || darwin
 // +build freebsd dragonfly netbsd openbsd darwin
-// +build poll_opt
 
 package netpoll
 
@@ -34,15 +33,17 @@ import (
 // Poller represents a poller which is in charge of monitoring file-descriptors.
 type Poller struct {
 	fd                          int
+	mode                        DispatchMode
 	wakeupCall                  int32
 	asyncTaskQueue              queue.AsyncTaskQueue // queue with low priority
 	urgentAsyncTaskQueue        queue.AsyncTaskQueue // queue with high priority
 	highPriorityEventsThreshold int32                // threshold of high-priority events
+	pollStats
 }
 
-// OpenPoller instantiates a poller.
-func OpenPoller() (poller *Poller, err error) {
-	poller = new(Poller)
+// OpenPoller instantiates a poller which dispatches I/O events in the given mode.
+func OpenPoller(mode DispatchMode) (poller *Poller, err error) {
+	poller = &Poller{mode: mode}
 	if poller.fd, err = unix.Kqueue(); err != nil {
 		poller = nil
 		err = os.NewSyscallError("kqueue", err)
@@ -100,7 +101,10 @@ func (p *Poller) Trigger(priority queue.EventPriority, fn queue.TaskFunc, arg in
 }
 
 // Polling blocks the current goroutine, waiting for network-events.
-func (p *Poller) Polling() error {
+//
+// The events are passed to callback along with the file descriptors in DispatchByFD mode,
+// or to the Callback of the PollAttachment of each file descriptor in DispatchByAttachment mode.
+func (p *Poller) Polling(callback PollEventHandler) error {
 	el := newEventList(InitPollEventsCap)
 
 	var (
@@ -108,9 +112,17 @@ func (p *Poller) Polling() error {
 		tsp      *unix.Timespec
 		doChores bool
 	)
+	p.start()
 	for {
+		if tsp == nil {
+			p.beforeBlock()
+		}
 		n, err := unix.Kevent(p.fd, nil, el.events, tsp)
+		spin := p.afterWait(tsp == nil, n)
 		if n == 0 || (n < 0 && err == unix.EINTR) {
+			if spin {
+				continue
+			}
 			tsp = nil
 			runtime.Gosched()
 			continue
@@ -125,8 +137,11 @@ func (p *Poller) Polling() error {
 			if ev.Ident == 0 { // poller is awakened to run tasks in queues
 				doChores = true
 			} else {
-				pollAttachment := (*PollAttachment)(unsafe.Pointer(ev.Udata))
-				switch err = pollAttachment.Callback(int(ev.Ident), ev.Filter, ev.Flags); err {
+				handler := callback
+				if p.mode == DispatchByAttachment {
+					handler = (*PollAttachment)(unsafe.Pointer(ev.Udata)).Callback
+				}
+				switch err = handler(int(ev.Ident), ev.Filter, ev.Flags); err {
 				case nil:
 				case errors.ErrAcceptSocket, errors.ErrEngineShutdown:
 					return err
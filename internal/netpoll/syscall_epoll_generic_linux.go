@@ -12,8 +12,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:build !arm64 && !riscv64 && poll_opt
-// +build !arm64,!riscv64,poll_opt
+//go:build !arm64 && !riscv64
+// +build !arm64,!riscv64
 
 package netpoll
 
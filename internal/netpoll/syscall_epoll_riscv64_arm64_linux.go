@@ -12,9 +12,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:build ((linux && arm64) || (linux && riscv64)) && poll_opt
+//go:build (linux && arm64) || (linux && riscv64)
 // +build linux,arm64 linux,riscv64
-// +build poll_opt
 
 package netpoll
 
@@ -2,9 +2,6 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-//go:build poll_opt
-// +build poll_opt
-
 package netpoll
 
 import "golang.org/x/sys/unix"
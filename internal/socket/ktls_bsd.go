@@ -0,0 +1,50 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || dragonfly || netbsd || openbsd || darwin
+// +build freebsd dragonfly netbsd openbsd darwin
+
+package socket
+
+import "golang.org/x/sys/unix"
+
+// The cipher types of kTLS, which are only meaningful on Linux.
+const (
+	TLSCipherAESGCM128        = 51
+	TLSCipherAESGCM256        = 52
+	TLSCipherChaCha20Poly1305 = 54
+)
+
+// TLSRecordOOBSize is the size of the buffer for the control message of the record type.
+var TLSRecordOOBSize = 0
+
+// SetTLSULP always fails since kTLS is specific to Linux here.
+func SetTLSULP(_ int) error {
+	return unix.ENOPROTOOPT
+}
+
+// SetTLSCryptoInfo is not supported on this platform.
+func SetTLSCryptoInfo(_ int, _ bool, _, _ uint16, _, _, _ []byte, _ [8]byte) error {
+	return unix.ENOPROTOOPT
+}
+
+// RecvTLSRecord is not supported on this platform.
+func RecvTLSRecord(_ int, _, _ []byte) (int, byte, error) {
+	return 0, 0, unix.ENOPROTOOPT
+}
+
+// SendTLSRecord is not supported on this platform.
+func SendTLSRecord(_ int, _ byte, _ []byte) (int, error) {
+	return 0, unix.ENOPROTOOPT
+}
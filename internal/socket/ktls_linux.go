@@ -0,0 +1,99 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket
+
+import (
+	"encoding/binary"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The cipher types of kTLS, see include/uapi/linux/tls.h.
+const (
+	TLSCipherAESGCM128        = 51
+	TLSCipherAESGCM256        = 52
+	TLSCipherChaCha20Poly1305 = 54
+)
+
+const (
+	tlsTX            = 1 // the socket option of the crypto state for sending
+	tlsRX            = 2 // the socket option of the crypto state for receiving
+	tlsSetRecordType = 1 // the control message of the type of the record to send
+	tlsGetRecordType = 2 // the control message of the type of the received record
+
+	tlsRecordTypeApplicationData = 23
+)
+
+// TLSRecordOOBSize is the size of the buffer for the control message of the record type.
+var TLSRecordOOBSize = unix.CmsgSpace(1)
+
+// SetTLSULP attaches the TLS upper layer protocol to the TCP socket, which is required
+// to offload the record layer of TLS to the kernel (kTLS), it fails with ENOENT if the
+// tls module is missing.
+func SetTLSULP(fd int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptString(fd, unix.IPPROTO_TCP, unix.TCP_ULP, "tls"))
+}
+
+// SetTLSCryptoInfo installs the crypto state for receiving the records if rx is true or sending them
+// otherwise on the socket with the TLS ULP attached. iv, key, salt and seq are laid out like the
+// struct tls12_crypto_info_* of the cipher, where the sizes of iv and salt vary with the cipher.
+func SetTLSCryptoInfo(fd int, rx bool, version, cipher uint16, iv, key, salt []byte, seq [8]byte) error {
+	info := make([]byte, 4, 4+len(iv)+len(key)+len(salt)+len(seq))
+	binary.NativeEndian.PutUint16(info, version)
+	binary.NativeEndian.PutUint16(info[2:], cipher)
+	info = append(info, iv...)
+	info = append(info, key...)
+	info = append(info, salt...)
+	info = append(info, seq[:]...)
+	opt := tlsTX
+	if rx {
+		opt = tlsRX
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptString(fd, unix.SOL_TLS, opt, string(info)))
+}
+
+// RecvTLSRecord reads the content of the records of the same type from the socket with kTLS for
+// receiving and returns the type of the records, which is application data unless the control
+// message says otherwise, oob is the buffer of TLSRecordOOBSize bytes for the control message.
+func RecvTLSRecord(fd int, p, oob []byte) (n int, typ byte, err error) {
+	typ = tlsRecordTypeApplicationData
+	n, oobn, _, _, err := unix.Recvmsg(fd, p, oob, 0)
+	if err != nil || oobn == 0 {
+		return
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, 0, os.NewSyscallError("recvmsg", err)
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.SOL_TLS && msg.Header.Type == tlsGetRecordType && len(msg.Data) > 0 {
+			typ = msg.Data[0]
+		}
+	}
+	return
+}
+
+// SendTLSRecord writes p as the content of a record of the given type to the socket with kTLS for sending.
+func SendTLSRecord(fd int, typ byte, p []byte) (int, error) {
+	oob := make([]byte, unix.CmsgSpace(1))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.SOL_TLS
+	h.Type = tlsSetRecordType
+	h.SetLen(unix.CmsgLen(1))
+	oob[unix.CmsgLen(0)] = typ
+	return unix.SendmsgN(fd, p, oob, nil, 0)
+}
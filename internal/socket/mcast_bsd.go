@@ -0,0 +1,34 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || dragonfly || netbsd || openbsd || darwin
+// +build freebsd dragonfly netbsd openbsd darwin
+
+package socket
+
+import (
+	"net"
+
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+// JoinSourceSpecificGroup is not supported on *BSD and Darwin for the time being.
+func JoinSourceSpecificGroup(_, _ int, _, _ net.IP) error {
+	return errors.ErrUnsupportedOp
+}
+
+// LeaveSourceSpecificGroup is not supported on *BSD and Darwin for the time being.
+func LeaveSourceSpecificGroup(_, _ int, _, _ net.IP) error {
+	return errors.ErrUnsupportedOp
+}
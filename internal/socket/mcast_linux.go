@@ -0,0 +1,86 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket
+
+import (
+	"net"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+// sizeofSockaddrStorage is the size of struct sockaddr_storage.
+const sizeofSockaddrStorage = 128
+
+// JoinSourceSpecificGroup joins fd to the source-specific multicast group on the interface of ifIndex,
+// only the datagrams sent from source to group will be received.
+func JoinSourceSpecificGroup(fd, ifIndex int, group, source net.IP) error {
+	return setSourceSpecificGroup(fd, ifIndex, group, source, true)
+}
+
+// LeaveSourceSpecificGroup drops the membership of the source-specific multicast group on the interface of ifIndex.
+func LeaveSourceSpecificGroup(fd, ifIndex int, group, source net.IP) error {
+	return setSourceSpecificGroup(fd, ifIndex, group, source, false)
+}
+
+// setSourceSpecificGroup sets MCAST_JOIN_SOURCE_GROUP or MCAST_LEAVE_SOURCE_GROUP with struct group_source_req,
+// which selects the interface by index for both IPv4 and IPv6, unlike IP_ADD_SOURCE_MEMBERSHIP.
+func setSourceSpecificGroup(fd, ifIndex int, group, source net.IP, join bool) error {
+	if !group.IsMulticast() {
+		return errors.ErrInvalidMulticastAddress
+	}
+	level := unix.IPPROTO_IPV6
+	if group.To4() != nil {
+		if source.To4() == nil {
+			return errors.ErrInvalidNetworkAddress
+		}
+		level = unix.IPPROTO_IP
+	} else if source.To4() != nil || source.To16() == nil {
+		return errors.ErrInvalidNetworkAddress
+	}
+
+	// struct group_source_req {
+	//	uint32_t                gsr_interface;
+	//	struct sockaddr_storage gsr_group;
+	//	struct sockaddr_storage gsr_source;
+	// };
+	// sockaddr_storage is aligned to the size of unsigned long.
+	off := int(unsafe.Sizeof(uintptr(0)))
+	req := make([]byte, off+2*sizeofSockaddrStorage)
+	*(*uint32)(unsafe.Pointer(&req[0])) = uint32(ifIndex)
+	putSockaddr(req[off:], group)
+	putSockaddr(req[off+sizeofSockaddrStorage:], source)
+
+	opt := unix.MCAST_JOIN_SOURCE_GROUP
+	if !join {
+		opt = unix.MCAST_LEAVE_SOURCE_GROUP
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptString(fd, level, opt, string(req)))
+}
+
+func putSockaddr(b []byte, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&b[0]))
+		sa.Family = unix.AF_INET
+		copy(sa.Addr[:], ip4)
+		return
+	}
+	sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(&b[0]))
+	sa.Family = unix.AF_INET6
+	copy(sa.Addr[:], ip.To16())
+}
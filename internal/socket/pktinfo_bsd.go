@@ -0,0 +1,35 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || dragonfly || netbsd || openbsd || darwin
+// +build freebsd dragonfly netbsd openbsd darwin
+
+package socket
+
+import "net"
+
+// PktInfoBufferSize is the size of the buffer for the packet-info control messages,
+// which are only supported on Linux for the time being.
+var PktInfoBufferSize = 0
+
+// SetPktInfo is a no-op on *BSD and Darwin, UDP replies are always sent
+// from the source address chosen by the kernel.
+func SetPktInfo(_, _ int) error {
+	return nil
+}
+
+// ParsePktInfo always returns nil values on *BSD and Darwin.
+func ParsePktInfo(_ []byte) (dst net.IP, reply []byte) {
+	return
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket
+
+import (
+	"net"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// PktInfoBufferSize is the size of the buffer that is large enough to hold
+// the IP_PKTINFO and IPV6_PKTINFO control messages of a datagram, both of
+// which are delivered for the IPv4 traffic on the dual-stack sockets.
+var PktInfoBufferSize = unix.CmsgSpace(unix.SizeofInet4Pktinfo) + unix.CmsgSpace(unix.SizeofInet6Pktinfo)
+
+// SetPktInfo enables the IP_PKTINFO and IPV6_RECVPKTINFO socket options on a UDP socket,
+// which makes the kernel attach the destination address of each received datagram as
+// a control message.
+func SetPktInfo(fd, enable int) error {
+	family, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
+	if err != nil {
+		return os.NewSyscallError("getsockopt", err)
+	}
+	if family == unix.AF_INET6 {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_RECVPKTINFO, enable); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	// IP_PKTINFO is also set on AF_INET6 sockets for the IPv4-mapped traffic
+	// of the dual-stack sockets, it fails on IPv6-only sockets, which is harmless.
+	err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_PKTINFO, enable)
+	if family == unix.AF_INET6 {
+		return nil
+	}
+	return os.NewSyscallError("setsockopt", err)
+}
+
+// ParsePktInfo parses the IP_PKTINFO or IPV6_PKTINFO control message of a received datagram,
+// it returns the local destination address of the datagram and the control message that
+// ought to be sent along with the replies to make them leave from the same address.
+// Nil values are returned if there is no such control message in oob.
+//
+// IP_PKTINFO takes precedence over IPV6_PKTINFO since the IPv4-mapped address in the latter
+// is not usable as the source address of the replies on the dual-stack sockets.
+func ParsePktInfo(oob []byte) (dst net.IP, reply []byte) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.IPPROTO_IP && msg.Header.Type == unix.IP_PKTINFO &&
+			len(msg.Data) >= unix.SizeofInet4Pktinfo {
+			info := (*unix.Inet4Pktinfo)(unsafe.Pointer(&msg.Data[0]))
+			dst = net.IPv4(info.Addr[0], info.Addr[1], info.Addr[2], info.Addr[3])
+			// ipi_spec_dst is the local address the datagram was delivered to,
+			// which differs from the header destination for broadcast and multicast.
+			reply = make([]byte, unix.CmsgSpace(unix.SizeofInet4Pktinfo))
+			h := (*unix.Cmsghdr)(unsafe.Pointer(&reply[0]))
+			h.Level, h.Type = unix.IPPROTO_IP, unix.IP_PKTINFO
+			h.SetLen(unix.CmsgLen(unix.SizeofInet4Pktinfo))
+			pi := (*unix.Inet4Pktinfo)(unsafe.Pointer(&reply[unix.CmsgLen(0)]))
+			pi.Ifindex = info.Ifindex
+			pi.Spec_dst = info.Spec_dst
+			return
+		}
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.IPPROTO_IPV6 && msg.Header.Type == unix.IPV6_PKTINFO &&
+			len(msg.Data) >= unix.SizeofInet6Pktinfo {
+			info := (*unix.Inet6Pktinfo)(unsafe.Pointer(&msg.Data[0]))
+			dst = make(net.IP, net.IPv6len)
+			copy(dst, info.Addr[:])
+			reply = make([]byte, unix.CmsgSpace(unix.SizeofInet6Pktinfo))
+			h := (*unix.Cmsghdr)(unsafe.Pointer(&reply[0]))
+			h.Level, h.Type = unix.IPPROTO_IPV6, unix.IPV6_PKTINFO
+			h.SetLen(unix.CmsgLen(unix.SizeofInet6Pktinfo))
+			pi := (*unix.Inet6Pktinfo)(unsafe.Pointer(&reply[unix.CmsgLen(0)]))
+			pi.Ifindex = info.Ifindex
+			// A multicast group can't be the source address, leave it unspecified
+			// and let the kernel pick one on the same interface.
+			if !dst.IsMulticast() {
+				pi.Addr = info.Addr
+			}
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || netbsd || openbsd || darwin
+// +build linux freebsd dragonfly netbsd openbsd darwin
+
+package socket
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// MaxFDsPerMessage is the maximum number of file descriptors that can be received
+// along with a single read from a Unix domain socket, the excess ones are discarded
+// by the kernel.
+const MaxFDsPerMessage = 64
+
+// RightsBufferSize is the size of the buffer that is large enough to hold
+// the SCM_RIGHTS control message with MaxFDsPerMessage file descriptors.
+var RightsBufferSize = unix.CmsgSpace(MaxFDsPerMessage * 4)
+
+// RecvmsgWithRights reads data from the Unix domain socket into p and returns
+// the file descriptors passed over SCM_RIGHTS, oob is the buffer for the control message.
+// The received file descriptors are marked close-on-exec.
+func RecvmsgWithRights(fd int, p, oob []byte) (n int, fds []int, err error) {
+	n, oobn, _, _, err := unix.Recvmsg(fd, p, oob, msgCmsgCloexec)
+	if err != nil || oobn == 0 {
+		return
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, nil, nil
+	}
+	for i := range msgs {
+		rights, e := unix.ParseUnixRights(&msgs[i])
+		if e != nil {
+			continue
+		}
+		if msgCmsgCloexec == 0 {
+			for _, rfd := range rights {
+				unix.CloseOnExec(rfd)
+			}
+		}
+		fds = append(fds, rights...)
+	}
+	return
+}
+
+// SendmsgWithRights writes p to the Unix domain socket and passes fds over SCM_RIGHTS
+// along with the first byte of p, it returns the number of bytes written.
+func SendmsgWithRights(fd int, p []byte, fds []int) (int, error) {
+	n, err := unix.SendmsgN(fd, p, unix.UnixRights(fds...), nil, 0)
+	if err != nil {
+		return n, os.NewSyscallError("sendmsg", err)
+	}
+	return n, nil
+}
@@ -109,26 +109,20 @@ func SetMulticastMembership(proto string, udpAddr *net.UDPAddr) func(int, int) e
 // received. If ifIndex is 0 then the operating system will choose the default,
 // it is usually needed when the host has multiple network interfaces configured.
 func SetIPv4MulticastMembership(fd int, mcast net.IP, ifIndex int) error {
-	// Multicast interfaces are selected by IP address on IPv4 (and by index on IPv6)
-	ip, err := interfaceFirstIPv4Addr(ifIndex)
-	if err != nil {
-		return err
-	}
-
-	mreq := &unix.IPMreq{}
-	copy(mreq.Multiaddr[:], mcast.To4())
-	copy(mreq.Interface[:], ip.To4())
-
 	if ifIndex > 0 {
-		if err := os.NewSyscallError("setsockopt", unix.SetsockoptInet4Addr(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, mreq.Interface)); err != nil {
+		// Multicast interfaces are selected by IP address on IPv4 (and by index on IPv6)
+		ip, err := interfaceFirstIPv4Addr(ifIndex)
+		if err != nil {
+			return err
+		}
+		var addr [4]byte
+		copy(addr[:], ip.To4())
+		if err := os.NewSyscallError("setsockopt", unix.SetsockoptInet4Addr(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, addr)); err != nil {
 			return err
 		}
 	}
 
-	if err := os.NewSyscallError("setsockopt", unix.SetsockoptByte(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_LOOP, 0)); err != nil {
-		return err
-	}
-	return os.NewSyscallError("setsockopt", unix.SetsockoptIPMreq(fd, syscall.IPPROTO_IP, syscall.IP_ADD_MEMBERSHIP, mreq))
+	return JoinMulticastGroup(fd, ifIndex, mcast)
 }
 
 // SetIPv6MulticastMembership joins fd to the specified multicast IPv6 address.
@@ -136,20 +130,100 @@ func SetIPv4MulticastMembership(fd int, mcast net.IP, ifIndex int) error {
 // received. If ifIndex is 0 then the operating system will choose the default,
 // it is usually needed when the host has multiple network interfaces configured.
 func SetIPv6MulticastMembership(fd int, mcast net.IP, ifIndex int) error {
-	mreq := &unix.IPv6Mreq{}
-	mreq.Interface = uint32(ifIndex)
-	copy(mreq.Multiaddr[:], mcast.To16())
-
 	if ifIndex > 0 {
 		if err := os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, ifIndex)); err != nil {
 			return err
 		}
 	}
 
-	if err := os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 0)); err != nil {
+	return JoinMulticastGroup(fd, ifIndex, mcast)
+}
+
+// JoinMulticastGroup joins fd to the multicast group on the interface of ifIndex,
+// the operating system will choose the interface if ifIndex is 0.
+// It can be called several times with different interfaces for the same group.
+func JoinMulticastGroup(fd, ifIndex int, group net.IP) error {
+	return setMulticastGroup(fd, ifIndex, group, true)
+}
+
+// LeaveMulticastGroup drops the membership of the multicast group on the interface of ifIndex.
+func LeaveMulticastGroup(fd, ifIndex int, group net.IP) error {
+	return setMulticastGroup(fd, ifIndex, group, false)
+}
+
+func setMulticastGroup(fd, ifIndex int, group net.IP, join bool) error {
+	if !group.IsMulticast() {
+		return errors.ErrInvalidMulticastAddress
+	}
+
+	if group.To4() != nil {
+		ip, err := interfaceFirstIPv4Addr(ifIndex)
+		if err != nil {
+			return err
+		}
+		mreq := &unix.IPMreq{}
+		copy(mreq.Multiaddr[:], group.To4())
+		copy(mreq.Interface[:], ip.To4())
+		opt := unix.IP_ADD_MEMBERSHIP
+		if !join {
+			opt = unix.IP_DROP_MEMBERSHIP
+		}
+		return os.NewSyscallError("setsockopt", unix.SetsockoptIPMreq(fd, unix.IPPROTO_IP, opt, mreq))
+	}
+
+	mreq := &unix.IPv6Mreq{}
+	mreq.Interface = uint32(ifIndex)
+	copy(mreq.Multiaddr[:], group.To16())
+	opt := unix.IPV6_JOIN_GROUP
+	if !join {
+		opt = unix.IPV6_LEAVE_GROUP
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptIPv6Mreq(fd, unix.IPPROTO_IPV6, opt, mreq))
+}
+
+// SetMulticastLoopback controls whether the multicast datagrams sent from fd
+// are looped back to the local sockets that joined the same group.
+func SetMulticastLoopback(fd, loop int) error {
+	isIPv6, err := isIPv6Socket(fd)
+	if err != nil {
+		return err
+	}
+	if isIPv6 {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_LOOP, loop); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+		// The IPv4 option takes effect for the IPv4 traffic of the dual-stack sockets,
+		// it's not supported everywhere, so the error is ignored.
+		_ = unix.SetsockoptByte(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_LOOP, byte(loop))
+		return nil
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptByte(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_LOOP, byte(loop)))
+}
+
+// SetMulticastTTL sets the time-to-live (IPv4) or the hop limit (IPv6)
+// of the multicast datagrams sent from fd.
+func SetMulticastTTL(fd, ttl int) error {
+	isIPv6, err := isIPv6Socket(fd)
+	if err != nil {
 		return err
 	}
-	return os.NewSyscallError("setsockopt", unix.SetsockoptIPv6Mreq(fd, syscall.IPPROTO_IPV6, syscall.IPV6_JOIN_GROUP, mreq))
+	if isIPv6 {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, ttl); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+		_ = unix.SetsockoptByte(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_TTL, byte(ttl))
+		return nil
+	}
+	return os.NewSyscallError("setsockopt", unix.SetsockoptByte(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_TTL, byte(ttl)))
+}
+
+func isIPv6Socket(fd int) (bool, error) {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return false, os.NewSyscallError("getsockname", err)
+	}
+	_, ok := sa.(*unix.SockaddrInet6)
+	return ok, nil
 }
 
 // interfaceFirstIPv4Addr returns the first IPv4 address of the interface.
@@ -40,7 +40,7 @@ func SockaddrToTCPOrUnixAddr(sa unix.Sockaddr) net.Addr {
 	return nil
 }
 
-// SockaddrToUDPAddr converts a Sockaddr to a net.UDPAddr
+// SockaddrToUDPAddr converts a Sockaddr to a net.UDPAddr or a net.UnixAddr of "unixgram".
 // Returns nil if conversion fails.
 func SockaddrToUDPAddr(sa unix.Sockaddr) net.Addr {
 	switch sa := sa.(type) {
@@ -48,6 +48,8 @@ func SockaddrToUDPAddr(sa unix.Sockaddr) net.Addr {
 		return &net.UDPAddr{IP: sa.Addr[0:], Port: sa.Port}
 	case *unix.SockaddrInet6:
 		return &net.UDPAddr{IP: sa.Addr[0:], Port: sa.Port, Zone: ip6ZoneToString(sa.ZoneId)}
+	case *unix.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: "unixgram"}
 	}
 	return nil
 }
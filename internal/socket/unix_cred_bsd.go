@@ -0,0 +1,32 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build dragonfly || netbsd || openbsd
+// +build dragonfly netbsd openbsd
+
+package socket
+
+import (
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+// msgCmsgCloexec makes the kernel set close-on-exec on the file descriptors received over SCM_RIGHTS.
+const msgCmsgCloexec = unix.MSG_CMSG_CLOEXEC
+
+// GetPeerCred is not supported on DragonFly BSD, NetBSD and OpenBSD for the time being.
+func GetPeerCred(_ int) (pid, uid, gid int, err error) {
+	return -1, -1, -1, errors.ErrUnsupportedOp
+}
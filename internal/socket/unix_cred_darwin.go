@@ -0,0 +1,41 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// msgCmsgCloexec is unavailable on Darwin, close-on-exec is set after the file descriptors are received.
+const msgCmsgCloexec = 0
+
+// GetPeerCred returns the credentials of the process on the other end of the Unix domain socket,
+// which were taken at the time of connect(2).
+func GetPeerCred(fd int) (pid, uid, gid int, err error) {
+	cred, err := unix.GetsockoptXucred(fd, unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	if err != nil {
+		return -1, -1, -1, os.NewSyscallError("getsockopt", err)
+	}
+	if pid, err = unix.GetsockoptInt(fd, unix.SOL_LOCAL, unix.LOCAL_PEERPID); err != nil {
+		return -1, -1, -1, os.NewSyscallError("getsockopt", err)
+	}
+	gid = -1
+	if cred.Ngroups > 0 {
+		gid = int(cred.Groups[0])
+	}
+	return pid, int(cred.Uid), gid, nil
+}
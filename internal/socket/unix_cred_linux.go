@@ -0,0 +1,34 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// msgCmsgCloexec makes the kernel set close-on-exec on the file descriptors received over SCM_RIGHTS.
+const msgCmsgCloexec = unix.MSG_CMSG_CLOEXEC
+
+// GetPeerCred returns the credentials of the process on the other end of the Unix domain socket,
+// which were taken at the time of connect(2).
+func GetPeerCred(fd int) (pid, uid, gid int, err error) {
+	cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return -1, -1, -1, os.NewSyscallError("getsockopt", err)
+	}
+	return int(cred.Pid), int(cred.Uid), int(cred.Gid), nil
+}
@@ -20,6 +20,7 @@ package socket
 import (
 	"net"
 	"os"
+	"runtime"
 
 	"golang.org/x/sys/unix"
 
@@ -34,7 +35,7 @@ func GetUnixSockAddr(proto, addr string) (sa unix.Sockaddr, family int, unixAddr
 	}
 
 	switch unixAddr.Network() {
-	case "unix":
+	case "unix", "unixgram", "unixpacket":
 		sa, family = &unix.SockaddrUnix{Name: unixAddr.Name}, unix.AF_UNIX
 	default:
 		err = errors.ErrUnsupportedUDSProtocol
@@ -43,6 +44,12 @@ func GetUnixSockAddr(proto, addr string) (sa unix.Sockaddr, family int, unixAddr
 	return
 }
 
+// IsAbstractUnixAddr reports whether the Unix domain socket address is in the abstract namespace,
+// which is only available on Linux and doesn't have a presence in the filesystem.
+func IsAbstractUnixAddr(addr string) bool {
+	return runtime.GOOS == "linux" && len(addr) > 0 && addr[0] == '@'
+}
+
 // udsSocket creates an endpoint for communication and returns a file descriptor that refers to that endpoint.
 // Argument `reusePort` indicates whether the SO_REUSEPORT flag will be assigned.
 func udsSocket(proto, addr string, passive bool, sockOpts ...Option) (fd int, netAddr net.Addr, err error) {
@@ -55,7 +62,15 @@ func udsSocket(proto, addr string, passive bool, sockOpts ...Option) (fd int, ne
 		return
 	}
 
-	if fd, err = sysSocket(family, unix.SOCK_STREAM, 0); err != nil {
+	sotype := unix.SOCK_STREAM
+	switch proto {
+	case "unixgram":
+		sotype = unix.SOCK_DGRAM
+	case "unixpacket":
+		sotype = unix.SOCK_SEQPACKET
+	}
+
+	if fd, err = sysSocket(family, sotype, 0); err != nil {
 		err = os.NewSyscallError("socket", err)
 		return
 	}
@@ -80,6 +95,10 @@ func udsSocket(proto, addr string, passive bool, sockOpts ...Option) (fd int, ne
 		if err = os.NewSyscallError("bind", unix.Bind(fd, sa)); err != nil {
 			return
 		}
+		// Datagram sockets are ready to receive data once they're bound.
+		if sotype == unix.SOCK_DGRAM {
+			return
+		}
 
 		// Set backlog size to the maximum.
 		err = os.NewSyscallError("listen", unix.Listen(fd, listenerBacklogMaxSize))
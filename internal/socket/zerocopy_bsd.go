@@ -0,0 +1,35 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || dragonfly || netbsd || openbsd || darwin
+// +build freebsd dragonfly netbsd openbsd darwin
+
+package socket
+
+import "golang.org/x/sys/unix"
+
+// SetZeroCopy always fails since MSG_ZEROCOPY is specific to Linux.
+func SetZeroCopy(_ int) error {
+	return unix.ENOPROTOOPT
+}
+
+// SendZeroCopy is not supported on this platform.
+func SendZeroCopy(_ int, _ []byte) (int, error) {
+	return 0, unix.ENOPROTOOPT
+}
+
+// ReadZeroCopyCompletions is not supported on this platform.
+func ReadZeroCopyCompletions(_ int, _ func(lo, hi uint32)) error {
+	return unix.ENOPROTOOPT
+}
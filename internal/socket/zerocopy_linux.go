@@ -0,0 +1,79 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socket
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetZeroCopy enables MSG_ZEROCOPY on the socket, it's only supported by TCP and UDP sockets
+// on Linux 4.14 and later.
+func SetZeroCopy(fd int) error {
+	return os.NewSyscallError("setsockopt", unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ZEROCOPY, 1))
+}
+
+// SendZeroCopy writes p to the socket with MSG_ZEROCOPY, the kernel pins the pages of p
+// instead of copying the data, so p must not be modified until the completion notification
+// of this send has been read from the error queue of the socket.
+//
+// Each call that writes any bytes of p is assigned the next sequence number starting from zero.
+func SendZeroCopy(fd int, p []byte) (int, error) {
+	return unix.SendmsgN(fd, p, nil, nil, unix.MSG_ZEROCOPY)
+}
+
+const sizeofSockExtendedErr = int(unsafe.Sizeof(unix.SockExtendedErr{}))
+
+// zeroCopyOOBSize is the size of the buffer for the control message of a completion notification.
+var zeroCopyOOBSize = unix.CmsgSpace(sizeofSockExtendedErr)
+
+// ReadZeroCopyCompletions reads all the completion notifications from the error queue
+// of the socket and invokes fn with the inclusive range of the sequence numbers of the
+// completed sends, the range may wrap around.
+func ReadZeroCopyCompletions(fd int, fn func(lo, hi uint32)) error {
+	oob := make([]byte, zeroCopyOOBSize)
+	for {
+		_, oobn, _, _, err := unix.Recvmsg(fd, nil, oob, unix.MSG_ERRQUEUE)
+		switch err {
+		case nil:
+		case unix.EINTR:
+			continue
+		case unix.EAGAIN:
+			return nil
+		default:
+			return os.NewSyscallError("recvmsg", err)
+		}
+		msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			continue
+		}
+		for _, msg := range msgs {
+			if !(msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_RECVERR) &&
+				!(msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_RECVERR) {
+				continue
+			}
+			if len(msg.Data) < sizeofSockExtendedErr {
+				continue
+			}
+			serr := (*unix.SockExtendedErr)(unsafe.Pointer(&msg.Data[0]))
+			if serr.Errno != 0 || serr.Origin != unix.SO_EE_ORIGIN_ZEROCOPY {
+				continue
+			}
+			fn(serr.Info, serr.Data)
+		}
+	}
+}
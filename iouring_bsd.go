@@ -0,0 +1,32 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build freebsd || dragonfly || netbsd || openbsd || darwin
+// +build freebsd dragonfly netbsd openbsd darwin
+
+package gnet
+
+import errorx "github.com/panjf2000/gnet/v2/pkg/errors"
+
+// ioRing is only available on Linux, the event-loops always work with kqueue on BSD.
+type ioRing struct{}
+
+func (el *eventloop) openRing() error { return errorx.ErrIOURingUnavailable }
+
+func (r *ioRing) supports(_ string) bool { return false }
+func (r *ioRing) accept(_ int) error     { return errorx.ErrIOURingUnavailable }
+func (r *ioRing) attach(_ *conn) error   { return errorx.ErrIOURingUnavailable }
+func (r *ioRing) write(_ *conn) error    { return errorx.ErrIOURingUnavailable }
+func (r *ioRing) detach(_ *conn) error   { return errorx.ErrIOURingUnavailable }
+func (r *ioRing) close()                 {}
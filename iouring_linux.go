@@ -0,0 +1,346 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/internal/netpoll"
+	"github.com/panjf2000/gnet/v2/internal/queue"
+	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
+	"github.com/panjf2000/gnet/v2/pkg/logging"
+)
+
+const (
+	// ringEntries is the size of the submission queue of each event-loop.
+	ringEntries = 1024
+	// ringBufGroup is the ID of the buffer ring that feeds the multishot recv requests.
+	ringBufGroup = 0
+	// ringBufMemory is the amount of memory of the buffer ring in each event-loop.
+	ringBufMemory = 1 << 20
+	// minRingBufs is the minimum number of buffers in the buffer ring.
+	minRingBufs = 16
+)
+
+type ringOpKind uint8
+
+const (
+	ringOpAccept ringOpKind = iota
+	ringOpRecv
+	ringOpWrite
+)
+
+// ringOp is an outstanding request in io_uring, it retains the memory of the request
+// until the request is done.
+type ringOp struct {
+	kind   ringOpKind
+	fd     int          // listener of ringOpAccept
+	c      *conn        // connection of ringOpRecv and ringOpWrite
+	iov    [][]byte     // data being written by ringOpWrite
+	iovecs []unix.Iovec // iovecs of iov
+}
+
+// ioRing drives the listeners and connections of TCP in an event-loop with io_uring:
+// the listeners accept connections by multishot accept, the connections receive data
+// by multishot recv with the buffers picked from a buffer ring, and the outbound data
+// of the connections is written by the writev requests that are submitted in a batch.
+//
+// The ring is registered with the poller of the event-loop, so it works along with
+// the file descriptors that are still driven by the poller, such as the event-fd for
+// asynchronous tasks, UDP sockets, Unix domain sockets and so on.
+type ioRing struct {
+	*netpoll.Ring
+	el        *eventloop
+	pa        *netpoll.PollAttachment // retains the attachment registered with the poller
+	bufs      *netpoll.BufRing
+	ops       map[uint64]*ringOp
+	lastID    uint64
+	listeners []int   // listeners accepting connections by multishot accept
+	dirty     []*conn // connections with outbound data that is waiting for the next submission
+	reaping   bool    // whether the completions are being processed
+	scheduled bool    // whether a submission has been scheduled in the task queue
+}
+
+// openRing sets up io_uring for el, ErrIOURingUnavailable is returned if the kernel
+// doesn't support it, in which case el keeps working with the poller.
+func (el *eventloop) openRing() error {
+	r, err := netpoll.OpenRing(ringEntries)
+	if err != nil {
+		return err
+	}
+	n := minRingBufs
+	for n*el.engine.opts.ReadBufferCap < ringBufMemory {
+		n <<= 1
+	}
+	bufs, err := r.RegisterBufRing(ringBufGroup, n, el.engine.opts.ReadBufferCap)
+	if err != nil {
+		_ = r.Close()
+		return err
+	}
+	ring := &ioRing{Ring: r, el: el, bufs: bufs, ops: make(map[uint64]*ringOp)}
+	ring.pa = &netpoll.PollAttachment{FD: r.Fd(), Callback: ring.process}
+	if err = el.poller.AddRead(ring.pa, false); err != nil {
+		_ = r.Close()
+		return err
+	}
+	el.ring = ring
+	return nil
+}
+
+// supports reports whether the listener or the connection of the given network
+// is driven by the ring, it's TCP only.
+func (r *ioRing) supports(network string) bool {
+	return r != nil && (network == "tcp" || network == "tcp4" || network == "tcp6")
+}
+
+func (r *ioRing) add(op *ringOp) uint64 {
+	r.lastID++
+	r.ops[r.lastID] = op
+	return r.lastID
+}
+
+// schedule makes sure that the prepared requests will be submitted soon, they're
+// submitted after the completions have been processed or at the end of the current
+// batch of asynchronous tasks.
+func (r *ioRing) schedule() error {
+	if r.reaping || r.scheduled {
+		return nil
+	}
+	r.scheduled = true
+	return r.el.poller.Trigger(queue.HighPriority, func(_ interface{}) error {
+		r.scheduled = false
+		return r.submit()
+	}, nil)
+}
+
+// submit prepares the writes of the connections with outbound data and submits all
+// pending requests with one system call.
+func (r *ioRing) submit() error {
+	for i, c := range r.dirty {
+		r.dirty[i] = nil
+		c.ringDirty = false
+		if !c.opened || c.ringWriting || c.outboundBuffer.IsEmpty() {
+			continue
+		}
+		iov, _ := c.outboundBuffer.Peek(-1)
+		if len(iov) > iovMax {
+			iov = iov[:iovMax]
+		}
+		op := &ringOp{kind: ringOpWrite, c: c, iov: iov, iovecs: make([]unix.Iovec, len(iov))}
+		for j, b := range iov {
+			op.iovecs[j].Base = &b[0]
+			op.iovecs[j].SetLen(len(b))
+		}
+		if err := r.PrepareWritev(c.fd, op.iovecs, r.add(op)); err != nil {
+			return err
+		}
+		c.ringWriting = true
+	}
+	r.dirty = r.dirty[:0]
+	r.bufs.Commit()
+	return r.Submit()
+}
+
+// accept starts accepting connections from the listener.
+func (r *ioRing) accept(fd int) error {
+	if err := r.PrepareAcceptMultishot(fd, r.add(&ringOp{kind: ringOpAccept, fd: fd})); err != nil {
+		return err
+	}
+	r.listeners = append(r.listeners, fd)
+	return r.schedule()
+}
+
+// attach starts receiving data from the connection.
+func (r *ioRing) attach(c *conn) error {
+	c.inRing = true
+	return r.recv(c)
+}
+
+func (r *ioRing) recv(c *conn) error {
+	id := r.add(&ringOp{kind: ringOpRecv, c: c})
+	if err := r.PrepareRecvMultishot(c.fd, r.bufs, id); err != nil {
+		delete(r.ops, id)
+		return err
+	}
+	c.ringRecv = id
+	return r.schedule()
+}
+
+// write queues the connection up for writing its outbound data with the next submission.
+func (r *ioRing) write(c *conn) error {
+	if c.ringDirty || c.ringWriting {
+		return nil
+	}
+	c.ringDirty = true
+	r.dirty = append(r.dirty, c)
+	return r.schedule()
+}
+
+// detach cancels all requests of the connection before it's closed.
+func (r *ioRing) detach(c *conn) error {
+	c.ringRecv = 0
+	if err := r.PrepareCancelFD(c.fd, 0); err != nil {
+		return err
+	}
+	// Submit the cancellation right away, otherwise the requests will keep
+	// the socket open after the file descriptor has been closed.
+	return r.Submit()
+}
+
+// close cancels the multishot accepts and tears down the ring.
+func (r *ioRing) close() {
+	for _, fd := range r.listeners {
+		_ = r.PrepareCancelFD(fd, 0)
+	}
+	_ = r.Submit()
+	_ = r.Close()
+}
+
+// process handles the completions when the ring becomes readable.
+func (r *ioRing) process(_ int, _ netpoll.IOEvent, _ netpoll.IOFlags) (err error) {
+	r.reaping = true
+	r.Reap(func(userData uint64, res int32, flags uint32) bool {
+		switch err = r.complete(userData, res, flags); err {
+		case nil:
+		case errorx.ErrAcceptSocket, errorx.ErrEngineShutdown:
+			return false
+		default:
+			logging.Warnf("error occurs in event-loop: %v", err)
+			err = nil
+		}
+		return true
+	})
+	r.reaping = false
+	if err != nil {
+		return
+	}
+	return r.submit()
+}
+
+func (r *ioRing) complete(userData uint64, res int32, flags uint32) error {
+	op := r.ops[userData]
+	if op == nil {
+		return nil
+	}
+	if flags&netpoll.CQEFMore == 0 {
+		delete(r.ops, userData)
+	}
+	switch op.kind {
+	case ringOpAccept:
+		return r.accepted(op, res, flags)
+	case ringOpRecv:
+		return r.received(op, userData, res, flags)
+	case ringOpWrite:
+		return r.written(op, res)
+	}
+	return nil
+}
+
+func (r *ioRing) accepted(op *ringOp, res int32, flags uint32) error {
+	if flags&netpoll.CQEFMore == 0 && res != -int32(unix.ECANCELED) {
+		if err := r.PrepareAcceptMultishot(op.fd, r.add(op)); err != nil {
+			return err
+		}
+	}
+	if res < 0 {
+		switch err := unix.Errno(-res); err {
+		case unix.EINTR, unix.EAGAIN, unix.ECONNRESET, unix.ECONNABORTED, unix.ECANCELED:
+			return nil
+		default:
+			r.el.getLogger().Errorf("Accept() failed due to error: %v", err)
+			return errorx.ErrAcceptSocket
+		}
+	}
+
+	nfd := int(res)
+	sa, err := unix.Getpeername(nfd)
+	if err != nil {
+		// The connection has been reset before we got the address of the remote.
+		_ = unix.Close(nfd)
+		return nil
+	}
+	return r.el.accepted(op.fd, nfd, sa)
+}
+
+func (r *ioRing) received(op *ringOp, userData uint64, res int32, flags uint32) error {
+	var buf []byte
+	if flags&netpoll.CQEFBuffer != 0 {
+		bid := netpoll.BufferID(flags)
+		defer r.bufs.Recycle(bid)
+		if res > 0 {
+			buf = r.bufs.Buffer(bid)[:res]
+		}
+	}
+
+	c := op.c
+	if !c.opened || c.ringRecv != userData {
+		return nil // ignore stale connections
+	}
+	if flags&netpoll.CQEFMore == 0 {
+		c.ringRecv = 0
+	}
+	switch {
+	case res > 0:
+	case res == 0:
+		return r.el.close(c, os.NewSyscallError("read", io.EOF))
+	case unix.Errno(-res) == unix.ENOBUFS:
+		// The buffer ring has run out, resume receiving after the buffers are recycled.
+		return r.recv(c)
+	default:
+		return r.el.close(c, os.NewSyscallError("recv", unix.Errno(-res)))
+	}
+
+	c.buffer = buf
+	action := r.el.handler(c).OnTraffic(c)
+	switch action {
+	case None:
+	case Close:
+		return r.el.close(c, nil)
+	case Shutdown:
+		return errorx.ErrEngineShutdown
+	}
+	_, _ = c.inboundBuffer.Write(c.buffer)
+	c.buffer = c.buffer[:0]
+
+	if c.opened && c.ringRecv == 0 {
+		return r.recv(c)
+	}
+	return nil
+}
+
+func (r *ioRing) written(op *ringOp, res int32) error {
+	c := op.c
+	if !c.ringWriting {
+		return nil
+	}
+	c.ringWriting = false
+	if !c.opened {
+		// The connection has been closed while its outbound buffer was being written, the buffer
+		// is released now that the kernel is done with it, whether the write was canceled or not.
+		c.outboundBuffer.Release()
+		return nil
+	}
+	if res < 0 {
+		return r.el.close(c, os.NewSyscallError("writev", unix.Errno(-res)))
+	}
+	_, _ = c.outboundBuffer.Discard(int(res))
+	if !c.outboundBuffer.IsEmpty() {
+		return r.write(c)
+	}
+	return nil
+}
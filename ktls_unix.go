@@ -0,0 +1,221 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || netbsd || openbsd || darwin
+// +build linux freebsd dragonfly netbsd openbsd darwin
+
+package gnet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/panjf2000/gnet/v2/internal/socket"
+	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
+	"github.com/panjf2000/gnet/v2/pkg/tls"
+)
+
+// The types of the TLS records handled with kTLS.
+const (
+	recordTypeAlert           = 21
+	recordTypeHandshake       = 22
+	recordTypeApplicationData = 23
+
+	alertCloseNotify  = 0
+	alertLevelWarning = 1
+	alertLevelFatal   = 2
+)
+
+// kernelTLSUnsupported indicates that the kernel lacks kTLS, so that the
+// connections don't try to offload the record layer any longer.
+var kernelTLSUnsupported atomic.Bool
+
+// kernelCryptoInfo is the crypto state of kTLS in one direction.
+type kernelCryptoInfo struct {
+	version, cipher uint16
+	iv, key, salt   []byte
+	seq             [8]byte
+}
+
+func newKernelCryptoInfo(keys tls.TrafficKeys) (*kernelCryptoInfo, error) {
+	info := &kernelCryptoInfo{version: keys.Version, key: keys.Key, seq: keys.Seq}
+	if keys.Version != tls.VersionTLS12 && keys.Version != tls.VersionTLS13 {
+		return nil, errorx.ErrUnsupportedOp
+	}
+	switch keys.CipherSuite {
+	case tls.TLS_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256:
+		info.cipher, info.iv = socket.TLSCipherChaCha20Poly1305, keys.IV
+		return info, nil
+	}
+	switch len(keys.Key) {
+	case 16:
+		info.cipher = socket.TLSCipherAESGCM128
+	case 32:
+		info.cipher = socket.TLSCipherAESGCM256
+	default:
+		return nil, errorx.ErrUnsupportedOp
+	}
+	// The nonce of AES-GCM is the salt followed by the explicit part, which is the sequence number
+	// of the record in TLS 1.2, whereas TLS 1.3 derives the nonce from the 12-byte IV.
+	if keys.Version == tls.VersionTLS13 {
+		info.salt, info.iv = keys.IV[:4], keys.IV[4:]
+	} else {
+		seq := keys.Seq
+		info.salt, info.iv = keys.IV, seq[:]
+	}
+	return info, nil
+}
+
+// install installs the crypto state on the socket for receiving if rx is true or sending otherwise.
+func (info *kernelCryptoInfo) install(fd int, rx bool) error {
+	return socket.SetTLSCryptoInfo(fd, rx, info.version, info.cipher, info.iv, info.key, info.salt, info.seq)
+}
+
+// installKernelKeys installs the keys of the record layer on the socket for receiving if rx is true or sending otherwise.
+func installKernelKeys(fd int, rx bool, keys tls.TrafficKeys) error {
+	info, err := newKernelCryptoInfo(keys)
+	if err != nil {
+		return err
+	}
+	return info.install(fd, rx)
+}
+
+// offloadToKernel offloads the record layer of tc to the kernel once the data read or written by
+// the record layer in user space has been drained, it's put off until then and tried once only.
+// The connection stays in user space if the offload fails, false is returned if it's broken.
+func (tc *tlsConn) offloadToKernel() bool {
+	c := tc.raw.(*conn)
+	if c.InboundBuffered() > 0 || c.outboundPending() {
+		return true
+	}
+	tc.kernelTried = true
+	if c.isUnix || c.inRing || kernelTLSUnsupported.Load() {
+		return true
+	}
+
+	var partial bool
+	err := tc.rawTLSConn.HandOffRecordLayer(func(in, out tls.TrafficKeys) error {
+		rx, err := newKernelCryptoInfo(in)
+		if err != nil {
+			return err
+		}
+		tx, err := newKernelCryptoInfo(out)
+		if err != nil {
+			return err
+		}
+		if err = socket.SetTLSULP(c.fd); err != nil {
+			if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ENOPROTOOPT) {
+				kernelTLSUnsupported.Store(true)
+			}
+			return err
+		}
+		// The socket without the crypto state works as a plain TCP socket,
+		// which is no longer the case once one direction has been offloaded.
+		if err = rx.install(c.fd, true); err != nil {
+			return err
+		}
+		partial = true
+		return tx.install(c.fd, false)
+	})
+	if err != nil {
+		return !partial
+	}
+	tc.kernel.Store(true)
+	c.ktls = true
+	return true
+}
+
+// readTLSRecord reads the plaintext of the application data from the socket whose record layer
+// is offloaded to the kernel, the other records received in between are handled by the connection.
+func (el *eventloop) readTLSRecord(c *conn) (int, error) {
+	if el.tlsRecord == nil {
+		el.tlsRecord = make([]byte, socket.TLSRecordOOBSize)
+	}
+	for {
+		n, typ, err := socket.RecvTLSRecord(c.fd, el.buffer, el.tlsRecord)
+		if err != nil || n == 0 || typ == recordTypeApplicationData {
+			return n, err
+		}
+		if err = c.ctx.(*tlsConn).handleKernelRecord(typ, el.buffer[:n]); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// handleKernelRecord handles the content of a record other than the application data received
+// through kTLS, i.e. the alerts and the post-handshake messages like KeyUpdate in TLS 1.3.
+// io.EOF is returned if the peer has closed the connection with close_notify.
+func (tc *tlsConn) handleKernelRecord(typ byte, data []byte) error {
+	fd := tc.raw.Fd()
+	switch typ {
+	case recordTypeAlert:
+		if len(data) != 2 {
+			return errorx.ErrTLSUnexpectedRecord
+		}
+		if data[1] == alertCloseNotify {
+			tc.closeNotified = true
+			tc.closeNotify()
+			return io.EOF
+		}
+		return &net.OpError{Op: "remote error", Err: tls.AlertError(data[1])}
+	case recordTypeHandshake:
+		keyUpdate, err := tc.rawTLSConn.HandlePostHandshake(data)
+		if err != nil {
+			if a, remote, ok := tls.ErrorAlert(err); ok && !remote {
+				_, _ = socket.SendTLSRecord(fd, recordTypeAlert, []byte{alertLevelFatal, byte(a)})
+			}
+			return err
+		}
+		if keyUpdate == nil {
+			return nil
+		}
+		if err = installKernelKeys(fd, true, keyUpdate.In); err != nil || keyUpdate.Reply == nil {
+			return err
+		}
+		// The reply is written with the current keys ahead of the data pending in the
+		// outbound buffer, which is written with the new keys afterwards.
+		if _, err = socket.SendTLSRecord(fd, recordTypeHandshake, keyUpdate.Reply); err != nil {
+			return err
+		}
+		return installKernelKeys(fd, false, keyUpdate.Out)
+	default:
+		return errorx.ErrTLSUnexpectedRecord
+	}
+}
+
+// kernelCloseNotify sends close_notify through kTLS, it's deferred to the closing of the
+// connection if there is data pending in the outbound buffer, so that the alert follows the data.
+func (tc *tlsConn) kernelCloseNotify() {
+	if tc.kernelCloseNotified {
+		return
+	}
+	tc.kernelCloseNotified = true
+	c := tc.raw.(*conn)
+	if c.outboundPending() {
+		c.closeNotify = true
+		return
+	}
+	sendCloseNotify(c.fd)
+}
+
+// sendCloseNotify sends close_notify as a TLS record through kTLS on the socket.
+func sendCloseNotify(fd int) {
+	_, _ = socket.SendTLSRecord(fd, recordTypeAlert, []byte{alertLevelWarning, alertCloseNotify})
+}
@@ -0,0 +1,358 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || netbsd || openbsd || darwin
+// +build linux freebsd dragonfly netbsd openbsd darwin
+
+package gnet
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	tls2 "crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/panjf2000/gnet/v2/internal/socket"
+	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
+	"github.com/panjf2000/gnet/v2/pkg/tls"
+)
+
+// emulatedKTLS emulates the record layer of kTLS in one direction with the crypto state
+// that would be installed on the socket, which verifies the keys handed to the kernel.
+type emulatedKTLS struct {
+	version  uint16
+	aead     cipher.AEAD
+	salt, iv []byte
+	seq      [8]byte
+	explicit bool // whether the nonce is explicit, i.e. AES-GCM in TLS 1.2
+}
+
+func newEmulatedKTLS(keys tls.TrafficKeys) (*emulatedKTLS, error) {
+	info, err := newKernelCryptoInfo(keys)
+	if err != nil {
+		return nil, err
+	}
+	e := &emulatedKTLS{
+		version: info.version,
+		salt:    info.salt,
+		iv:      append([]byte(nil), info.iv...),
+		seq:     info.seq,
+	}
+	if info.cipher == socket.TLSCipherChaCha20Poly1305 {
+		e.aead, err = chacha20poly1305.New(info.key)
+		return e, err
+	}
+	block, err := aes.NewCipher(info.key)
+	if err != nil {
+		return nil, err
+	}
+	e.aead, err = cipher.NewGCM(block)
+	e.explicit = info.version == tls.VersionTLS12
+	return e, err
+}
+
+func increment(b []byte) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
+
+// nextNonce returns the nonce of the next record, along with its sequence number.
+func (e *emulatedKTLS) nextNonce() (nonce []byte, seq [8]byte) {
+	seq = e.seq
+	increment(e.seq[:])
+	nonce = append(append([]byte(nil), e.salt...), e.iv...)
+	if e.explicit {
+		increment(e.iv)
+		return
+	}
+	for i := range seq {
+		nonce[len(nonce)-len(seq)+i] ^= seq[i]
+	}
+	return
+}
+
+func (e *emulatedKTLS) seal(typ byte, p []byte) []byte {
+	nonce, seq := e.nextNonce()
+	if e.version == tls.VersionTLS13 {
+		inner := append(append([]byte(nil), p...), typ)
+		hdr := []byte{recordTypeApplicationData, 3, 3, 0, 0}
+		binary.BigEndian.PutUint16(hdr[3:], uint16(len(inner)+e.aead.Overhead()))
+		return e.aead.Seal(append([]byte(nil), hdr...), nonce, inner, hdr)
+	}
+	aad := append(seq[:], typ, 3, 3, 0, 0)
+	binary.BigEndian.PutUint16(aad[11:], uint16(len(p)))
+	rec := []byte{typ, 3, 3, 0, 0}
+	if e.explicit {
+		rec = append(rec, nonce[len(e.salt):]...)
+	}
+	binary.BigEndian.PutUint16(rec[3:], uint16(len(rec)-5+len(p)+e.aead.Overhead()))
+	return e.aead.Seal(rec, nonce, p, aad)
+}
+
+func (e *emulatedKTLS) open(rec []byte) (typ byte, p []byte, err error) {
+	nonce, seq := e.nextNonce()
+	hdr, payload := rec[:5], rec[5:]
+	if e.version == tls.VersionTLS13 {
+		if p, err = e.aead.Open(nil, nonce, payload, hdr); err != nil {
+			return
+		}
+		for len(p) > 0 && p[len(p)-1] == 0 {
+			p = p[:len(p)-1]
+		}
+		if len(p) == 0 {
+			return 0, nil, errors.New("missing record type")
+		}
+		return p[len(p)-1], p[:len(p)-1], nil
+	}
+	if e.explicit {
+		copy(nonce[len(e.salt):], payload)
+		payload = payload[len(nonce)-len(e.salt):]
+	}
+	aad := append(seq[:], hdr[0], 3, 3, 0, 0)
+	binary.BigEndian.PutUint16(aad[11:], uint16(len(payload)-e.aead.Overhead()))
+	p, err = e.aead.Open(nil, nonce, payload, aad)
+	return hdr[0], p, err
+}
+
+func TestKernelCryptoInfo(t *testing.T) {
+	seq := [8]byte{0, 0, 0, 0, 0, 0, 0, 7}
+	iv12 := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	for _, tc := range []struct {
+		keys     tls.TrafficKeys
+		cipher   uint16
+		iv, salt []byte
+	}{
+		{
+			tls.TrafficKeys{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, Key: make([]byte, 16), IV: iv12, Seq: seq},
+			socket.TLSCipherAESGCM128, iv12[4:], iv12[:4],
+		},
+		{
+			tls.TrafficKeys{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_256_GCM_SHA384, Key: make([]byte, 32), IV: iv12, Seq: seq},
+			socket.TLSCipherAESGCM256, iv12[4:], iv12[:4],
+		},
+		{
+			tls.TrafficKeys{Version: tls.VersionTLS13, CipherSuite: tls.TLS_CHACHA20_POLY1305_SHA256, Key: make([]byte, 32), IV: iv12, Seq: seq},
+			socket.TLSCipherChaCha20Poly1305, iv12, nil,
+		},
+		{
+			tls.TrafficKeys{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, Key: make([]byte, 16), IV: iv12[:4], Seq: seq},
+			socket.TLSCipherAESGCM128, seq[:], iv12[:4],
+		},
+		{
+			tls.TrafficKeys{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, Key: make([]byte, 32), IV: iv12, Seq: seq},
+			socket.TLSCipherChaCha20Poly1305, iv12, nil,
+		},
+	} {
+		info, err := newKernelCryptoInfo(tc.keys)
+		require.NoError(t, err)
+		assert.Equal(t, tc.keys.Version, info.version)
+		assert.Equal(t, tc.cipher, info.cipher)
+		assert.Equal(t, tc.iv, info.iv)
+		assert.Equal(t, tc.keys.Key, info.key)
+		assert.Equal(t, tc.salt, info.salt)
+		assert.Equal(t, seq, info.seq)
+	}
+
+	_, err := newKernelCryptoInfo(tls.TrafficKeys{Version: tls.VersionTLS11, CipherSuite: tls.TLS_RSA_WITH_AES_128_GCM_SHA256, Key: make([]byte, 16)})
+	assert.ErrorIs(t, err, errorx.ErrUnsupportedOp)
+}
+
+type emulatedKTLSConn struct {
+	rx, tx *emulatedKTLS
+}
+
+type testEmulatedKTLSServer struct {
+	*testTLSHandshakeServer
+	t       *testing.T
+	pending chan []byte
+}
+
+// OnTraffic hands the record layer off once the first message has arrived, and emulates
+// kTLS for the rest of the connection, where the records are read and written as they are.
+func (s *testEmulatedKTLSServer) OnTraffic(c Conn) (action Action) {
+	tc := c.(*tlsConn)
+	ec, _ := c.Context().(*emulatedKTLSConn)
+	if ec == nil {
+		buf, _ := c.Next(-1)
+		ec = &emulatedKTLSConn{}
+		err := tc.rawTLSConn.HandOffRecordLayer(func(in, out tls.TrafficKeys) (err error) {
+			if ec.rx, err = newEmulatedKTLS(in); err != nil {
+				return
+			}
+			ec.tx, err = newEmulatedKTLS(out)
+			return
+		})
+		if !assert.NoError(s.t, err) {
+			return Close
+		}
+		c.SetContext(ec)
+		tc.kernel.Store(true)
+		tc.kernelTried = true
+		_, _ = tc.raw.Write(ec.tx.seal(recordTypeApplicationData, buf))
+		return
+	}
+
+	for {
+		buf, _ := c.Peek(-1)
+		if len(buf) < 5 || len(buf) < 5+int(binary.BigEndian.Uint16(buf[3:])) {
+			return
+		}
+		rec, _ := c.Next(5 + int(binary.BigEndian.Uint16(buf[3:])))
+		typ, p, err := ec.rx.open(rec)
+		if !assert.NoError(s.t, err) {
+			return Close
+		}
+		if typ == recordTypeAlert {
+			// There is no kTLS on the socket to send close_notify through.
+			_, _ = tc.raw.Write(ec.tx.seal(recordTypeAlert, []byte{alertLevelWarning, alertCloseNotify}))
+			tc.kernelCloseNotified = true
+			return Close
+		}
+		if string(p) == "close" {
+			// The record is left in the outbound buffer as if the socket was full,
+			// close_notify must follow it once it's flushed on close.
+			rec = ec.tx.seal(recordTypeApplicationData, []byte("bye"))
+			_, _ = tc.raw.(*conn).bufferOutbound(rec)
+			tc.closeNotify()
+			s.pending <- rec
+			return Close
+		}
+		if string(p) != "keyupdate" {
+			_, _ = tc.raw.Write(ec.tx.seal(recordTypeApplicationData, p))
+			continue
+		}
+
+		// The KeyUpdate message that requests the update of the keys of both directions.
+		keyUpdate, err := tc.rawTLSConn.HandlePostHandshake([]byte{24, 0, 0, 1, 1})
+		if tc.rawTLSConn.ConnectionState().Version == tls.VersionTLS12 {
+			a, remote, ok := tls.ErrorAlert(err)
+			assert.True(s.t, ok && !remote, "want the local alert in TLS 1.2")
+			assert.EqualValues(s.t, 100, a, "want no_renegotiation")
+		} else if assert.NoError(s.t, err) && assert.NotNil(s.t, keyUpdate) {
+			in, err := newEmulatedKTLS(keyUpdate.In)
+			assert.NoError(s.t, err)
+			assert.NotEqual(s.t, ec.rx.aead, in.aead)
+			assert.Equal(s.t, []byte{24, 0, 0, 1, 0}, keyUpdate.Reply)
+			assert.Equal(s.t, [8]byte{}, keyUpdate.Out.Seq)
+			// The peer writes with the old keys since it hasn't updated them, so
+			// only the keys for writing are switched to verify the reply.
+			_, _ = tc.raw.Write(ec.tx.seal(recordTypeHandshake, keyUpdate.Reply))
+			ec.tx, err = newEmulatedKTLS(keyUpdate.Out)
+			assert.NoError(s.t, err)
+		}
+		_, _ = tc.raw.Write(ec.tx.seal(recordTypeApplicationData, []byte("updated")))
+	}
+}
+
+func TestTLSKernelTLSKeys(t *testing.T) {
+	server := &testEmulatedKTLSServer{
+		testTLSHandshakeServer: &testTLSHandshakeServer{eng: make(chan Engine, 1), ready: make(chan struct{})},
+		t:                      t,
+		pending:                make(chan []byte, 1),
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9957", WithTicker(true), WithTLSConfig(getServerConfig()))
+	}()
+	eng := <-server.eng
+	<-server.ready
+
+	for _, suite := range []uint16{
+		0, // TLS 1.3
+		tls2.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls2.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls2.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	} {
+		config := getGoClientTLSConfig()
+		if suite != 0 {
+			config.MaxVersion = tls2.VersionTLS12
+			config.CipherSuites = []uint16{suite}
+		}
+		raw, err := net.Dial("tcp", "127.0.0.1:9957")
+		require.NoError(t, err)
+		c := tls2.Client(raw, config)
+		_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+		for _, msg := range []string{"ping", "pong", "pang", "keyupdate", "pung"} {
+			_, err = c.Write([]byte(msg))
+			require.NoError(t, err)
+			if msg == "keyupdate" {
+				msg = "updated"
+			}
+			buf := make([]byte, len(msg))
+			_, err = io.ReadFull(c, buf)
+			require.NoError(t, err, tls2.CipherSuiteName(suite))
+			require.Equal(t, msg, string(buf))
+		}
+		require.NoError(t, c.CloseWrite())
+		_, err = c.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+		_ = c.Close()
+	}
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+}
+
+func TestTLSKernelTLSCloseNotify(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("close_notify is written as it is only on Linux without kTLS")
+	}
+	server := &testEmulatedKTLSServer{
+		testTLSHandshakeServer: &testTLSHandshakeServer{eng: make(chan Engine, 1), ready: make(chan struct{})},
+		t:                      t,
+		pending:                make(chan []byte, 1),
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9956", WithTicker(true), WithTLSConfig(getServerConfig()))
+	}()
+	eng := <-server.eng
+	<-server.ready
+
+	raw, err := net.Dial("tcp", "127.0.0.1:9956")
+	require.NoError(t, err)
+	defer raw.Close()
+	c := tls2.Client(raw, getGoClientTLSConfig())
+	_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, len("ping"))
+	_, err = io.ReadFull(c, buf)
+	require.NoError(t, err)
+
+	// Without kTLS on the socket, close_notify is written in plaintext after the pending record.
+	_, err = c.Write([]byte("close"))
+	require.NoError(t, err)
+	rec := <-server.pending
+	buf, err = io.ReadAll(raw)
+	require.NoError(t, err)
+	require.Equal(t, append(rec, alertLevelWarning, alertCloseNotify), buf)
+
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+}
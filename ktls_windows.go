@@ -0,0 +1,24 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+// offloadToKernel keeps the record layer of tc in user space since kTLS is only available on Linux.
+func (tc *tlsConn) offloadToKernel() bool {
+	tc.kernelTried = true
+	return true
+}
+
+// kernelCloseNotify is a no-op since the record layer is never offloaded to the kernel on Windows.
+func (*tlsConn) kernelCloseNotify() {}
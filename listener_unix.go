@@ -57,8 +57,10 @@ func (ln *listener) normalize() (err error) {
 	case "udp", "udp4", "udp6":
 		ln.fd, ln.addr, err = socket.UDPSocket(ln.network, ln.address, false, ln.sockOpts...)
 		ln.network = "udp"
-	case "unix":
-		_ = os.RemoveAll(ln.address)
+	case "unix", "unixgram", "unixpacket":
+		if !socket.IsAbstractUnixAddr(ln.address) {
+			_ = os.RemoveAll(ln.address)
+		}
 		ln.fd, ln.addr, err = socket.UnixSocket(ln.network, ln.address, true, ln.sockOpts...)
 	default:
 		err = errors.ErrUnsupportedProtocol
@@ -72,7 +74,7 @@ func (ln *listener) close() {
 			if ln.fd > 0 {
 				logging.Error(os.NewSyscallError("close", unix.Close(ln.fd)))
 			}
-			if ln.network == "unix" {
+			if strings.HasPrefix(ln.network, "unix") && !socket.IsAbstractUnixAddr(ln.address) {
 				logging.Error(os.RemoveAll(ln.address))
 			}
 		})
@@ -80,7 +82,8 @@ func (ln *listener) close() {
 
 func initListener(network, addr string, options *Options) (l *listener, err error) {
 	var sockOpts []socket.Option
-	if options.ReusePort || strings.HasPrefix(network, "udp") {
+	// Unix domain sockets can't share the same address with SO_REUSEPORT.
+	if (options.ReusePort || strings.HasPrefix(network, "udp")) && !strings.HasPrefix(network, "unix") {
 		sockOpt := socket.Option{SetSockOpt: socket.SetReuseport, Opt: 1}
 		sockOpts = append(sockOpts, sockOpt)
 	}
@@ -101,13 +104,30 @@ func initListener(network, addr string, options *Options) (l *listener, err erro
 		sockOpts = append(sockOpts, sockOpt)
 	}
 	if strings.HasPrefix(network, "udp") {
+		sockOpt := socket.Option{SetSockOpt: socket.SetPktInfo, Opt: 1}
+		sockOpts = append(sockOpts, sockOpt)
 		udpAddr, err := net.ResolveUDPAddr(network, addr)
-		if err == nil && udpAddr.IP.IsMulticast() {
+		isMulticast := err == nil && udpAddr.IP.IsMulticast()
+		if isMulticast {
 			if sockoptFn := socket.SetMulticastMembership(network, udpAddr); sockoptFn != nil {
 				sockOpt := socket.Option{SetSockOpt: sockoptFn, Opt: options.MulticastInterfaceIndex}
 				sockOpts = append(sockOpts, sockOpt)
 			}
 		}
+		// The other UDP listeners keep the system default of the loopback unless it's enabled,
+		// they may join the groups at runtime by Engine.JoinGroup.
+		if isMulticast || options.MulticastLoopback {
+			var loop int
+			if options.MulticastLoopback {
+				loop = 1
+			}
+			sockOpt = socket.Option{SetSockOpt: socket.SetMulticastLoopback, Opt: loop}
+			sockOpts = append(sockOpts, sockOpt)
+		}
+		if options.MulticastTTL > 0 {
+			sockOpt = socket.Option{SetSockOpt: socket.SetMulticastTTL, Opt: options.MulticastTTL}
+			sockOpts = append(sockOpts, sockOpt)
+		}
 	}
 	l = &listener{network: network, address: addr, sockOpts: sockOpts}
 	err = l.normalize()
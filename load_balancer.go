@@ -17,6 +17,7 @@ package gnet
 import (
 	"hash/crc32"
 	"net"
+	"sync/atomic"
 
 	"github.com/panjf2000/gnet/v2/internal/bs"
 )
@@ -102,10 +103,10 @@ func (lb *baseLoadBalancer) len() int {
 
 // ==================================== Implementation of Round-Robin load-balancer ====================================
 
-// next returns the eligible event-loop based on Round-Robin algorithm.
+// next returns the eligible event-loop based on Round-Robin algorithm,
+// it's concurrency-safe since a Client dials from multiple goroutines.
 func (lb *roundRobinLoadBalancer) next(_ net.Addr) (el *eventloop) {
-	el = lb.eventLoops[lb.nextIndex%uint64(lb.size)]
-	lb.nextIndex++
+	el = lb.eventLoops[(atomic.AddUint64(&lb.nextIndex, 1)-1)%uint64(lb.size)]
 	return
 }
 
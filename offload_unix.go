@@ -0,0 +1,255 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || freebsd || dragonfly || netbsd || openbsd || darwin
+// +build linux freebsd dragonfly netbsd openbsd darwin
+
+package gnet
+
+import (
+	"runtime/debug"
+	"sync"
+
+	"github.com/panjf2000/gnet/v2/internal/queue"
+	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
+	"github.com/panjf2000/gnet/v2/pkg/pool/goroutine"
+)
+
+// defaultWorkerQueueSize is the default value of Options.WorkerQueueSize.
+const defaultWorkerQueueSize = 1 << 16
+
+// offloadTask is a function passed to Conn.Go along with its result.
+type offloadTask struct {
+	c     *conn
+	seq   uint64
+	fn    func() []byte
+	out   []byte
+	write func([]byte) (int, error)
+}
+
+// run calls the function on a worker and hands the result over to the event-loop of the connection.
+func (t *offloadTask) run() {
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				t.c.loop.getLogger().Errorf("panic occurs in the function passed to Conn.Go: %v\n%s", r, debug.Stack())
+			}
+		}()
+		t.out = t.fn()
+	}()
+	err := t.c.loop.poller.Trigger(queue.HighPriority, t.c.completeOffload, t)
+	if err != nil && !t.c.loop.engine.isInShutdown() {
+		t.c.loop.getLogger().Errorf("failed to enqueue the result of Conn.Go for fd=%d: %v", t.c.fd, err)
+	}
+}
+
+// offloader runs functions on a goroutine pool, such as the ones passed to Conn.Go, the functions
+// are queued up in a channel and submitted to the pool by a dispatcher, so that the event-loops are
+// never blocked by a full pool.
+type offloader struct {
+	once     sync.Once
+	mu       sync.RWMutex
+	released bool
+	pool     *goroutine.Pool
+	queue    chan func()
+	done     chan struct{}
+}
+
+// start creates the pool with the capacity of size and the queue that holds up to queueSize functions,
+// the default values are used if they're not positive.
+func (o *offloader) start(opts *Options, size, queueSize int) {
+	if size <= 0 {
+		size = goroutine.DefaultAntsPoolSize
+	}
+	if queueSize <= 0 {
+		queueSize = defaultWorkerQueueSize
+	}
+	pool, err := goroutine.NewBlocking(size)
+	if err != nil {
+		opts.Logger.Errorf("failed to create the goroutine pool: %v", err)
+		return
+	}
+	o.pool = pool
+	o.queue = make(chan func(), queueSize)
+	o.done = make(chan struct{})
+	go o.dispatch()
+}
+
+func (o *offloader) dispatch() {
+	for {
+		select {
+		case fn := <-o.queue:
+			// Submit blocks until a worker is available and fails after the pool has been released,
+			// in which case the function runs here instead of being dropped.
+			if o.pool.Submit(fn) != nil {
+				fn()
+			}
+		case <-o.done:
+			return
+		}
+	}
+}
+
+// submit queues fn up for the pool, which is started at the first call with size and queueSize.
+func (o *offloader) submit(opts *Options, size, queueSize int, fn func()) error {
+	o.once.Do(func() { o.start(opts, size, queueSize) })
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if o.pool == nil || o.released {
+		return errorx.ErrEngineInShutdown
+	}
+	select {
+	case o.queue <- fn:
+		return nil
+	default:
+		return errorx.ErrWorkerQueueFull
+	}
+}
+
+// release stops accepting functions and releases the goroutine pool, the functions that are still
+// queued up run on the caller rather than being dropped, it must be called after all event-loops
+// have exited.
+func (o *offloader) release() {
+	o.once.Do(func() {})
+	o.mu.Lock()
+	o.released = true
+	o.mu.Unlock()
+	if o.pool == nil {
+		return
+	}
+	close(o.done)
+	o.pool.Release()
+	for {
+		select {
+		case fn := <-o.queue:
+			fn()
+		default:
+			return
+		}
+	}
+}
+
+func (c *conn) Go(fn func() []byte) error {
+	return c.offload(fn, c.write)
+}
+
+// offload runs fn on the goroutine pool and writes the result with write in order.
+func (c *conn) offload(fn func() []byte, write func([]byte) (int, error)) error {
+	if c.isDatagram {
+		return errorx.ErrUnsupportedOp
+	}
+	opts := c.loop.engine.opts
+	t := &offloadTask{c: c, seq: c.goSeq, fn: fn, write: write}
+	if err := c.loop.engine.offload.submit(opts, opts.WorkerPoolSize, opts.WorkerQueueSize, t.run); err != nil {
+		return err
+	}
+	c.goSeq++
+	return nil
+}
+
+// completeOffload writes the result of the function if it's the next one in order,
+// along with the results that have finished ahead of their turns and are next to it.
+func (c *conn) completeOffload(itf interface{}) error {
+	t := itf.(*offloadTask)
+	if !c.opened {
+		return nil
+	}
+	if t.seq != c.goNext {
+		if c.goDone == nil {
+			c.goDone = make(map[uint64]*offloadTask)
+		}
+		c.goDone[t.seq] = t
+		return nil
+	}
+	for t != nil {
+		c.goNext++
+		if len(t.out) > 0 {
+			if _, err := t.write(t.out); err != nil {
+				return err
+			}
+		}
+		if t = c.goDone[c.goNext]; t != nil {
+			delete(c.goDone, c.goNext)
+		}
+	}
+	return nil
+}
+
+// tlsHandshakes holds the TLS handshakes of an event-loop whose steps are offloaded, it caps
+// the number of the steps running on the pool with Options.TLSHandshakesPerLoop, the steps
+// beyond the cap wait in the event-loop for their turns.
+type tlsHandshakes struct {
+	running int
+	waiting []func()
+}
+
+func (el *eventloop) maxTLSHandshakes() int {
+	if n := el.engine.opts.TLSHandshakesPerLoop; n > 0 {
+		return n
+	}
+	return el.engine.opts.TLSHandshakeWorkers
+}
+
+// offloadHandshake runs step, an offloaded step of the TLS handshake on the connection, on the
+// goroutine pool for TLS handshakes, and calls resume in the event-loop after step is done.
+func (c *conn) offloadHandshake(step func(), resume func() Action) {
+	el := c.loop
+	start := func() {
+		if !c.opened {
+			return
+		}
+		el.handshakes.running++
+		run := func() {
+			step()
+			err := c.runInLoop(func(err error) error {
+				el.finishTLSHandshake()
+				if err != nil {
+					return nil
+				}
+				switch resume() {
+				case Close:
+					return el.close(c, nil)
+				case Shutdown:
+					return errorx.ErrEngineShutdown
+				}
+				return nil
+			})
+			if err != nil && !el.engine.isInShutdown() {
+				el.getLogger().Errorf("failed to resume the TLS handshake for fd=%d: %v", c.fd, err)
+			}
+		}
+		opts := el.engine.opts
+		if err := el.engine.tlsOffload.submit(opts, opts.TLSHandshakeWorkers, 0, run); err != nil {
+			// The pool is unavailable, fall back to running the step in the event-loop.
+			run()
+		}
+	}
+	if el.handshakes.running >= el.maxTLSHandshakes() {
+		el.handshakes.waiting = append(el.handshakes.waiting, start)
+		return
+	}
+	start()
+}
+
+// finishTLSHandshake releases the slot of an offloaded step and starts the waiting ones.
+func (el *eventloop) finishTLSHandshake() {
+	hs := &el.handshakes
+	hs.running--
+	for len(hs.waiting) > 0 && hs.running < el.maxTLSHandshakes() {
+		start := hs.waiting[0]
+		hs.waiting[0] = nil
+		hs.waiting = hs.waiting[1:]
+		start()
+	}
+}
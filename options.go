@@ -41,6 +41,21 @@ const (
 	TCPDelay
 )
 
+// PollerMode is the strategy of the event-loops for dispatching I/O events.
+type PollerMode int
+
+// Available poller modes.
+const (
+	// PollerAuto picks PollerUltimate if gnet is built with the "poll_opt" tag, otherwise PollerDefault.
+	PollerAuto PollerMode = iota
+	// PollerDefault stores the file descriptor in the poller for each registration, the event-loop
+	// looks up the connection or listener of the file descriptor when an I/O event comes.
+	PollerDefault
+	// PollerUltimate stores a pointer to the callback of the connection or listener in the poller
+	// for each registration, the event-loop invokes the callback directly when an I/O event comes.
+	PollerUltimate
+)
+
 // Options are configurations for the gnet application.
 type Options struct {
 	// ================================== Options for only server-side ==================================
@@ -67,6 +82,31 @@ type Options struct {
 	// MulticastInterfaceIndex is the index of the interface name where the multicast UDP addresses will be bound to.
 	MulticastInterfaceIndex int
 
+	// MulticastTTL sets up the time-to-live (IPv4) or the hop limit (IPv6) of the multicast datagrams
+	// sent from UDP listeners, the system default (usually 1) is used if it's not greater than 0.
+	MulticastTTL int
+
+	// MulticastLoopback indicates whether the multicast datagrams sent from UDP listeners
+	// are looped back to the local sockets that joined the same group, it's disabled by default
+	// for the listeners bound to multicast addresses, while the other UDP listeners keep the
+	// system default unless it's enabled.
+	MulticastLoopback bool
+
+	// IOURing enables io_uring for the TCP listeners and connections on Linux 6.0 and later: connections
+	// are accepted by multishot accept, data is received by multishot recv into the buffers provided to
+	// the kernel, and the outbound data of connections in an event-loop is written in a batch with one
+	// system call. The event-loops fall back to epoll at runtime when io_uring is unavailable.
+	//
+	// Note that Conn.SendFile, Conn.AsyncWriteZeroCopy and Relay are not supported by the connections
+	// driven by io_uring, and the other protocols are always handled by epoll.
+	IOURing bool
+
+	// SocketBusyPoll sets SO_BUSY_POLL to BusyPollDuration on the accepted TCP sockets, so that the kernel
+	// busy-polls the device queue when receiving on them, it only works on Linux and requires CAP_NET_ADMIN
+	// if BusyPollDuration exceeds net.core.busy_read. It's tried on the listeners once when the engine starts,
+	// and disabled with the error logged if it fails.
+	SocketBusyPoll bool
+
 	// ============================= Options for both server-side and client-side =============================
 
 	// ReadBufferCap is the maximum number of bytes that can be read from the remote when the readable event comes.
@@ -129,8 +169,54 @@ type Options struct {
 	// Note that this option is only available for stream-oriented protocol.
 	EdgeTriggeredIO bool
 
-	// TLSConfig support TLS
+	// PollerMode decides how the epoll/kqueue event-loops dispatch I/O events at runtime,
+	// PollerAuto is used by default. This option is ignored on Windows.
+	PollerMode PollerMode
+
+	// BusyPollDuration makes the epoll/kqueue event-loops keep polling for I/O events without blocking
+	// for this long after the last I/O event arrived, which trades CPU for lower latency. It's disabled
+	// by default. The time that the event-loops spend on being busy and idle is reported by Engine.PollStats.
+	BusyPollDuration time.Duration
+
+	// WorkerPoolSize is the capacity of the goroutine pool that runs the functions passed to Conn.Go,
+	// the default value is 256K. The pool is created at the first call to Conn.Go and released when
+	// the engine or the client is shut down.
+	WorkerPoolSize int
+
+	// WorkerQueueSize is the maximum number of the functions passed to Conn.Go that can be waiting for
+	// the workers of the goroutine pool when it's full, the default value is 64K.
+	WorkerQueueSize int
+
+	// TLSConfig support TLS, the connections of the engine are served as the server side of TLS,
+	// whereas the connections of Client are the client side, whose OnOpen fires after the
+	// handshake has completed. The connections closed by Conn.Close or the Close action send
+	// close_notify to the peer, while the Shutdown action closes them abruptly, and the ones
+	// closed by the peer with close_notify are reported to OnClose with errors.ErrTLSCloseNotify.
 	TLSConfig *tls.Config
+
+	// TLSHandshakeWorkers is the capacity of the goroutine pool that runs the CPU-intensive steps of
+	// the server-side TLS handshakes, i.e. the key agreement, the signatures and tls.Config.GetCertificate,
+	// so that the handshakes don't block the other connections of the event-loops during connection storms.
+	// The steps run in the event-loops if it's 0, which is the default. This option is ignored on Windows.
+	TLSHandshakeWorkers int
+
+	// TLSHandshakesPerLoop caps the number of the offloaded steps of TLS handshakes that each event-loop
+	// keeps on the goroutine pool, the handshakes beyond the cap wait for their turns without taking up
+	// the event-loop, it defaults to TLSHandshakeWorkers.
+	TLSHandshakesPerLoop int
+
+	// TLSHandshakeTimeout is the maximum duration for the TLS handshake of a connection to complete,
+	// the connection is closed with errors.ErrTLSHandshakeTimeout after that, 0 means no timeout.
+	// The failed handshakes are counted by Engine.TLSHandshakeFailures and reported to
+	// TLSHandshakeErrorHandler.OnHandshakeError if the EventHandler implements it.
+	TLSHandshakeTimeout time.Duration
+
+	// KernelTLS offloads the record layer of the TLS connections to the kernel (kTLS) once their handshakes
+	// have completed on Linux, so that the plaintext is written with the plain writes and Conn.SendFile
+	// works for TLS too. The connections fall back to the record layer in user space if the tls module
+	// of the kernel is missing, the cipher suite isn't based on AES-GCM or ChaCha20-Poly1305, or they're
+	// Unix domain sockets or served by io_uring. This option is ignored on the other platforms.
+	KernelTLS bool
 }
 
 // WithOptions sets up all options.
@@ -259,6 +345,20 @@ func WithMulticastInterfaceIndex(idx int) Option {
 	}
 }
 
+// WithMulticastTTL sets the time-to-live or the hop limit of the multicast datagrams sent from UDP listeners.
+func WithMulticastTTL(ttl int) Option {
+	return func(opts *Options) {
+		opts.MulticastTTL = ttl
+	}
+}
+
+// WithMulticastLoopback enables the loopback of the multicast datagrams sent from UDP listeners.
+func WithMulticastLoopback(loop bool) Option {
+	return func(opts *Options) {
+		opts.MulticastLoopback = loop
+	}
+}
+
 // WithEdgeTriggeredIO enables the edge-triggered I/O for the underlying epoll/kqueue event-loop.
 func WithEdgeTriggeredIO(et bool) Option {
 	return func(opts *Options) {
@@ -266,9 +366,79 @@ func WithEdgeTriggeredIO(et bool) Option {
 	}
 }
 
+// WithPollerMode sets up the strategy of the event-loops for dispatching I/O events.
+func WithPollerMode(mode PollerMode) Option {
+	return func(opts *Options) {
+		opts.PollerMode = mode
+	}
+}
+
+// WithBusyPollDuration sets up the duration of busy-polling after the last I/O event in event-loops.
+func WithBusyPollDuration(d time.Duration) Option {
+	return func(opts *Options) {
+		opts.BusyPollDuration = d
+	}
+}
+
+// WithSocketBusyPoll sets SO_BUSY_POLL to BusyPollDuration on the accepted TCP sockets.
+func WithSocketBusyPoll(busyPoll bool) Option {
+	return func(opts *Options) {
+		opts.SocketBusyPoll = busyPoll
+	}
+}
+
+// WithWorkerPoolSize sets up the capacity of the goroutine pool for Conn.Go.
+func WithWorkerPoolSize(size int) Option {
+	return func(opts *Options) {
+		opts.WorkerPoolSize = size
+	}
+}
+
+// WithWorkerQueueSize sets up the maximum number of functions waiting for the goroutine pool of Conn.Go.
+func WithWorkerQueueSize(size int) Option {
+	return func(opts *Options) {
+		opts.WorkerQueueSize = size
+	}
+}
+
 // WithTLSConfig sets support TLS
 func WithTLSConfig(tlsConfig *tls.Config) Option {
 	return func(opts *Options) {
 		opts.TLSConfig = tlsConfig
 	}
 }
+
+// WithTLSHandshakeWorkers sets up the capacity of the goroutine pool for the steps of TLS handshakes.
+func WithTLSHandshakeWorkers(workers int) Option {
+	return func(opts *Options) {
+		opts.TLSHandshakeWorkers = workers
+	}
+}
+
+// WithTLSHandshakesPerLoop sets up the maximum number of TLS handshakes of each event-loop on the goroutine pool.
+func WithTLSHandshakesPerLoop(n int) Option {
+	return func(opts *Options) {
+		opts.TLSHandshakesPerLoop = n
+	}
+}
+
+// WithTLSHandshakeTimeout sets up the maximum duration of TLS handshakes.
+func WithTLSHandshakeTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.TLSHandshakeTimeout = timeout
+	}
+}
+
+// WithKernelTLS enables offloading the record layer of TLS to the kernel on Linux.
+func WithKernelTLS(kernelTLS bool) Option {
+	return func(opts *Options) {
+		opts.KernelTLS = kernelTLS
+	}
+}
+
+// WithIOURing enables io_uring for the TCP listeners and connections on Linux.
+func WithIOURing(ioURing bool) Option {
+	return func(opts *Options) {
+		opts.IOURing = ioURing
+	}
+}
@@ -4,11 +4,18 @@
 package gnet
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
+	"net/netip"
+	"os"
+	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -18,6 +25,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sys/unix"
 
+	"github.com/panjf2000/gnet/v2/internal/queue"
+	"github.com/panjf2000/gnet/v2/internal/socket"
+	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
 )
 
@@ -191,6 +201,143 @@ func TestMulticastBindIPv6(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestMulticastJoinLeave(t *testing.T) {
+	// 224.0.0.170 is an unassigned address from the Local Network Control Block,
+	// 232.0.0.170 is from the Source-Specific Multicast Block.
+	ts := &testMulticastJoinServer{
+		t:     t,
+		port:  9987,
+		group: net.IPv4(224, 0, 0, 170),
+		ssm:   net.IPv4(232, 0, 0, 170),
+		ch:    make(chan []byte, 16),
+		done:  make(chan struct{}),
+	}
+	err := Run(ts, "udp4://0.0.0.0:9987",
+		WithMulticastTTL(2),
+		WithMulticastLoopback(true),
+		WithTicker(true))
+	assert.NoError(t, err)
+}
+
+func TestMulticastLoopbackDefault(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the default of IP_MULTICAST_LOOP is checked on Linux")
+	}
+	// The UDP listeners that aren't bound to multicast addresses keep the system default.
+	ln, err := initListener("udp4", "127.0.0.1:9950", &Options{})
+	require.NoError(t, err)
+	defer ln.close()
+	loop, err := unix.GetsockoptInt(ln.fd, unix.IPPROTO_IP, unix.IP_MULTICAST_LOOP)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, loop)
+}
+
+type testMulticastJoinServer struct {
+	*BuiltinEventEngine
+	t       *testing.T
+	eng     Engine
+	port    int
+	group   net.IP
+	ssm     net.IP
+	ch      chan []byte
+	started int32
+	done    chan struct{}
+}
+
+func (s *testMulticastJoinServer) OnBoot(eng Engine) (action Action) {
+	s.eng = eng
+	return
+}
+
+func (s *testMulticastJoinServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	b := make([]byte, len(buf))
+	copy(b, buf)
+	s.ch <- b
+	return
+}
+
+func (s *testMulticastJoinServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer close(s.done)
+			s.checkSockOpts()
+			s.testGroup()
+			if runtime.GOOS == "linux" {
+				s.testSourceSpecificGroup()
+			}
+		}()
+	}
+	select {
+	case <-s.done:
+		action = Shutdown
+	default:
+	}
+	delay = 100 * time.Millisecond
+	return
+}
+
+func (s *testMulticastJoinServer) checkSockOpts() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	fd, err := s.eng.Dup()
+	require.NoError(s.t, err)
+	defer unix.Close(fd) //nolint:errcheck
+	ttl, err := unix.GetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_TTL)
+	require.NoError(s.t, err)
+	require.EqualValues(s.t, 2, ttl)
+	loop, err := unix.GetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_LOOP)
+	require.NoError(s.t, err)
+	require.EqualValues(s.t, 1, loop)
+}
+
+func (s *testMulticastJoinServer) testGroup() {
+	c, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: s.group, Port: s.port})
+	require.NoError(s.t, err)
+	defer c.Close()
+
+	require.ErrorIs(s.t, s.eng.JoinGroup(0, net.IPv4(127, 0, 0, 1)), errorx.ErrInvalidMulticastAddress)
+	require.NoError(s.t, s.eng.JoinGroup(0, s.group))
+	s.send(c, "joined", true)
+	require.NoError(s.t, s.eng.LeaveGroup(0, s.group))
+	s.send(c, "left", false)
+	require.NoError(s.t, s.eng.JoinGroup(0, s.group))
+	s.send(c, "rejoined", true)
+	require.NoError(s.t, s.eng.LeaveGroup(0, s.group))
+}
+
+func (s *testMulticastJoinServer) testSourceSpecificGroup() {
+	c, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: s.ssm, Port: s.port})
+	require.NoError(s.t, err)
+	defer c.Close()
+	source := c.LocalAddr().(*net.UDPAddr).IP
+
+	require.NoError(s.t, s.eng.JoinSourceSpecificGroup(0, s.ssm, net.IPv4(192, 0, 2, 254)))
+	s.send(c, "other-source", false)
+	require.NoError(s.t, s.eng.LeaveSourceSpecificGroup(0, s.ssm, net.IPv4(192, 0, 2, 254)))
+	require.NoError(s.t, s.eng.JoinSourceSpecificGroup(0, s.ssm, source))
+	s.send(c, "joined", true)
+	require.NoError(s.t, s.eng.LeaveSourceSpecificGroup(0, s.ssm, source))
+	s.send(c, "left", false)
+}
+
+func (s *testMulticastJoinServer) send(c net.Conn, msg string, delivered bool) {
+	_, err := c.Write([]byte(msg))
+	require.NoError(s.t, err)
+	timeout := time.Second
+	if !delivered {
+		timeout = 200 * time.Millisecond
+	}
+	select {
+	case b := <-s.ch:
+		require.Truef(s.t, delivered, "unexpected datagram: %s", b)
+		require.Equal(s.t, msg, string(b))
+	case <-time.After(timeout):
+		require.Falsef(s.t, delivered, "timeout receiving datagram: %s", msg)
+	}
+}
+
 /*
 func TestEngineAsyncWrite(t *testing.T) {
 	t.Run("tcp", func(t *testing.T) {
@@ -517,3 +664,1387 @@ func (s *testEngineClosedWakeUpServer) OnClose(Conn, error) (action Action) {
 	return
 }
 */
+
+func TestUDPPktInfo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("packet-info control messages are only supported on Linux")
+	}
+	t.Run("udp", func(t *testing.T) {
+		testUDPPktInfo(t, "udp://:9993", "127.0.0.2:9993")
+	})
+	t.Run("udp4", func(t *testing.T) {
+		testUDPPktInfo(t, "udp4://0.0.0.0:9994", "127.0.0.3:9994")
+	})
+}
+
+type testUDPPktInfoServer struct {
+	*BuiltinEventEngine
+	t       *testing.T
+	dstAddr string
+	started int32
+	done    chan struct{}
+}
+
+func (s *testUDPPktInfoServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	addr, ok := c.LocalAddr().(*net.UDPAddr)
+	require.True(s.t, ok)
+	require.Equal(s.t, s.dstAddr, addr.String())
+	_, err := c.Write(buf)
+	require.NoError(s.t, err)
+	return
+}
+
+func (s *testUDPPktInfoServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer close(s.done)
+			// A connected UDP socket drops the datagrams coming from any address other than
+			// the one it is connected to, so the replies must leave from the dialed address.
+			c, err := net.Dial("udp", s.dstAddr)
+			require.NoError(s.t, err)
+			defer c.Close()
+			for i := 0; i < 10; i++ {
+				msg := []byte(fmt.Sprintf("pktinfo-%d", i))
+				_, err = c.Write(msg)
+				require.NoError(s.t, err)
+				require.NoError(s.t, c.SetReadDeadline(time.Now().Add(time.Second)))
+				resp := make([]byte, 64)
+				n, err := c.Read(resp)
+				require.NoError(s.t, err)
+				require.Equal(s.t, msg, resp[:n])
+			}
+		}()
+	}
+	select {
+	case <-s.done:
+		action = Shutdown
+	default:
+	}
+	delay = 100 * time.Millisecond
+	return
+}
+
+func testUDPPktInfo(t *testing.T, protoAddr, dstAddr string) {
+	ts := &testUDPPktInfoServer{t: t, dstAddr: dstAddr, done: make(chan struct{})}
+	err := Run(ts, protoAddr, WithTicker(true))
+	assert.NoError(t, err)
+}
+
+func TestUnixFDPassing(t *testing.T) {
+	t.Run("LT", func(t *testing.T) {
+		testUnixFDPassing(t, "gnet-fds-lt.sock", false)
+	})
+	t.Run("ET", func(t *testing.T) {
+		testUnixFDPassing(t, "gnet-fds-et.sock", true)
+	})
+}
+
+type testUnixFDPassingServer struct {
+	*BuiltinEventEngine
+	t       *testing.T
+	addr    string
+	payload []byte
+	started int32
+	done    chan struct{}
+}
+
+func (s *testUnixFDPassingServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	require.Equal(s.t, "ping", string(buf))
+	fds := c.ReadFDs()
+	require.Len(s.t, fds, 1)
+	require.Nil(s.t, c.ReadFDs())
+	defer unix.Close(fds[0]) //nolint:errcheck
+
+	pid, uid, gid, err := c.PeerCred()
+	require.NoError(s.t, err)
+	if runtime.GOOS == "linux" {
+		require.Equal(s.t, os.Getpid(), pid)
+		require.Equal(s.t, os.Getgid(), gid)
+	}
+	require.Equal(s.t, os.Getuid(), uid)
+
+	_, err = c.WriteWithFDs(nil, fds)
+	require.ErrorIs(s.t, err, errorx.ErrFDsWithoutData)
+	// Fill up the socket buffer so that the file descriptors have to be queued up
+	// behind the pending data in the outbound buffer.
+	_, err = c.Write(s.payload)
+	require.NoError(s.t, err)
+	require.Greater(s.t, c.OutboundBuffered(), 0)
+	n, err := c.WriteWithFDs([]byte("pong"), fds)
+	require.NoError(s.t, err)
+	require.Equal(s.t, 4, n)
+	return
+}
+
+func (s *testUnixFDPassingServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer close(s.done)
+			s.runClient()
+		}()
+	}
+	select {
+	case <-s.done:
+		action = Shutdown
+	default:
+	}
+	delay = 100 * time.Millisecond
+	return
+}
+
+func (s *testUnixFDPassingServer) runClient() {
+	r, w, err := os.Pipe()
+	require.NoError(s.t, err)
+	defer r.Close()
+	defer w.Close()
+
+	c, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: s.addr, Net: "unix"})
+	require.NoError(s.t, err)
+	defer c.Close()
+	_, _, err = c.WriteMsgUnix([]byte("ping"), unix.UnixRights(int(w.Fd())), nil)
+	require.NoError(s.t, err)
+
+	var (
+		data []byte
+		fds  []int
+	)
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, unix.CmsgSpace(4*4))
+	require.NoError(s.t, c.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for len(data) < len(s.payload)+4 {
+		n, oobn, _, _, err := c.ReadMsgUnix(buf, oob)
+		require.NoError(s.t, err)
+		data = append(data, buf[:n]...)
+		if oobn > 0 {
+			msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+			require.NoError(s.t, err)
+			for i := range msgs {
+				rights, err := unix.ParseUnixRights(&msgs[i])
+				require.NoError(s.t, err)
+				// The file descriptors must arrive along with the data they were written with.
+				require.Greater(s.t, len(data), len(s.payload))
+				fds = append(fds, rights...)
+			}
+		}
+	}
+	require.Equal(s.t, s.payload, data[:len(s.payload)])
+	require.Equal(s.t, "pong", string(data[len(s.payload):]))
+	require.Len(s.t, fds, 1)
+
+	// The received file descriptor refers to the write end of the pipe.
+	f := os.NewFile(uintptr(fds[0]), "pipe")
+	defer f.Close()
+	_, err = f.Write([]byte("hello"))
+	require.NoError(s.t, err)
+	msg := make([]byte, 5)
+	_, err = io.ReadFull(r, msg)
+	require.NoError(s.t, err)
+	require.Equal(s.t, "hello", string(msg))
+}
+
+func testUnixFDPassing(t *testing.T, addr string, et bool) {
+	payload := make([]byte, 4*1024*1024)
+	_, err := rand.Read(payload)
+	require.NoError(t, err)
+	ts := &testUnixFDPassingServer{t: t, addr: addr, payload: payload, done: make(chan struct{})}
+	err = Run(ts, "unix://"+addr, WithEdgeTriggeredIO(et), WithTicker(true))
+	assert.NoError(t, err)
+}
+
+func TestUnixDatagram(t *testing.T) {
+	t.Run("pathname", func(t *testing.T) {
+		testUnixDatagram(t, "gnet-dgram-server.sock", "gnet-dgram-client.sock")
+	})
+	if runtime.GOOS == "linux" {
+		t.Run("abstract", func(t *testing.T) {
+			testUnixDatagram(t, "@gnet-dgram-server", "@gnet-dgram-client")
+		})
+	}
+}
+
+type testUnixDatagramServer struct {
+	*BuiltinEventEngine
+	t          *testing.T
+	addr       string
+	clientAddr string
+	started    int32
+	done       chan struct{}
+}
+
+func (s *testUnixDatagramServer) OnTraffic(c Conn) (action Action) {
+	require.Equal(s.t, "unixgram", c.RemoteAddr().Network())
+	require.Equal(s.t, s.clientAddr, c.RemoteAddr().String())
+	buf, _ := c.Next(-1)
+	_, err := c.Write(buf)
+	require.NoError(s.t, err)
+	return
+}
+
+func (s *testUnixDatagramServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer close(s.done)
+			s.runClient()
+		}()
+	}
+	select {
+	case <-s.done:
+		action = Shutdown
+	default:
+	}
+	delay = 100 * time.Millisecond
+	return
+}
+
+func (s *testUnixDatagramServer) runClient() {
+	if !socket.IsAbstractUnixAddr(s.clientAddr) {
+		_ = os.RemoveAll(s.clientAddr)
+		defer os.RemoveAll(s.clientAddr) //nolint:errcheck
+	}
+	c, err := net.DialUnix("unixgram",
+		&net.UnixAddr{Name: s.clientAddr, Net: "unixgram"},
+		&net.UnixAddr{Name: s.addr, Net: "unixgram"})
+	require.NoError(s.t, err)
+	defer c.Close()
+	require.NoError(s.t, c.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	buf := make([]byte, 1024)
+	for i := 1; i <= 10; i++ {
+		msg := bytes.Repeat([]byte{byte(i)}, i*10)
+		_, err = c.Write(msg)
+		require.NoError(s.t, err)
+		n, err := c.Read(buf)
+		require.NoError(s.t, err)
+		require.Equal(s.t, msg, buf[:n])
+	}
+}
+
+func testUnixDatagram(t *testing.T, addr, clientAddr string) {
+	ts := &testUnixDatagramServer{t: t, addr: addr, clientAddr: clientAddr, done: make(chan struct{})}
+	err := Run(ts, "unixgram://"+addr, WithMulticore(true), WithTicker(true))
+	assert.NoError(t, err)
+}
+
+func TestUnixPacket(t *testing.T) {
+	t.Run("LT", func(t *testing.T) {
+		testUnixPacket(t, "gnet-packet-lt.sock", false)
+	})
+	t.Run("ET", func(t *testing.T) {
+		testUnixPacket(t, "gnet-packet-et.sock", true)
+	})
+	if runtime.GOOS == "linux" {
+		t.Run("abstract", func(t *testing.T) {
+			testUnixPacket(t, "@gnet-packet", false)
+		})
+	}
+}
+
+type testUnixPacketServer struct {
+	*BuiltinEventEngine
+	t       *testing.T
+	addr    string
+	started int32
+	done    chan struct{}
+}
+
+const unixPacketMessages = 64
+
+func unixPacketMessage(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, (i+1)*1024+i)
+}
+
+func (s *testUnixPacketServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	require.Equal(s.t, "ping", string(buf))
+	// Write a batch of messages that exceeds the socket buffer, the pending ones in
+	// the outbound buffer must still arrive as separate messages.
+	for i := 0; i < unixPacketMessages; i++ {
+		msg := unixPacketMessage(i)
+		var err error
+		if i%2 == 0 {
+			_, err = c.Write(msg)
+		} else {
+			_, err = c.Writev([][]byte{msg[:i], msg[i:]})
+		}
+		require.NoError(s.t, err)
+	}
+	require.Greater(s.t, c.OutboundBuffered(), 0)
+	return
+}
+
+func (s *testUnixPacketServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer close(s.done)
+			s.runClient()
+		}()
+	}
+	select {
+	case <-s.done:
+		action = Shutdown
+	default:
+	}
+	delay = 100 * time.Millisecond
+	return
+}
+
+func (s *testUnixPacketServer) runClient() {
+	c, err := net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: s.addr, Net: "unixpacket"})
+	require.NoError(s.t, err)
+	defer c.Close()
+	_, err = c.Write([]byte("ping"))
+	require.NoError(s.t, err)
+	require.NoError(s.t, c.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	buf := make([]byte, 128*1024)
+	for i := 0; i < unixPacketMessages; i++ {
+		n, err := c.Read(buf)
+		require.NoError(s.t, err)
+		require.Equal(s.t, unixPacketMessage(i), buf[:n])
+	}
+}
+
+func testUnixPacket(t *testing.T, addr string, et bool) {
+	ts := &testUnixPacketServer{t: t, addr: addr, done: make(chan struct{})}
+	err := Run(ts, "unixpacket://"+addr, WithEdgeTriggeredIO(et), WithTicker(true))
+	assert.NoError(t, err)
+}
+
+func TestSendFile(t *testing.T) {
+	t.Run("LT", func(t *testing.T) {
+		testSendFile(t, "tcp://127.0.0.1:9986", false)
+	})
+	t.Run("ET", func(t *testing.T) {
+		testSendFile(t, "tcp://127.0.0.1:9985", true)
+	})
+}
+
+type testSendFileServer struct {
+	*BuiltinEventEngine
+	t       *testing.T
+	addr    string
+	file    *os.File
+	offset  int64
+	header  []byte
+	sent    int32
+	started int32
+	done    chan struct{}
+}
+
+func (s *testSendFileServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	require.Equal(s.t, "ping", string(buf))
+
+	// Fill up the socket buffer so that the files have to be queued up
+	// behind the pending data in the outbound buffer.
+	_, err := c.Write(s.header)
+	require.NoError(s.t, err)
+	require.Greater(s.t, c.OutboundBuffered(), 0)
+	callback := func(c Conn, err error) error {
+		require.NoError(s.t, err)
+		atomic.AddInt32(&s.sent, 1)
+		return nil
+	}
+	require.NoError(s.t, c.SendFile(s.file, s.offset, 1024*1024, callback))
+	require.NoError(s.t, c.SendFile(s.file, s.offset+1024*1024, 0, callback))
+	require.NoError(s.t, c.AsyncWrite([]byte("pong"), nil))
+	return
+}
+
+func (s *testSendFileServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer close(s.done)
+			s.runClient()
+		}()
+	}
+	select {
+	case <-s.done:
+		action = Shutdown
+	default:
+	}
+	delay = 100 * time.Millisecond
+	return
+}
+
+func (s *testSendFileServer) runClient() {
+	content, err := os.ReadFile(s.file.Name())
+	require.NoError(s.t, err)
+	expected := append(append(append([]byte{}, s.header...), content[s.offset:]...), "pong"...)
+
+	c, err := net.Dial("tcp", s.addr)
+	require.NoError(s.t, err)
+	defer c.Close()
+	_, err = c.Write([]byte("ping"))
+	require.NoError(s.t, err)
+	require.NoError(s.t, c.SetReadDeadline(time.Now().Add(10*time.Second)))
+	data := make([]byte, len(expected))
+	_, err = io.ReadFull(c, data)
+	require.NoError(s.t, err)
+	require.Equal(s.t, expected, data)
+	require.EqualValues(s.t, 2, atomic.LoadInt32(&s.sent))
+}
+
+func testSendFile(t *testing.T, protoAddr string, et bool) {
+	content := make([]byte, 8*1024*1024)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	f, err := os.CreateTemp(t.TempDir(), "gnet-sendfile")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.Write(content)
+	require.NoError(t, err)
+
+	header := make([]byte, 4*1024*1024)
+	_, err = rand.Read(header)
+	require.NoError(t, err)
+	ts := &testSendFileServer{
+		t:      t,
+		addr:   strings.TrimPrefix(protoAddr, "tcp://"),
+		file:   f,
+		offset: 1234,
+		header: header,
+		done:   make(chan struct{}),
+	}
+	err = Run(ts, protoAddr, WithEdgeTriggeredIO(et), WithTicker(true))
+	assert.NoError(t, err)
+}
+
+func TestAsyncWriteZeroCopy(t *testing.T) {
+	t.Run("LT", func(t *testing.T) {
+		testAsyncWriteZeroCopy(t, "tcp://127.0.0.1:9979", false)
+	})
+	t.Run("ET", func(t *testing.T) {
+		testAsyncWriteZeroCopy(t, "tcp://127.0.0.1:9978", true)
+	})
+	// MSG_ZEROCOPY is not supported by Unix domain sockets, the buffers are sent by regular writes.
+	t.Run("Unix", func(t *testing.T) {
+		testAsyncWriteZeroCopy(t, "unix://gnet-zerocopy.sock", false)
+	})
+}
+
+type testZeroCopyServer struct {
+	*BuiltinEventEngine
+	t       *testing.T
+	network string
+	addr    string
+	header  []byte
+	bufs    [][]byte
+	sent    int32
+	started int32
+	done    chan struct{}
+}
+
+func (s *testZeroCopyServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	require.Equal(s.t, "ping", string(buf))
+
+	// Fill up the socket buffer so that the zero-copy buffers have to be
+	// queued up behind the pending data in the outbound buffer.
+	_, err := c.Write(s.header)
+	require.NoError(s.t, err)
+	require.Greater(s.t, c.OutboundBuffered(), 0)
+	for _, b := range s.bufs {
+		b := b
+		require.NoError(s.t, c.AsyncWriteZeroCopy(b, func(c Conn, err error) error {
+			require.NoError(s.t, err)
+			// The buffer is no longer used by the kernel, scribble on it.
+			for i := range b {
+				b[i] = 0
+			}
+			atomic.AddInt32(&s.sent, 1)
+			return nil
+		}))
+	}
+	require.NoError(s.t, c.AsyncWrite([]byte("pong"), nil))
+	return
+}
+
+func (s *testZeroCopyServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer close(s.done)
+			s.runClient()
+		}()
+	}
+	select {
+	case <-s.done:
+		action = Shutdown
+	default:
+	}
+	delay = 100 * time.Millisecond
+	return
+}
+
+func (s *testZeroCopyServer) runClient() {
+	expected := append([]byte{}, s.header...)
+	for _, b := range s.bufs {
+		expected = append(expected, b...)
+	}
+	expected = append(expected, "pong"...)
+
+	c, err := net.Dial(s.network, s.addr)
+	require.NoError(s.t, err)
+	defer c.Close()
+	_, err = c.Write([]byte("ping"))
+	require.NoError(s.t, err)
+	require.NoError(s.t, c.SetReadDeadline(time.Now().Add(10*time.Second)))
+	data := make([]byte, len(expected))
+	_, err = io.ReadFull(c, data)
+	require.NoError(s.t, err)
+	require.Equal(s.t, expected, data)
+	require.Eventually(s.t, func() bool {
+		return atomic.LoadInt32(&s.sent) == int32(len(s.bufs))
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func testAsyncWriteZeroCopy(t *testing.T, protoAddr string, et bool) {
+	header := make([]byte, 4*1024*1024)
+	_, err := rand.Read(header)
+	require.NoError(t, err)
+	var bufs [][]byte
+	for _, size := range []int{16, 1024 * 1024, 3*1024*1024 + 7} {
+		b := make([]byte, size)
+		_, err = rand.Read(b)
+		require.NoError(t, err)
+		bufs = append(bufs, b)
+	}
+	network, addr, _ := strings.Cut(protoAddr, "://")
+	ts := &testZeroCopyServer{
+		t:       t,
+		network: network,
+		addr:    addr,
+		header:  header,
+		bufs:    bufs,
+		done:    make(chan struct{}),
+	}
+	err = Run(ts, protoAddr, WithEdgeTriggeredIO(et), WithTicker(true))
+	assert.NoError(t, err)
+}
+
+func TestRelay(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("splice(2) is only available on Linux")
+	}
+	t.Run("LT", func(t *testing.T) {
+		testRelay(t, "tcp://127.0.0.1:9984", false, false)
+	})
+	t.Run("ET", func(t *testing.T) {
+		testRelay(t, "tcp://127.0.0.1:9983", true, false)
+	})
+	t.Run("LT-multicore", func(t *testing.T) {
+		testRelay(t, "tcp://127.0.0.1:9982", false, true)
+	})
+	t.Run("ET-multicore", func(t *testing.T) {
+		testRelay(t, "tcp://127.0.0.1:9981", true, true)
+	})
+}
+
+type testRelayServer struct {
+	*BuiltinEventEngine
+	t        *testing.T
+	addr     string
+	backend  net.Listener
+	cli      *Client
+	payload  []byte
+	relayed  int32
+	closed   int32
+	started  int32
+	done     chan struct{}
+	finished chan struct{}
+}
+
+func (s *testRelayServer) OnTraffic(c Conn) (action Action) {
+	if c.Context() != nil {
+		// The relay hasn't been established yet, just leave the data in the inbound buffer.
+		return
+	}
+	// Leave the data in the inbound buffer, it must be forwarded by the relay.
+	buf, err := c.Peek(5)
+	require.NoError(s.t, err)
+	require.Equal(s.t, "hello", string(buf))
+	b, err := s.cli.Dial("tcp", s.backend.Addr().String())
+	require.NoError(s.t, err)
+	c.SetContext(b)
+	require.NoError(s.t, Relay(c, b))
+	atomic.AddInt32(&s.relayed, 1)
+	return
+}
+
+func (s *testRelayServer) OnClose(c Conn, _ error) (action Action) {
+	if b, ok := c.Context().(Conn); ok {
+		atomic.AddInt32(&s.closed, 1)
+		// The backend connection has been handed back, close it.
+		require.NoError(s.t, b.Close())
+	}
+	return
+}
+
+func (s *testRelayServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer close(s.done)
+			s.runClient()
+		}()
+	}
+	select {
+	case <-s.done:
+		action = Shutdown
+	default:
+	}
+	delay = 100 * time.Millisecond
+	return
+}
+
+func (s *testRelayServer) runClient() {
+	c, err := net.Dial("tcp", s.addr)
+	require.NoError(s.t, err)
+	defer c.Close()
+	_, err = c.Write([]byte("hello"))
+	require.NoError(s.t, err)
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := c.Write(s.payload)
+		writeErr <- err
+	}()
+	require.NoError(s.t, c.SetReadDeadline(time.Now().Add(10*time.Second)))
+	data := make([]byte, 5+len(s.payload))
+	_, err = io.ReadFull(c, data)
+	require.NoError(s.t, err)
+	require.NoError(s.t, <-writeErr)
+	require.Equal(s.t, "hello", string(data[:5]))
+	require.Equal(s.t, s.payload, data[5:])
+	require.NoError(s.t, c.Close())
+
+	// The backend connection is closed after the client connection has been closed.
+	select {
+	case <-s.finished:
+	case <-time.After(5 * time.Second):
+		require.Fail(s.t, "backend connection is not closed")
+	}
+	require.EqualValues(s.t, 1, atomic.LoadInt32(&s.relayed))
+	require.EqualValues(s.t, 1, atomic.LoadInt32(&s.closed))
+}
+
+type testRelayClient struct {
+	*BuiltinEventEngine
+	t *testing.T
+}
+
+func (cli *testRelayClient) OnTraffic(_ Conn) (action Action) {
+	require.Fail(cli.t, "OnTraffic must not be invoked during the relay")
+	return
+}
+
+func testRelay(t *testing.T, protoAddr string, et, multicore bool) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer backend.Close()
+	finished := make(chan struct{})
+	go func() {
+		c, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _ = io.Copy(c, c)
+		close(finished)
+	}()
+
+	cli, err := NewClient(&testRelayClient{t: t}, WithEdgeTriggeredIO(et))
+	require.NoError(t, err)
+	require.NoError(t, cli.Start())
+	defer cli.Stop() //nolint:errcheck
+
+	payload := make([]byte, 8*1024*1024)
+	_, err = rand.Read(payload)
+	require.NoError(t, err)
+	ts := &testRelayServer{
+		t:        t,
+		addr:     strings.TrimPrefix(protoAddr, "tcp://"),
+		backend:  backend,
+		cli:      cli,
+		payload:  payload,
+		done:     make(chan struct{}),
+		finished: finished,
+	}
+	err = Run(ts, protoAddr, WithEdgeTriggeredIO(et), WithMulticore(multicore), WithTicker(true))
+	assert.NoError(t, err)
+}
+
+func TestIOURing(t *testing.T) {
+	t.Run("Multicore", func(t *testing.T) {
+		testIOURing(t, "tcp://127.0.0.1:9977", false)
+	})
+	t.Run("ReusePort", func(t *testing.T) {
+		testIOURing(t, "tcp://127.0.0.1:9976", true)
+	})
+}
+
+type testIOURingServer struct {
+	*BuiltinEventEngine
+	t       *testing.T
+	addr    string
+	clients int
+	opened  int32
+	closed  int32
+	inRing  int32
+	started int32
+	done    chan struct{}
+}
+
+func (s *testIOURingServer) OnOpen(c Conn) (out []byte, action Action) {
+	atomic.AddInt32(&s.opened, 1)
+	if c.(*conn).inRing {
+		atomic.AddInt32(&s.inRing, 1)
+		err := c.SendFile(os.Stdin, 0, 1, nil)
+		require.ErrorIs(s.t, err, errorx.ErrUnsupportedOp)
+	}
+	return
+}
+
+func (s *testIOURingServer) OnClose(Conn, error) (action Action) {
+	atomic.AddInt32(&s.closed, 1)
+	return
+}
+
+func (s *testIOURingServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	if c.Fd()%2 == 0 {
+		_, err := c.Write(buf)
+		require.NoError(s.t, err)
+		return
+	}
+	require.NoError(s.t, c.AsyncWrite(bytes.Clone(buf), nil))
+	return
+}
+
+func (s *testIOURingServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer close(s.done)
+			var wg sync.WaitGroup
+			for i := 0; i < s.clients; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.runClient()
+				}()
+			}
+			wg.Wait()
+			require.Eventually(s.t, func() bool {
+				return atomic.LoadInt32(&s.closed) == int32(s.clients)
+			}, 5*time.Second, 10*time.Millisecond)
+		}()
+	}
+	select {
+	case <-s.done:
+		action = Shutdown
+	default:
+	}
+	delay = 100 * time.Millisecond
+	return
+}
+
+func (s *testIOURingServer) runClient() {
+	c, err := net.Dial("tcp", s.addr)
+	require.NoError(s.t, err)
+	defer c.Close()
+	require.NoError(s.t, c.SetDeadline(time.Now().Add(10*time.Second)))
+	for i := 0; i < 10; i++ {
+		data := make([]byte, 1+rand.Intn(512*1024))
+		_, err = rand.Read(data)
+		require.NoError(s.t, err)
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := c.Write(data)
+			errCh <- err
+		}()
+		echo := make([]byte, len(data))
+		_, err = io.ReadFull(c, echo)
+		require.NoError(s.t, err)
+		require.NoError(s.t, <-errCh)
+		require.Equal(s.t, data, echo)
+	}
+}
+
+func testIOURing(t *testing.T, protoAddr string, reuseport bool) {
+	ts := &testIOURingServer{
+		t:       t,
+		addr:    strings.TrimPrefix(protoAddr, "tcp://"),
+		clients: 16,
+		done:    make(chan struct{}),
+	}
+	err := Run(ts, protoAddr,
+		WithIOURing(true),
+		WithNumEventLoop(4),
+		WithReusePort(reuseport),
+		WithTicker(true))
+	assert.NoError(t, err)
+	require.EqualValues(t, ts.clients, atomic.LoadInt32(&ts.opened))
+	if runtime.GOOS == "linux" && atomic.LoadInt32(&ts.inRing) == 0 {
+		t.Log("io_uring is unavailable, the connections were driven by the poller")
+	}
+}
+
+func TestProbeSocketBusyPoll(t *testing.T) {
+	ln, err := initListener("tcp", "127.0.0.1:9948", &Options{})
+	require.NoError(t, err)
+	defer ln.close()
+	eng := &engine{listeners: map[int]*listener{ln.fd: ln}, opts: &Options{Logger: logging.GetDefaultLogger()}}
+	// It requires CAP_NET_ADMIN on Linux since net.core.busy_read defaults to 0.
+	if usec := eng.probeSocketBusyPoll(1); usec != 0 {
+		require.EqualValues(t, 1, usec)
+	}
+
+	// It's disabled once it fails on the listeners.
+	eng.listeners[-1] = &listener{fd: -1, network: "tcp"}
+	require.Zero(t, eng.probeSocketBusyPoll(1))
+}
+
+type testIOURingCloseServer struct {
+	*BuiltinEventEngine
+	eng    chan Engine
+	closed chan *conn
+}
+
+func (s *testIOURingCloseServer) OnBoot(eng Engine) (action Action) {
+	s.eng <- eng
+	return
+}
+
+func (s *testIOURingCloseServer) OnOpen(_ Conn) (out []byte, action Action) {
+	// The data is far beyond the socket buffers, so the write keeps pending.
+	return make([]byte, 32<<20), None
+}
+
+func (s *testIOURingCloseServer) OnTraffic(c Conn) (action Action) {
+	s.closed <- c.(*conn)
+	return Close
+}
+
+func TestIOURingCloseWhileWriting(t *testing.T) {
+	ts := &testIOURingCloseServer{eng: make(chan Engine, 1), closed: make(chan *conn, 1)}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(ts, "tcp://127.0.0.1:9949", WithIOURing(true))
+	}()
+	eng := <-ts.eng
+	defer func() {
+		require.NoError(t, eng.Stop(context.Background()))
+		require.NoError(t, <-errCh)
+	}()
+
+	var (
+		c   net.Conn
+		err error
+	)
+	require.Eventually(t, func() bool {
+		c, err = net.Dial("tcp", "127.0.0.1:9949")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	defer c.Close()
+	time.Sleep(100 * time.Millisecond)
+	_, err = c.Write([]byte("close"))
+	require.NoError(t, err)
+	cc := <-ts.closed
+	if !cc.inRing {
+		t.Skip("io_uring is unavailable")
+	}
+
+	// The outbound buffer is retained until the canceled write completes.
+	state := make(chan [2]bool, 1)
+	require.Eventually(t, func() bool {
+		_ = cc.loop.poller.Trigger(queue.HighPriority, func(_ interface{}) error {
+			state <- [2]bool{cc.ringWriting, cc.outboundBuffer.IsEmpty()}
+			return nil
+		}, nil)
+		s := <-state
+		require.NotEqual(t, [2]bool{true, true}, s, "the outbound buffer is released while it's being written")
+		return !s[0] && s[1]
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestBusyPoll(t *testing.T) {
+	ts := &testBusyPollServer{
+		t:        t,
+		addr:     "127.0.0.1:9975",
+		busyPoll: 100 * time.Millisecond,
+	}
+	err := Run(ts, "tcp://"+ts.addr,
+		WithBusyPollDuration(ts.busyPoll),
+		WithSocketBusyPoll(true),
+		WithTicker(true))
+	assert.NoError(t, err)
+}
+
+type testBusyPollServer struct {
+	*BuiltinEventEngine
+	t        *testing.T
+	eng      Engine
+	addr     string
+	busyPoll time.Duration
+	started  int32
+	done     int32
+}
+
+func (s *testBusyPollServer) OnBoot(eng Engine) (action Action) {
+	s.eng = eng
+	return
+}
+
+func (s *testBusyPollServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	_, err := c.Write(buf)
+	require.NoError(s.t, err)
+	return
+}
+
+func (s *testBusyPollServer) OnTick() (delay time.Duration, action Action) {
+	delay = time.Second
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go s.runClient()
+	}
+	if atomic.LoadInt32(&s.done) == 1 {
+		action = Shutdown
+	}
+	return
+}
+
+func (s *testBusyPollServer) runClient() {
+	defer atomic.StoreInt32(&s.done, 1)
+
+	stats, err := s.eng.PollStats()
+	require.NoError(s.t, err)
+
+	c, err := net.Dial("tcp", s.addr)
+	require.NoError(s.t, err)
+	defer c.Close()
+	require.NoError(s.t, c.SetDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 4)
+	for i := 0; i < 3; i++ {
+		_, err = c.Write([]byte("ping"))
+		require.NoError(s.t, err)
+		_, err = io.ReadFull(c, buf)
+		require.NoError(s.t, err)
+		require.Equal(s.t, "ping", string(buf))
+		time.Sleep(2 * s.busyPoll)
+	}
+
+	// The event-loop kept polling for a while after each message before blocking again.
+	latest, err := s.eng.PollStats()
+	require.NoError(s.t, err)
+	require.GreaterOrEqual(s.t, latest.Busy-stats.Busy, 3*s.busyPoll)
+	require.Greater(s.t, latest.Idle, stats.Idle)
+}
+
+func TestConnGo(t *testing.T) {
+	t.Run("Ordered", func(t *testing.T) {
+		ts := &testConnGoServer{
+			t:       t,
+			addr:    "127.0.0.1:9974",
+			clients: 4,
+			done:    make(chan struct{}),
+		}
+		err := Run(ts, "tcp://"+ts.addr,
+			WithMulticore(true),
+			WithWorkerPoolSize(8),
+			WithTicker(true))
+		assert.NoError(t, err)
+	})
+	t.Run("QueueFull", func(t *testing.T) {
+		ts := &testConnGoServer{
+			t:       t,
+			addr:    "127.0.0.1:9973",
+			clients: 1,
+			blocker: make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		err := Run(ts, "tcp://"+ts.addr,
+			WithWorkerPoolSize(1),
+			WithWorkerQueueSize(1),
+			WithTicker(true))
+		assert.NoError(t, err)
+	})
+}
+
+func TestOffloaderRelease(t *testing.T) {
+	opts := &Options{Logger: logging.GetDefaultLogger()}
+	var (
+		o       offloader
+		ran     int32
+		blocker = make(chan struct{})
+	)
+	require.NoError(t, o.submit(opts, 1, 8, func() {
+		<-blocker
+		atomic.AddInt32(&ran, 1)
+	}))
+	for i := 0; i < 4; i++ {
+		require.NoError(t, o.submit(opts, 1, 8, func() { atomic.AddInt32(&ran, 1) }))
+	}
+	// The functions behind the blocked one are still waiting for the worker when the pool
+	// is released, they run anyway instead of being dropped.
+	time.AfterFunc(100*time.Millisecond, func() { close(blocker) })
+	o.release()
+	require.ErrorIs(t, o.submit(opts, 1, 8, func() {}), errorx.ErrEngineInShutdown)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&ran) == 5
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+type testConnGoServer struct {
+	*BuiltinEventEngine
+	t        *testing.T
+	addr     string
+	clients  int
+	blocker  chan struct{}
+	full     bool
+	accepted int32
+	started  int32
+	done     chan struct{}
+}
+
+func (s *testConnGoServer) OnTraffic(c Conn) (action Action) {
+	for !s.full && c.InboundBuffered() >= 4 {
+		buf, _ := c.Next(4)
+		id := append([]byte{}, buf...)
+		blocker := s.blocker
+		err := c.Go(func() []byte {
+			if blocker != nil {
+				<-blocker
+			} else {
+				time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+			}
+			return id
+		})
+		if errors.Is(err, errorx.ErrWorkerQueueFull) {
+			// Let the functions that have been accepted finish.
+			s.full = true
+			close(s.blocker)
+			break
+		}
+		require.NoError(s.t, err)
+		atomic.AddInt32(&s.accepted, 1)
+	}
+	if s.full {
+		_, _ = c.Discard(-1)
+	}
+	return
+}
+
+func (s *testConnGoServer) OnTick() (delay time.Duration, action Action) {
+	if atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		go func() {
+			defer close(s.done)
+			var wg sync.WaitGroup
+			for i := 0; i < s.clients; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.runClient()
+				}()
+			}
+			wg.Wait()
+		}()
+	}
+	select {
+	case <-s.done:
+		action = Shutdown
+	default:
+	}
+	delay = 100 * time.Millisecond
+	return
+}
+
+func (s *testConnGoServer) runClient() {
+	c, err := net.Dial("tcp", s.addr)
+	require.NoError(s.t, err)
+	defer c.Close()
+	require.NoError(s.t, c.SetDeadline(time.Now().Add(10*time.Second)))
+
+	const n = 100
+	req := make([]byte, 4*n)
+	for i := 0; i < n; i++ {
+		binary.BigEndian.PutUint32(req[4*i:], uint32(i))
+	}
+	_, err = c.Write(req)
+	require.NoError(s.t, err)
+
+	if s.blocker != nil {
+		// Only the functions accepted before the queue got full are replied,
+		// at most one is running, one is being submitted and one is queued up.
+		require.Eventually(s.t, func() bool { return atomic.LoadInt32(&s.accepted) > 0 }, 5*time.Second, 10*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		accepted := int(atomic.LoadInt32(&s.accepted))
+		require.LessOrEqual(s.t, accepted, 3)
+		resp := make([]byte, 4*accepted)
+		_, err = io.ReadFull(c, resp)
+		require.NoError(s.t, err)
+		require.Equal(s.t, req[:len(resp)], resp)
+		return
+	}
+
+	resp := make([]byte, len(req))
+	_, err = io.ReadFull(c, resp)
+	require.NoError(s.t, err)
+	require.Equal(s.t, req, resp)
+}
+
+func TestDialWithContext(t *testing.T) {
+	ev := &testDialClient{}
+	cli, err := NewClient(ev)
+	require.NoError(t, err)
+	require.NoError(t, cli.Start())
+	defer cli.Stop() //nolint:errcheck
+
+	t.Run("Concurrent", func(t *testing.T) {
+		sockFile := fmt.Sprintf("/tmp/gnet-dial-%d.sock", os.Getpid())
+		defer os.Remove(sockFile) //nolint:errcheck
+		for _, network := range []string{"tcp", "unix"} {
+			addr := "127.0.0.1:0"
+			if network == "unix" {
+				addr = sockFile
+			}
+			ln, err := net.Listen(network, addr)
+			require.NoError(t, err)
+			go func() {
+				for {
+					c, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						_, _ = io.Copy(c, c)
+						_ = c.Close()
+					}()
+				}
+			}()
+
+			const n = 200
+			received := atomic.LoadInt64(&ev.received)
+			results := make(chan error, n)
+			for i := 0; i < n; i++ {
+				i := i
+				err = cli.DialWithContext(context.Background(), network, ln.Addr().String(), &DialOptions{
+					Context: i,
+					Callback: func(c Conn, err error) error {
+						if err == nil {
+							if _, ok := ev.opened.Load(c); !ok {
+								err = errors.New("callback is invoked before OnOpen")
+							} else if c.Context() != i {
+								err = fmt.Errorf("unexpected context: %v", c.Context())
+							} else {
+								_, err = c.Write([]byte("ping"))
+							}
+						}
+						results <- err
+						return nil
+					},
+				})
+				require.NoError(t, err)
+			}
+			for i := 0; i < n; i++ {
+				select {
+				case err := <-results:
+					require.NoError(t, err)
+				case <-time.After(10 * time.Second):
+					t.Fatalf("timed out waiting for the dials over %s", network)
+				}
+			}
+			require.Eventually(t, func() bool {
+				return atomic.LoadInt64(&ev.received)-received == 4*n
+			}, 10*time.Second, 10*time.Millisecond)
+			require.NoError(t, ln.Close())
+		}
+	})
+
+	t.Run("Refused", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := ln.Addr().String()
+		require.NoError(t, ln.Close())
+
+		results := make(chan error, 1)
+		err = cli.DialWithContext(context.Background(), "tcp", addr, &DialOptions{
+			Callback: func(c Conn, err error) error {
+				assert.Nil(t, c)
+				results <- err
+				return nil
+			},
+		})
+		require.NoError(t, err)
+		require.ErrorIs(t, <-results, unix.ECONNREFUSED)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		// A listener with a backlog of zero doesn't complete the handshakes
+		// of the subsequent connections before the first one is accepted.
+		fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+		require.NoError(t, err)
+		defer unix.Close(fd) //nolint:errcheck
+		require.NoError(t, unix.Bind(fd, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}))
+		require.NoError(t, unix.Listen(fd, 0))
+		sa, err := unix.Getsockname(fd)
+		require.NoError(t, err)
+		addr := fmt.Sprintf("127.0.0.1:%d", sa.(*unix.SockaddrInet4).Port)
+		c, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer c.Close() //nolint:errcheck
+
+		results := make(chan error, 2)
+		callback := func(c Conn, err error) error {
+			assert.Nil(t, c)
+			results <- err
+			return nil
+		}
+		start := time.Now()
+		err = cli.DialWithContext(context.Background(), "tcp", addr, &DialOptions{
+			Timeout:  100 * time.Millisecond,
+			Callback: callback,
+		})
+		require.NoError(t, err)
+		require.ErrorIs(t, <-results, context.DeadlineExceeded)
+		require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		err = cli.DialWithContext(ctx, "tcp", addr, &DialOptions{Callback: callback})
+		require.NoError(t, err)
+		cancel()
+		require.ErrorIs(t, <-results, context.Canceled)
+
+		err = cli.DialWithContext(ctx, "tcp", addr, &DialOptions{Callback: callback})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Resolve", func(t *testing.T) {
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close() //nolint:errcheck
+		_, port, err := net.SplitHostPort(ln.Addr().String())
+		require.NoError(t, err)
+
+		results := make(chan error, 1)
+		err = cli.DialWithContext(context.Background(), "tcp4", net.JoinHostPort("localhost", port), &DialOptions{
+			Callback: func(c Conn, err error) error {
+				if err == nil {
+					err = c.Close()
+				}
+				results <- err
+				return nil
+			},
+		})
+		require.NoError(t, err)
+		require.NoError(t, <-results)
+
+		addr, err := resolveTCPAddr(context.Background(), "tcp", "127.0.0.1:"+port)
+		require.NoError(t, err)
+		require.Equal(t, "127.0.0.1:"+port, addr)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = resolveTCPAddr(ctx, "tcp", "gnet.invalid:"+port)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		err := cli.DialWithContext(context.Background(), "udp", "127.0.0.1:9972", nil)
+		require.ErrorIs(t, err, errorx.ErrUnsupportedProtocol)
+	})
+}
+
+type testDialClient struct {
+	*BuiltinEventEngine
+	opened   sync.Map
+	received int64
+}
+
+func (ev *testDialClient) OnOpen(c Conn) (out []byte, action Action) {
+	ev.opened.Store(c, struct{}{})
+	return
+}
+
+func (ev *testDialClient) OnClose(c Conn, _ error) (action Action) {
+	ev.opened.Delete(c)
+	return
+}
+
+func (ev *testDialClient) OnTraffic(c Conn) (action Action) {
+	n, _ := c.Discard(-1)
+	atomic.AddInt64(&ev.received, int64(n))
+	return
+}
+
+func TestClientPoolStalledLookup(t *testing.T) {
+	stalled := make(chan struct{}, 1)
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	var lookups int32
+	lookupNetIP = func(_ context.Context, _, _ string) ([]netip.Addr, error) {
+		// The first lookup succeeds, and the one of the redial stalls.
+		if atomic.AddInt32(&lookups, 1) == 1 {
+			return []netip.Addr{netip.MustParseAddr("127.0.0.1")}, nil
+		}
+		defer close(returned)
+		stalled <- struct{}{}
+		<-release
+		return nil, errors.New("lookup stalled")
+	}
+	defer func() { lookupNetIP = net.DefaultResolver.LookupNetIP }()
+
+	named := startPoolUpstream(t, "127.0.0.1:0")
+	defer named.ln.Close()
+	_, port, err := net.SplitHostPort(named.ln.Addr().String())
+	require.NoError(t, err)
+	namedAddr := net.JoinHostPort("upstream.gnet.test", port)
+	other := startPoolUpstream(t, "127.0.0.1:0")
+	defer other.ln.Close()
+	otherAddr := other.ln.Addr().String()
+
+	ev := &poolClient{responses: make(chan string, 16)}
+	pool, err := NewClientPool(ev, PoolOptions{MinConns: 1})
+	require.NoError(t, err)
+	require.NoError(t, pool.Start())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := pool.Checkout(ctx, namedAddr)
+	require.NoError(t, err)
+	pool.Return(c)
+	require.Eventually(t, func() bool {
+		named.mu.Lock()
+		defer named.mu.Unlock()
+		return len(named.conns) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The connection closed by the upstream is redialed, and the stalled lookup
+	// of the redial blocks neither the event-loop nor the callers of the pool.
+	named.closeConns()
+	select {
+	case <-stalled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the lookup of the redial")
+	}
+	checkedOut := make(chan error, 1)
+	go func() {
+		c, err := pool.Checkout(ctx, otherAddr)
+		if err == nil {
+			pool.Return(c)
+		}
+		checkedOut <- err
+	}()
+	select {
+	case err = <-checkedOut:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("the pool is blocked by the stalled lookup")
+	}
+	require.Equal(t, PoolStats{Dialing: 1}, pool.Stats(namedAddr))
+	require.Equal(t, PoolStats{Idle: 1}, pool.Stats(otherAddr))
+
+	require.NoError(t, pool.Stop())
+	close(release)
+	<-returned
+}
@@ -28,13 +28,13 @@ var (
 	// ErrTooManyEventLoopThreads occurs when attempting to set up more than 10,000 event-loop goroutines under LockOSThread mode.
 	ErrTooManyEventLoopThreads = errors.New("gnet: too many event-loops under LockOSThread mode")
 	// ErrUnsupportedProtocol occurs when trying to use protocol that is not supported.
-	ErrUnsupportedProtocol = errors.New("gnet: only unix, tcp/tcp4/tcp6, udp/udp4/udp6 are supported")
+	ErrUnsupportedProtocol = errors.New("gnet: only unix/unixgram/unixpacket, tcp/tcp4/tcp6, udp/udp4/udp6 are supported")
 	// ErrUnsupportedTCPProtocol occurs when trying to use an unsupported TCP protocol.
 	ErrUnsupportedTCPProtocol = errors.New("gnet: only tcp/tcp4/tcp6 are supported")
 	// ErrUnsupportedUDPProtocol occurs when trying to use an unsupported UDP protocol.
 	ErrUnsupportedUDPProtocol = errors.New("gnet: only udp/udp4/udp6 are supported")
 	// ErrUnsupportedUDSProtocol occurs when trying to use an unsupported Unix protocol.
-	ErrUnsupportedUDSProtocol = errors.New("gnet: only unix is supported")
+	ErrUnsupportedUDSProtocol = errors.New("gnet: only unix/unixgram/unixpacket are supported")
 	// ErrUnsupportedPlatform occurs when running gnet on an unsupported platform.
 	ErrUnsupportedPlatform = errors.New("gnet: unsupported platform in gnet")
 	// ErrUnsupportedOp occurs when calling some methods that has not been implemented yet.
@@ -43,6 +43,28 @@ var (
 	ErrNegativeSize = errors.New("gnet: negative size is not allowed")
 	// ErrNoIPv4AddressOnInterface occurs when an IPv4 multicast address is set on an interface but IPv4 is not configured.
 	ErrNoIPv4AddressOnInterface = errors.New("gnet: no IPv4 address on interface")
+	// ErrInvalidMulticastAddress occurs when trying to join or leave a group with a non-multicast address.
+	ErrInvalidMulticastAddress = errors.New("gnet: invalid multicast address")
+	// ErrFDsWithoutData occurs when trying to send file descriptors without any data.
+	ErrFDsWithoutData = errors.New("gnet: file descriptors must be sent along with data")
 	// ErrInvalidNetworkAddress occurs when the network address is invalid.
 	ErrInvalidNetworkAddress = errors.New("gnet: invalid network address")
+	// ErrInvalidFileRegion occurs when the file region to be sent is invalid.
+	ErrInvalidFileRegion = errors.New("gnet: invalid file region")
+	// ErrIOURingUnavailable occurs when io_uring is not available on the running kernel.
+	ErrIOURingUnavailable = errors.New("gnet: io_uring is not available")
+	// ErrWorkerQueueFull occurs when there are too many functions waiting for the workers of the goroutine pool.
+	ErrWorkerQueueFull = errors.New("gnet: the queue of the worker pool is full")
+	// ErrClientPoolClosed occurs when trying to use a client pool that has been closed.
+	ErrClientPoolClosed = errors.New("gnet: the client pool has been closed")
+	// ErrTLSHandshakeTimeout occurs when the TLS handshake of a connection doesn't complete within Options.TLSHandshakeTimeout.
+	ErrTLSHandshakeTimeout = errors.New("gnet: the TLS handshake timed out")
+	// ErrTLSCloseNotify is passed to OnClose when the peer has closed the TLS connection with close_notify.
+	ErrTLSCloseNotify = errors.New("gnet: the TLS connection is closed by the peer with close_notify")
+	// ErrTLSStarted occurs when trying to start TLS on a connection that is already served by TLS.
+	ErrTLSStarted = errors.New("gnet: TLS has been started on the connection")
+	// ErrNilTLSConfig occurs when trying to start TLS on a connection without a TLS config.
+	ErrNilTLSConfig = errors.New("gnet: the TLS config is nil")
+	// ErrTLSUnexpectedRecord occurs when a malformed or unexpected TLS record is received through kTLS.
+	ErrTLSUnexpectedRecord = errors.New("gnet: unexpected TLS record received through kTLS")
 )
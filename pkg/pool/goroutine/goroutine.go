@@ -64,3 +64,16 @@ func Default() *Pool {
 	defaultAntsPool, _ := ants.NewPool(DefaultAntsPoolSize, ants.WithOptions(options))
 	return defaultAntsPool
 }
+
+// NewBlocking instantiates a *WorkerPool with the given capacity, submitting a task to it
+// blocks until a worker is available when the pool is full.
+func NewBlocking(size int) (*Pool, error) {
+	options := ants.Options{
+		ExpiryDuration: ExpiryDuration,
+		Logger:         &antsLogger{logging.GetDefaultLogger()},
+		PanicHandler: func(i interface{}) {
+			logging.Errorf("goroutine pool panic: %v", i)
+		},
+	}
+	return ants.NewPool(size, ants.WithOptions(options))
+}
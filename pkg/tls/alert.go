@@ -4,7 +4,11 @@
 
 package tls
 
-import "strconv"
+import (
+	"errors"
+	"net"
+	"strconv"
+)
 
 // An AlertError is a TLS alert.
 //
@@ -107,3 +111,18 @@ func (e alert) String() string {
 func (e alert) Error() string {
 	return e.String()
 }
+
+// ErrorAlert returns the alert that made the connection fail with err, such as the
+// error returned by Conn.Handshake, and whether the alert was received from the peer
+// rather than sent to it. ok is false if err was not caused by an alert.
+func ErrorAlert(err error) (a AlertError, remote bool, ok bool) {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return
+	}
+	e, isAlert := opErr.Err.(alert)
+	if !isAlert {
+		return
+	}
+	return AlertError(e), opErr.Op == "remote error", true
+}
@@ -25,6 +25,10 @@ import (
 
 var ErrNotEnough = errors.New("data not enough")
 
+// ErrHandshakePending is returned by Handshake when a step of the handshake is running
+// on the handshake executor of the connection, see Conn.SetHandshakeExecutor.
+var ErrHandshakePending = errors.New("handshake step pending")
+
 // A Conn represents a secured connection.
 // It implements the net.Conn interface.
 type Conn struct {
@@ -39,8 +43,13 @@ type Conn struct {
 	// isHandshakeComplete is true implies handshakeErr == nil.
 	isHandshakeComplete  atomic.Bool
 	isWaitClientFinished atomic.Bool
-	handshakeState       int8
 	hs                   interface{ handshake() error }
+	handshakeExecutor    func(step func()) // runs the offloaded steps of the handshake, see offload
+	nonBlocking          bool              // Handshake reports the failure right away, see SetNonBlocking
+	offloaded            *offloadedStep    // the step that is running on handshakeExecutor
+	handedOff            bool              // the record layer has been handed off, see HandOffRecordLayer
+	keyUpdate            *KeyUpdate        // the update of the traffic keys for the caller of HandlePostHandshake
+	resumingSession      bool              // whether the client handshake is resuming a session
 	readClientFinished   func() error
 	// constant after handshake; protected by handshakeMutex
 	handshakeMutex sync.Mutex
@@ -111,6 +120,14 @@ type Conn struct {
 	buffering bool         // whether records are buffered in sendBuf
 	sendBuf   []byte       // a buffer of records waiting to be sent
 
+	// inPlaceRecord is the length of the record that input has been decrypted from in
+	// place in the buffer of the underlying connection, the record is discarded from
+	// that buffer by releaseInput once input has been drained.
+	inPlaceRecord int
+	// recordCopy holds the content of the record decrypted in place other than
+	// application data, so the record can be discarded right away.
+	recordCopy []byte
+
 	// bytesSent counts the bytes of application data sent.
 	// packetsSent counts packets.
 	bytesSent   int64
@@ -215,6 +232,9 @@ type halfConn struct {
 
 	level         QUICEncryptionLevel // current QUIC encryption level
 	trafficSecret []byte              // current TLS 1.3 traffic secret
+
+	key, iv         []byte // the key and IV of the AEAD cipher, see Conn.HandOffRecordLayer
+	nextKey, nextIV []byte // the key and IV of nextCipher
 }
 
 type permanentError struct {
@@ -243,6 +263,11 @@ func (hc *halfConn) prepareCipherSpec(version uint16, cipher any, mac hash.Hash)
 	hc.nextMac = mac
 }
 
+// prepareKeys sets the key and IV of the cipher previously passed to prepareCipherSpec.
+func (hc *halfConn) prepareKeys(key, iv []byte) {
+	hc.nextKey, hc.nextIV = key, iv
+}
+
 // changeCipherSpec changes the encryption and MAC states
 // to the ones previously passed to prepareCipherSpec.
 func (hc *halfConn) changeCipherSpec() error {
@@ -251,8 +276,10 @@ func (hc *halfConn) changeCipherSpec() error {
 	}
 	hc.cipher = hc.nextCipher
 	hc.mac = hc.nextMac
+	hc.key, hc.iv = hc.nextKey, hc.nextIV
 	hc.nextCipher = nil
 	hc.nextMac = nil
+	hc.nextKey, hc.nextIV = nil, nil
 	for i := range hc.seq {
 		hc.seq[i] = 0
 	}
@@ -264,6 +291,7 @@ func (hc *halfConn) setTrafficSecret(suite *cipherSuiteTLS13, level QUICEncrypti
 	hc.level = level
 	key, iv := suite.trafficKey(secret)
 	hc.cipher = suite.aead(key, iv)
+	hc.key, hc.iv = key, iv
 	for i := range hc.seq {
 		hc.seq[i] = 0
 	}
@@ -611,13 +639,38 @@ type RecordHeaderError struct {
 
 func (e RecordHeaderError) Error() string { return "tls: " + e.Msg }
 
-func (c *Conn) newRecordHeaderError(conn net.Conn, msg string) (err RecordHeaderError) {
+func (c *Conn) newRecordHeaderError(conn net.Conn, hdr []byte, msg string) (err RecordHeaderError) {
 	err.Msg = msg
 	err.Conn = conn
-	copy(err.RecordHeader[:], c.rawInput.Bytes())
+	copy(err.RecordHeader[:], hdr)
 	return err
 }
 
+// recordBuffer is implemented by the connections that buffer the inbound data themselves,
+// like gnet.Conn, the records are decrypted in place in their buffers rather than being
+// copied into Conn.rawInput.
+type recordBuffer interface {
+	Peek(n int) (buf []byte, err error)
+	Discard(n int) (discarded int, err error)
+	InboundBuffered() (n int)
+}
+
+// releaseInput discards the record that c.input has been decrypted from in place,
+// the application data that hasn't been read yet is copied out of the record first,
+// as the buffer of the underlying connection may be reused after that.
+func (c *Conn) releaseInput() {
+	if c.inPlaceRecord == 0 {
+		return
+	}
+	if c.input.Len() > 0 {
+		rest := make([]byte, c.input.Len())
+		_, _ = c.input.Read(rest)
+		c.input.Reset(rest)
+	}
+	_, _ = c.conn.(recordBuffer).Discard(c.inPlaceRecord)
+	c.inPlaceRecord = 0
+}
+
 func (c *Conn) readRecord() error {
 	return c.readRecordOrCCS(false)
 }
@@ -662,21 +715,28 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 	}
 
 	// Read header, payload.
-	if err := c.readFromUntil(c.conn, recordHeaderLen); err != nil {
-		// RFC 8446, Section 6.1 suggests that EOF without an alertCloseNotify
-		// is an error, but popular web sites seem to do this, so we accept it
-		// if and only if at the record boundary.
-		if err == io.ErrUnexpectedEOF && c.rawInput.Len() == 0 {
-			err = io.EOF
-		}
-		if e, ok := err.(net.Error); !ok || !e.Temporary() {
-			if !errors.Is(err, ErrNotEnough) {
-				c.in.setErrorLocked(err)
+	rb, inPlace := c.conn.(recordBuffer)
+	var hdr []byte
+	if inPlace {
+		// CanRead has made sure that the whole record has been buffered.
+		hdr, _ = rb.Peek(recordHeaderLen)
+	} else {
+		if err := c.readFromUntil(c.conn, recordHeaderLen); err != nil {
+			// RFC 8446, Section 6.1 suggests that EOF without an alertCloseNotify
+			// is an error, but popular web sites seem to do this, so we accept it
+			// if and only if at the record boundary.
+			if err == io.ErrUnexpectedEOF && c.rawInput.Len() == 0 {
+				err = io.EOF
+			}
+			if e, ok := err.(net.Error); !ok || !e.Temporary() {
+				if !errors.Is(err, ErrNotEnough) {
+					c.in.setErrorLocked(err)
+				}
 			}
+			return err
 		}
-		return err
+		hdr = c.rawInput.Bytes()[:recordHeaderLen]
 	}
-	hdr := c.rawInput.Bytes()[:recordHeaderLen]
 	typ := recordType(hdr[0])
 
 	// No valid TLS record has a type of 0x80, however SSLv2 handshakes
@@ -685,7 +745,7 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 	// an SSLv2 client.
 	if !handshakeComplete && typ == 0x80 {
 		c.sendAlert(alertProtocolVersion)
-		return c.in.setErrorLocked(c.newRecordHeaderError(nil, "unsupported SSLv2 handshake received"))
+		return c.in.setErrorLocked(c.newRecordHeaderError(nil, hdr, "unsupported SSLv2 handshake received"))
 	}
 
 	vers := uint16(hdr[1])<<8 | uint16(hdr[2])
@@ -699,7 +759,7 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 	if c.haveVers && vers != expectedVers {
 		c.sendAlert(alertProtocolVersion)
 		msg := fmt.Sprintf("received record with version %x when expecting version %x", vers, expectedVers)
-		return c.in.setErrorLocked(c.newRecordHeaderError(nil, msg))
+		return c.in.setErrorLocked(c.newRecordHeaderError(nil, hdr, msg))
 	}
 	if !c.haveVers {
 		// First message, be extra suspicious: this might not be a TLS
@@ -707,29 +767,44 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		// The current max version is 3.3 so if the version is >= 16.0,
 		// it's probably not real.
 		if (typ != recordTypeAlert && typ != recordTypeHandshake) || vers >= 0x1000 {
-			return c.in.setErrorLocked(c.newRecordHeaderError(c.conn, "first record does not look like a TLS handshake"))
+			return c.in.setErrorLocked(c.newRecordHeaderError(c.conn, hdr, "first record does not look like a TLS handshake"))
 		}
 	}
 	if c.vers == VersionTLS13 && n > maxCiphertextTLS13 || n > maxCiphertext {
 		c.sendAlert(alertRecordOverflow)
 		msg := fmt.Sprintf("oversized record received with length %d", n)
-		return c.in.setErrorLocked(c.newRecordHeaderError(nil, msg))
+		return c.in.setErrorLocked(c.newRecordHeaderError(nil, hdr, msg))
 	}
-	if err := c.readFromUntil(c.conn, n); err != nil {
-		if e, ok := err.(net.Error); !ok || !e.Temporary() {
-			if !errors.Is(err, ErrNotEnough) {
-				c.in.setErrorLocked(err)
+	var record []byte
+	if inPlace {
+		record, _ = rb.Peek(recordHeaderLen + n)
+	} else {
+		if err := c.readFromUntil(c.conn, n); err != nil {
+			if e, ok := err.(net.Error); !ok || !e.Temporary() {
+				if !errors.Is(err, ErrNotEnough) {
+					c.in.setErrorLocked(err)
+				}
 			}
+			return err
 		}
-		return err
+		record = c.rawInput.Next(recordHeaderLen + n)
 	}
 
 	// Process message.
-	record := c.rawInput.Next(recordHeaderLen + n)
 	data, typ, err := c.in.decrypt(record)
 	if err != nil {
 		return c.in.setErrorLocked(c.sendAlert(err.(alert)))
 	}
+	if inPlace {
+		if typ == recordTypeApplicationData && len(data) > 0 {
+			// The plaintext stays in place until c.input is drained.
+			c.inPlaceRecord = recordHeaderLen + n
+		} else {
+			c.recordCopy = append(c.recordCopy[:0], data...)
+			data = c.recordCopy
+			_, _ = rb.Discard(recordHeaderLen + n)
+		}
+	}
 	if len(data) > maxPlaintext {
 		return c.in.setErrorLocked(c.sendAlert(alertRecordOverflow))
 	}
@@ -810,7 +885,8 @@ func (c *Conn) readRecordOrCCS(expectChangeCipherSpec bool) error {
 		}
 		// Note that data is owned by c.rawInput, following the Next call above,
 		// to avoid copying the plaintext. This is safe because c.rawInput is
-		// not read from or written to until c.input is drained.
+		// not read from or written to until c.input is drained. Likewise, data
+		// decrypted in place is owned by the buffer of c.conn until releaseInput.
 		c.input.Reset(data)
 
 	case recordTypeHandshake:
@@ -884,7 +960,8 @@ func (c *Conn) readFromUntil(r io.Reader, n int) error {
 
 // sendAlertLocked sends a TLS alert message.
 func (c *Conn) sendAlertLocked(err alert) error {
-	if c.quic != nil {
+	// The alert is sent by the caller after the record layer has been handed off.
+	if c.quic != nil || c.handedOff {
 		return c.out.setErrorLocked(&net.OpError{Op: "local error", Err: err})
 	}
 
@@ -1377,6 +1454,10 @@ func (c *Conn) handleKeyUpdate(keyUpdate *keyUpdateMsg) error {
 	newSecret := cipherSuite.nextTrafficSecret(c.in.trafficSecret)
 	c.in.setTrafficSecret(cipherSuite, QUICEncryptionLevelInitial, newSecret)
 
+	if c.handedOff {
+		return c.handOffKeyUpdate(cipherSuite, keyUpdate.updateRequested)
+	}
+
 	if keyUpdate.updateRequested {
 		c.out.Lock()
 		defer c.out.Unlock()
@@ -1431,6 +1512,7 @@ func (c *Conn) Read(b []byte) (int, error) {
 	}
 
 	n, _ := c.input.Read(b)
+	c.releaseInput()
 
 	// If a close-notify alert is waiting, read it so that we can return (n,
 	// EOF) instead of (n, nil), to signal to the HTTP response reading
@@ -1449,6 +1531,47 @@ func (c *Conn) Read(b []byte) (int, error) {
 	return n, nil
 }
 
+// WriteTo decrypts the records that have been completely buffered by the underlying
+// connection and writes the application data to w until there is no complete record
+// left, at which point it returns a nil error. It implements io.WriterTo.
+//
+// If the underlying connection buffers the inbound data itself and exposes the buffer
+// by Peek and Discard, like gnet.Conn, the records are decrypted in place, so that the
+// plaintext is copied to w only. WriteTo does nothing until the handshake has completed.
+func (c *Conn) WriteTo(w io.Writer) (n int64, err error) {
+	if err = c.Handshake(); err != nil {
+		return
+	}
+	if !c.isHandshakeComplete.Load() {
+		return
+	}
+
+	c.in.Lock()
+	defer c.in.Unlock()
+
+	for {
+		if c.input.Len() > 0 {
+			m, err := c.input.WriteTo(w)
+			n += m
+			c.releaseInput()
+			if err != nil {
+				return n, err
+			}
+		}
+		if err = c.readRecord(); err != nil {
+			if errors.Is(err, ErrNotEnough) {
+				err = nil
+			}
+			return
+		}
+		for c.hand.Len() > 0 {
+			if err = c.handlePostHandshakeMessage(); err != nil {
+				return
+			}
+		}
+	}
+}
+
 // Close closes the connection.
 func (c *Conn) Close() error {
 	// Interlock with Conn.Write above.
@@ -1534,6 +1657,213 @@ func (c *Conn) HandshakeCompleted() bool {
 	return c.isHandshakeComplete.Load()
 }
 
+// ServerName returns the server name indicated by the client, which is available
+// once the ClientHello has been processed, even if the handshake fails later.
+// Unlike ConnectionState, it can be called while a step of the handshake is running
+// on the handshake executor.
+func (c *Conn) ServerName() string {
+	return c.serverName
+}
+
+// SetNonBlocking makes Handshake return the error of the failed handshake from the call
+// in which it fails, rather than nil followed by the error from the next call, for the
+// callers that drive the handshake as the data arrives and may not call it again.
+//
+// SetNonBlocking must be called before the handshake starts.
+func (c *Conn) SetNonBlocking() {
+	c.nonBlocking = true
+}
+
+// SetHandshakeExecutor makes the server handshake run its CPU-intensive steps, such as the
+// key agreement, the signatures and Config.GetCertificate, through exec, so that they don't
+// block the goroutine that drives the connection. exec must run the given step on another
+// goroutine, Handshake returns ErrHandshakePending until the step is done, after which it
+// must be called again to resume the handshake. The steps run inline when exec is nil.
+//
+// SetHandshakeExecutor must be called before the handshake starts.
+func (c *Conn) SetHandshakeExecutor(exec func(step func())) {
+	c.handshakeExecutor = exec
+}
+
+// TrafficKeys are the keys of the record layer in one direction, see Conn.HandOffRecordLayer.
+type TrafficKeys struct {
+	Version     uint16 // the negotiated TLS version
+	CipherSuite uint16 // the negotiated cipher suite, which is based on AES-GCM or ChaCha20-Poly1305
+	Key         []byte
+	// IV is the implicit part of the nonce, which is 4 bytes for AES-GCM in TLS 1.2 whose
+	// explicit part is the sequence number of the record, and 12 bytes otherwise.
+	IV  []byte
+	Seq [8]byte // the sequence number of the next record
+}
+
+func (hc *halfConn) trafficKeys(version, suite uint16) TrafficKeys {
+	return TrafficKeys{Version: version, CipherSuite: suite, Key: hc.key, IV: hc.iv, Seq: hc.seq}
+}
+
+// HandOffRecordLayer hands the record layer off to install once the handshake has completed,
+// e.g. to offload it to the kernel (kTLS), which is given the keys for reading the records from
+// the peer (in) and writing the records to the peer (out). The record layer is handed off unless
+// install fails, after which the Conn mustn't be read from or written to, the content of the
+// handshake records received from the peer is passed to HandlePostHandshake, while the other
+// records are handled by the caller.
+//
+// It fails if the cipher suite isn't based on AES-GCM or ChaCha20-Poly1305, or there is data that
+// has been read from the underlying connection but not consumed yet.
+func (c *Conn) HandOffRecordLayer(install func(in, out TrafficKeys) error) (err error) {
+	c.in.Lock()
+	defer c.in.Unlock()
+	c.out.Lock()
+	defer c.out.Unlock()
+
+	switch {
+	case !c.isHandshakeComplete.Load():
+		err = errors.New("tls: handshake has not completed")
+	case c.quic != nil:
+		err = errors.New("tls: record layer of QUIC can't be handed off")
+	case c.vers != VersionTLS13 && (cipherSuiteByID(c.cipherSuite) == nil || cipherSuiteByID(c.cipherSuite).aead == nil):
+		err = errors.New("tls: record layer of non-AEAD cipher suites can't be handed off")
+	case c.rawInput.Len() > 0 || c.input.Len() > 0 || c.hand.Len() > 0 || c.inPlaceRecord > 0:
+		err = errors.New("tls: record layer can't be handed off with the data pending")
+	case c.in.err != nil:
+		err = c.in.err
+	case c.out.err != nil:
+		err = c.out.err
+	}
+	if err != nil {
+		return
+	}
+	if err = install(c.in.trafficKeys(c.vers, c.cipherSuite), c.out.trafficKeys(c.vers, c.cipherSuite)); err != nil {
+		return
+	}
+	c.handedOff = true
+	return nil
+}
+
+// KeyUpdate is the update of the traffic keys caused by the KeyUpdate message from the peer in TLS 1.3
+// after the record layer has been handed off, see Conn.HandlePostHandshake.
+type KeyUpdate struct {
+	// In is the keys to read the records from the peer after the KeyUpdate message.
+	In TrafficKeys
+	// Reply is the KeyUpdate message to be sent to the peer in a handshake record with the current
+	// keys if the peer has requested the update, after which the records are written with Out.
+	// It's nil if the keys for writing are not updated.
+	Reply []byte
+	Out   TrafficKeys
+}
+
+// HandlePostHandshake handles the content of the handshake records received from the peer after
+// the record layer has been handed off, i.e. NewSessionTicket and KeyUpdate in TLS 1.3, which can
+// be split across the records. A non-nil KeyUpdate is returned if the peer has updated its keys.
+// The error carries the alert to be sent to the peer if any, see ErrorAlert.
+func (c *Conn) HandlePostHandshake(data []byte) (*KeyUpdate, error) {
+	c.in.Lock()
+	defer c.in.Unlock()
+
+	if !c.handedOff {
+		return nil, errors.New("tls: record layer has not been handed off")
+	}
+	if c.in.err != nil {
+		return nil, c.in.err
+	}
+	// The renegotiation of TLS 1.2 needs the record layer.
+	if c.vers != VersionTLS13 {
+		return nil, c.in.setErrorLocked(c.sendAlert(alertNoRenegotiation))
+	}
+	c.keyUpdate = nil
+	c.retryCount = 0
+	c.hand.Write(data)
+	for c.hand.Len() >= 4 {
+		b := c.hand.Bytes()
+		n := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+		if n <= maxHandshake && len(b) < 4+n {
+			break
+		}
+		if err := c.handlePostHandshakeMessage(); err != nil {
+			return nil, c.in.setErrorLocked(err)
+		}
+	}
+	keyUpdate := c.keyUpdate
+	c.keyUpdate = nil
+	return keyUpdate, nil
+}
+
+// handOffKeyUpdate hands the update of the traffic keys off to the caller of HandlePostHandshake,
+// which writes the reply since the record layer has been handed off. The keys for writing are
+// updated once at most for the handshake data passed to HandlePostHandshake at a time.
+func (c *Conn) handOffKeyUpdate(suite *cipherSuiteTLS13, updateRequested bool) error {
+	keyUpdate := &KeyUpdate{In: c.in.trafficKeys(c.vers, c.cipherSuite)}
+	if prev := c.keyUpdate; prev != nil && prev.Reply != nil {
+		keyUpdate.Reply, keyUpdate.Out = prev.Reply, prev.Out
+	} else if updateRequested {
+		c.out.Lock()
+		defer c.out.Unlock()
+
+		msg := &keyUpdateMsg{}
+		msgBytes, err := msg.marshal()
+		if err != nil {
+			return err
+		}
+		c.out.setTrafficSecret(suite, QUICEncryptionLevelInitial, suite.nextTrafficSecret(c.out.trafficSecret))
+		keyUpdate.Reply, keyUpdate.Out = msgBytes, c.out.trafficKeys(c.vers, c.cipherSuite)
+	}
+	c.keyUpdate = keyUpdate
+	return nil
+}
+
+// offloadedStep is a step of the handshake that is run by the handshake executor.
+type offloadedStep struct {
+	done atomic.Bool
+	err  error
+}
+
+// alertError is an error of an offloaded step along with the alert for it,
+// the alert is sent once the handshake is resumed.
+type alertError struct {
+	alert alert
+	err   error
+}
+
+func (e *alertError) Error() string {
+	return e.err.Error()
+}
+
+// offload runs fn, the CPU-intensive part of a handshake step, on the handshake
+// executor. fn must only compute the state of the handshake: it mustn't read from
+// or write to the connection, an alert is sent by returning an *alertError.
+//
+// The first call of offload hands fn over to the executor and returns
+// ErrHandshakePending, which makes the step run again when the handshake is
+// resumed, then the call of offload returns the result of fn. Therefore, offload
+// must be called at the beginning of a step, before it changes any state.
+func (c *Conn) offload(fn func() error) error {
+	step := c.offloaded
+	if step == nil {
+		if c.handshakeExecutor == nil {
+			return c.handleAlertError(fn())
+		}
+		step = new(offloadedStep)
+		c.offloaded = step
+		c.handshakeExecutor(func() {
+			step.err = fn()
+			step.done.Store(true)
+		})
+	}
+	if !step.done.Load() {
+		return ErrHandshakePending
+	}
+	c.offloaded = nil
+	return c.handleAlertError(step.err)
+}
+
+func (c *Conn) handleAlertError(err error) error {
+	var ae *alertError
+	if errors.As(err, &ae) {
+		c.sendAlert(ae.alert)
+		return ae.err
+	}
+	return err
+}
+
 func (c *Conn) CanRead() error {
 	r, ok := c.conn.(interface {
 		Peek(n int) (buf []byte, err error)
@@ -1553,6 +1883,12 @@ func (c *Conn) CanRead() error {
 		return err
 	}
 
+	// Let readRecordOrCCS reject the bad header right away rather than waiting for
+	// the body, e.g. when a scanner speaks plaintext HTTP to the TLS port.
+	if c.badRecordHeader(hdr) {
+		return nil
+	}
+
 	n := int(hdr[3])<<8 | int(hdr[4])
 	total := n + recordHeaderLen
 	if r.InboundBuffered() < total {
@@ -1561,6 +1897,27 @@ func (c *Conn) CanRead() error {
 	return nil
 }
 
+// badRecordHeader reports whether hdr is going to be rejected by readRecordOrCCS.
+func (c *Conn) badRecordHeader(hdr []byte) bool {
+	typ := recordType(hdr[0])
+	if !c.isHandshakeComplete.Load() && typ == 0x80 {
+		return true
+	}
+	vers := uint16(hdr[1])<<8 | uint16(hdr[2])
+	expectedVers := c.vers
+	if expectedVers == VersionTLS13 {
+		expectedVers = VersionTLS12
+	}
+	if c.haveVers && vers != expectedVers {
+		return true
+	}
+	if !c.haveVers && ((typ != recordTypeAlert && typ != recordTypeHandshake) || vers >= 0x1000) {
+		return true
+	}
+	n := int(hdr[3])<<8 | int(hdr[4])
+	return c.vers == VersionTLS13 && n > maxCiphertextTLS13 || n > maxCiphertext
+}
+
 // HandshakeContext runs the client or server handshake
 // protocol if it has not yet been run.
 //
@@ -1652,15 +2009,35 @@ func (c *Conn) handshakeContext(ctx context.Context) (ret error) {
 		c.handshakeErr = nil
 		return nil
 	}
+	// The handshake is resumed after the offloaded step is done.
+	if errors.Is(c.handshakeErr, ErrHandshakePending) {
+		c.handshakeErr = nil
+		return ErrHandshakePending
+	}
 	if c.handshakeErr == nil {
 		c.handshakes++
 	} else {
 		// If an error occurred during the handshake try to flush the
 		// alert that might be left in the buffer.
 		c.flush()
+		// Wrap the alert that has been sent to the peer, if the error doesn't
+		// carry it, so that ErrorAlert can tell it.
+		if _, _, ok := ErrorAlert(c.handshakeErr); !ok && c.quic == nil {
+			var a alert
+			c.out.Lock()
+			sent := errors.As(c.out.err, &a)
+			c.out.Unlock()
+			if sent {
+				// Truncate the text of the alert to 0 characters.
+				c.handshakeErr = fmt.Errorf("%w%.0w", c.handshakeErr, &net.OpError{Op: "local error", Err: a})
+			}
+		}
 	}
 
 	if !c.isHandshakeComplete.Load() {
+		if c.nonBlocking {
+			return c.handshakeErr
+		}
 		return nil
 	}
 
@@ -33,6 +33,14 @@ type clientHandshakeState struct {
 	masterSecret []byte
 	session      *SessionState // the session being resumed
 	ticket       []byte        // a fresh ticket received during this handshake
+
+	steps         handshakeSteps  // pending steps of the handshake
+	msg           any             // the last message read by readMessage
+	certMsg       *certificateMsg // the server's certificates
+	keyAgreement  keyAgreement    // key agreement of the cipher suite
+	certReq       *certificateRequestMsg
+	chainToSend   *Certificate
+	certRequested bool
 }
 
 var testingOnlyForceClientHelloSignatureAlgorithms []SignatureScheme
@@ -168,26 +176,92 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, *ecdh.PrivateKey, error) {
 	return hello, key, nil
 }
 
-func (c *Conn) clientHandshake(ctx context.Context) (err error) {
-	if c.config == nil {
-		c.config = defaultConfig()
+// handshakeSteps drives a handshake as a series of steps, so that the handshake can
+// be run on a non-blocking connection: each step reads at most one handshake message
+// before it changes any state, a step that runs out of data returns ErrNotEnough and
+// it's run again when the handshake is resumed after more data has arrived. Likewise,
+// a step that has been offloaded returns ErrHandshakePending, see Conn.offload.
+type handshakeSteps []func() error
+
+// run runs the pending steps in order.
+func (s *handshakeSteps) run() error {
+	for len(*s) > 0 {
+		step := (*s)[0]
+		*s = (*s)[1:]
+		if err := step(); err != nil {
+			if errors.Is(err, ErrNotEnough) || errors.Is(err, ErrHandshakePending) {
+				*s = append(handshakeSteps{step}, *s...)
+			}
+			return err
+		}
 	}
+	return nil
+}
 
-	// This may be a renegotiation handshake, in which case some fields
-	// need to be reset.
-	c.didResume = false
+// then schedules the given steps to run right after the current step.
+func (s *handshakeSteps) then(steps ...func() error) {
+	*s = append(steps, *s...)
+}
 
-	hello, ecdheKey, err := c.makeClientHello()
-	if err != nil {
-		return err
-	}
-	c.serverName = hello.serverName
+// clientHelloState is the state of the client handshake after the ClientHello
+// has been sent, until the ServerHello is received.
+type clientHelloState struct {
+	c           *Conn
+	ctx         context.Context
+	hello       *clientHelloMsg
+	ecdheKey    *ecdh.PrivateKey
+	session     *SessionState
+	earlySecret []byte
+	binderKey   []byte
+}
 
-	session, earlySecret, binderKey, err := c.loadSession(hello)
-	if err != nil {
-		return err
+func (c *Conn) clientHandshake(ctx context.Context) (err error) {
+	if c.hs == nil {
+		if c.config == nil {
+			c.config = defaultConfig()
+		}
+
+		// This may be a renegotiation handshake, in which case some fields
+		// need to be reset.
+		c.didResume = false
+
+		hello, ecdheKey, err := c.makeClientHello()
+		if err != nil {
+			return err
+		}
+		c.serverName = hello.serverName
+
+		session, earlySecret, binderKey, err := c.loadSession(hello)
+		if err != nil {
+			return err
+		}
+		c.hs = &clientHelloState{
+			c:           c,
+			ctx:         ctx,
+			hello:       hello,
+			ecdheKey:    ecdheKey,
+			session:     session,
+			earlySecret: earlySecret,
+			binderKey:   binderKey,
+		}
+		c.resumingSession = session != nil
+
+		if _, err := c.writeHandshakeRecord(hello, nil); err != nil {
+			return err
+		}
+
+		if hello.earlyData {
+			suite := cipherSuiteTLS13ByID(session.cipherSuite)
+			transcript := suite.hash.New()
+			if err := transcriptMsg(hello, transcript); err != nil {
+				return err
+			}
+			earlyTrafficSecret := suite.deriveSecret(earlySecret, clientEarlyTrafficLabel, transcript)
+			c.quicSetWriteSecret(QUICEncryptionLevelEarly, suite.id, earlyTrafficSecret)
+		}
 	}
-	if session != nil {
+
+	if c.resumingSession {
 		defer func() {
 			// If we got a handshake failure when resuming a session, throw away
 			// the session ticket. See RFC 5077, Section 3.2.
@@ -195,7 +269,7 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 			// RFC 8446 makes no mention of dropping tickets on failure, but it
 			// does require servers to abort on invalid binders, so we need to
 			// delete tickets to recover from a corrupted PSK.
-			if err != nil {
+			if err != nil && !errors.Is(err, ErrNotEnough) {
 				if cacheKey := c.clientSessionCacheKey(); cacheKey != "" {
 					c.config.ClientSessionCache.Put(cacheKey, nil)
 				}
@@ -203,19 +277,15 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 		}()
 	}
 
-	if _, err := c.writeHandshakeRecord(hello, nil); err != nil {
-		return err
+	if err = c.hs.handshake(); err == nil {
+		c.hs = nil
 	}
+	return err
+}
 
-	if hello.earlyData {
-		suite := cipherSuiteTLS13ByID(session.cipherSuite)
-		transcript := suite.hash.New()
-		if err := transcriptMsg(hello, transcript); err != nil {
-			return err
-		}
-		earlyTrafficSecret := suite.deriveSecret(earlySecret, clientEarlyTrafficLabel, transcript)
-		c.quicSetWriteSecret(QUICEncryptionLevelEarly, suite.id, earlyTrafficSecret)
-	}
+// handshake reads the ServerHello and continues the handshake with the negotiated version.
+func (hs *clientHelloState) handshake() error {
+	c := hs.c
 
 	// serverHelloMsg is not included in the transcript
 	msg, err := c.readHandshake(nil)
@@ -246,34 +316,28 @@ func (c *Conn) clientHandshake(ctx context.Context) (err error) {
 	}
 
 	if c.vers == VersionTLS13 {
-		hs := &clientHandshakeStateTLS13{
+		// In TLS 1.3, session tickets are delivered after the handshake.
+		c.hs = &clientHandshakeStateTLS13{
 			c:           c,
-			ctx:         ctx,
+			ctx:         hs.ctx,
 			serverHello: serverHello,
-			hello:       hello,
-			ecdheKey:    ecdheKey,
-			session:     session,
-			earlySecret: earlySecret,
-			binderKey:   binderKey,
+			hello:       hs.hello,
+			ecdheKey:    hs.ecdheKey,
+			session:     hs.session,
+			earlySecret: hs.earlySecret,
+			binderKey:   hs.binderKey,
+		}
+	} else {
+		c.hs = &clientHandshakeState{
+			c:           c,
+			ctx:         hs.ctx,
+			serverHello: serverHello,
+			hello:       hs.hello,
+			session:     hs.session,
 		}
-
-		// In TLS 1.3, session tickets are delivered after the handshake.
-		return hs.handshake()
-	}
-
-	hs := &clientHandshakeState{
-		c:           c,
-		ctx:         ctx,
-		serverHello: serverHello,
-		hello:       hello,
-		session:     session,
-	}
-
-	if err := hs.handshake(); err != nil {
-		return err
 	}
 
-	return nil
+	return c.hs.handshake()
 }
 
 func (c *Conn) loadSession(hello *clientHelloMsg) (
@@ -435,6 +499,14 @@ func (c *Conn) pickTLSVersion(serverHello *serverHelloMsg) error {
 // Does the handshake, either a full one or resumes old session. Requires hs.c,
 // hs.hello, hs.serverHello, and, optionally, hs.session to be set.
 func (hs *clientHandshakeState) handshake() error {
+	if hs.steps == nil {
+		hs.steps = handshakeSteps{hs.processHello, hs.finishHandshake}
+	}
+	return hs.steps.run()
+}
+
+// processHello processes the ServerHello and schedules the rest of the handshake.
+func (hs *clientHandshakeState) processHello() error {
 	c := hs.c
 
 	isResume, err := hs.processServerHello()
@@ -465,49 +537,56 @@ func (hs *clientHandshakeState) handshake() error {
 		if err := hs.establishKeys(); err != nil {
 			return err
 		}
-		if err := hs.readSessionTicket(); err != nil {
-			return err
-		}
-		if err := hs.readFinished(c.serverFinished[:]); err != nil {
-			return err
-		}
-		c.clientFinishedIsFirst = false
-		// Make sure the connection is still being verified whether or not this
-		// is a resumption. Resumptions currently don't reverify certificates so
-		// they don't call verifyServerCertificate. See Issue 31641.
-		if c.config.VerifyConnection != nil {
-			if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
-				c.sendAlert(alertBadCertificate)
-				return err
-			}
-		}
-		if err := hs.sendFinished(c.clientFinished[:]); err != nil {
-			return err
-		}
-		if _, err := c.flush(); err != nil {
-			return err
-		}
+		hs.steps.then(hs.readSessionTicket, c.readChangeCipherSpec, hs.readServerFinished, hs.sendResumedFinished)
 	} else {
-		if err := hs.doFullHandshake(); err != nil {
-			return err
-		}
-		if err := hs.establishKeys(); err != nil {
-			return err
-		}
-		if err := hs.sendFinished(c.clientFinished[:]); err != nil {
-			return err
-		}
-		if _, err := c.flush(); err != nil {
-			return err
-		}
-		c.clientFinishedIsFirst = true
-		if err := hs.readSessionTicket(); err != nil {
-			return err
-		}
-		if err := hs.readFinished(c.serverFinished[:]); err != nil {
+		hs.steps.then(hs.doFullHandshake)
+	}
+	return nil
+}
+
+// sendResumedFinished sends the client's Finished of an abbreviated handshake.
+func (hs *clientHandshakeState) sendResumedFinished() error {
+	c := hs.c
+
+	c.clientFinishedIsFirst = false
+	// Make sure the connection is still being verified whether or not this
+	// is a resumption. Resumptions currently don't reverify certificates so
+	// they don't call verifyServerCertificate. See Issue 31641.
+	if c.config.VerifyConnection != nil {
+		if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
+			c.sendAlert(alertBadCertificate)
 			return err
 		}
 	}
+	if err := hs.sendFinished(c.clientFinished[:]); err != nil {
+		return err
+	}
+	_, err := c.flush()
+	return err
+}
+
+// sendFullFinished sends the client's Finished of a full handshake.
+func (hs *clientHandshakeState) sendFullFinished() error {
+	c := hs.c
+
+	if err := hs.establishKeys(); err != nil {
+		return err
+	}
+	if err := hs.sendFinished(c.clientFinished[:]); err != nil {
+		return err
+	}
+	if _, err := c.flush(); err != nil {
+		return err
+	}
+	c.clientFinishedIsFirst = true
+	hs.steps.then(hs.readSessionTicket, c.readChangeCipherSpec, hs.readServerFinished)
+	return nil
+}
+
+// finishHandshake completes the handshake after the server's Finished has been verified.
+func (hs *clientHandshakeState) finishHandshake() error {
+	c := hs.c
+
 	if err := hs.saveSessionTicket(); err != nil {
 		return err
 	}
@@ -518,6 +597,12 @@ func (hs *clientHandshakeState) handshake() error {
 	return nil
 }
 
+// readMessage reads the next handshake message, which is processed by the next step.
+func (hs *clientHandshakeState) readMessage() (err error) {
+	hs.msg, err = hs.c.readHandshake(&hs.finishedHash)
+	return
+}
+
 func (hs *clientHandshakeState) pickCipherSuite() error {
 	if hs.suite = mutualCipherSuite(hs.hello.cipherSuites, hs.serverHello.cipherSuite); hs.suite == nil {
 		hs.c.sendAlert(alertHandshakeFailure)
@@ -544,13 +629,17 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		c.sendAlert(alertUnexpectedMessage)
 		return unexpectedMessageError(certMsg, msg)
 	}
+	hs.certMsg = certMsg
 
-	msg, err = c.readHandshake(&hs.finishedHash)
-	if err != nil {
-		return err
-	}
+	hs.steps.then(hs.readMessage, hs.readCertificateStatus)
+	return nil
+}
 
-	cs, ok := msg.(*certificateStatusMsg)
+// readCertificateStatus processes the optional CertificateStatus and verifies the server's certificates.
+func (hs *clientHandshakeState) readCertificateStatus() error {
+	c := hs.c
+
+	cs, ok := hs.msg.(*certificateStatusMsg)
 	if ok {
 		// RFC4366 on Certificate Status Request:
 		// The server MAY return a "certificate_status" message.
@@ -566,16 +655,21 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 
 		c.ocspResponse = cs.response
 
-		msg, err = c.readHandshake(&hs.finishedHash)
-		if err != nil {
-			return err
-		}
+		hs.steps.then(hs.readMessage, hs.readServerKeyExchange)
+		return nil
 	}
 
+	return hs.readServerKeyExchange()
+}
+
+// readServerKeyExchange verifies the server's certificates and processes the optional ServerKeyExchange.
+func (hs *clientHandshakeState) readServerKeyExchange() error {
+	c := hs.c
+
 	if c.handshakes == 0 {
 		// If this is the first handshake on a connection, process and
 		// (optionally) verify the server's certificates.
-		if err := c.verifyServerCertificate(certMsg.certificates); err != nil {
+		if err := c.verifyServerCertificate(hs.certMsg.certificates); err != nil {
 			return err
 		}
 	} else {
@@ -585,46 +679,59 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		//
 		// See https://mitls.org/pages/attacks/3SHAKE for the
 		// motivation behind this requirement.
-		if !bytes.Equal(c.peerCertificates[0].Raw, certMsg.certificates[0]) {
+		if !bytes.Equal(c.peerCertificates[0].Raw, hs.certMsg.certificates[0]) {
 			c.sendAlert(alertBadCertificate)
 			return errors.New("tls: server's identity changed during renegotiation")
 		}
 	}
 
-	keyAgreement := hs.suite.ka(c.vers)
+	hs.keyAgreement = hs.suite.ka(c.vers)
 
-	skx, ok := msg.(*serverKeyExchangeMsg)
+	skx, ok := hs.msg.(*serverKeyExchangeMsg)
 	if ok {
-		err = keyAgreement.processServerKeyExchange(c.config, hs.hello, hs.serverHello, c.peerCertificates[0], skx)
+		err := hs.keyAgreement.processServerKeyExchange(c.config, hs.hello, hs.serverHello, c.peerCertificates[0], skx)
 		if err != nil {
 			c.sendAlert(alertUnexpectedMessage)
 			return err
 		}
 
-		msg, err = c.readHandshake(&hs.finishedHash)
-		if err != nil {
-			return err
-		}
+		hs.steps.then(hs.readMessage, hs.readCertificateRequest)
+		return nil
 	}
 
-	var chainToSend *Certificate
-	var certRequested bool
-	certReq, ok := msg.(*certificateRequestMsg)
+	return hs.readCertificateRequest()
+}
+
+// readCertificateRequest processes the optional CertificateRequest.
+func (hs *clientHandshakeState) readCertificateRequest() (err error) {
+	c := hs.c
+
+	certReq, ok := hs.msg.(*certificateRequestMsg)
 	if ok {
-		certRequested = true
+		hs.certRequested = true
+		hs.certReq = certReq
 
 		cri := certificateRequestInfoFromMsg(hs.ctx, c.vers, certReq)
-		if chainToSend, err = c.getClientCertificate(cri); err != nil {
+		if hs.chainToSend, err = c.getClientCertificate(cri); err != nil {
 			c.sendAlert(alertInternalError)
 			return err
 		}
 
-		msg, err = c.readHandshake(&hs.finishedHash)
-		if err != nil {
-			return err
-		}
+		hs.steps.then(hs.readMessage, hs.readServerHelloDone)
+		return nil
 	}
 
+	return hs.readServerHelloDone()
+}
+
+// readServerHelloDone processes the ServerHelloDone and sends the client's second flight.
+func (hs *clientHandshakeState) readServerHelloDone() error {
+	c := hs.c
+	msg := hs.msg
+	keyAgreement := hs.keyAgreement
+	chainToSend := hs.chainToSend
+	certReq := hs.certReq
+
 	shd, ok := msg.(*serverHelloDoneMsg)
 	if !ok {
 		c.sendAlert(alertUnexpectedMessage)
@@ -634,8 +741,8 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 	// If the server requested a certificate then we have to send a
 	// Certificate message, even if it's empty because we don't have a
 	// certificate to send.
-	if certRequested {
-		certMsg = new(certificateMsg)
+	if hs.certRequested {
+		certMsg := new(certificateMsg)
 		certMsg.certificates = chainToSend.Certificate
 		if _, err := hs.c.writeHandshakeRecord(certMsg, &hs.finishedHash); err != nil {
 			return err
@@ -715,6 +822,7 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 
 	hs.finishedHash.discardHandshakeBuffer()
 
+	hs.steps.then(hs.sendFullFinished)
 	return nil
 }
 
@@ -737,6 +845,8 @@ func (hs *clientHandshakeState) establishKeys() error {
 
 	c.in.prepareCipherSpec(c.vers, serverCipher, serverHash)
 	c.out.prepareCipherSpec(c.vers, clientCipher, clientHash)
+	c.in.prepareKeys(serverKey, serverIV)
+	c.out.prepareKeys(clientKey, clientIV)
 	return nil
 }
 
@@ -842,12 +952,10 @@ func checkALPN(clientProtos []string, serverProto string, quic bool) error {
 	return errors.New("tls: server selected unadvertised ALPN protocol")
 }
 
-func (hs *clientHandshakeState) readFinished(out []byte) error {
+// readServerFinished reads the server's Finished, which follows the ChangeCipherSpec.
+func (hs *clientHandshakeState) readServerFinished() error {
 	c := hs.c
-
-	if err := c.readChangeCipherSpec(); err != nil {
-		return err
-	}
+	out := c.serverFinished[:]
 
 	// finishedMsg is included in the transcript, but not until after we
 	// check the client version, since the state before this message was
@@ -34,11 +34,29 @@ type clientHandshakeStateTLS13 struct {
 	transcript    hash.Hash
 	masterSecret  []byte
 	trafficSecret []byte // client_application_traffic_secret_0
+
+	steps handshakeSteps // pending steps of the handshake
 }
 
 // handshake requires hs.c, hs.hello, hs.serverHello, hs.ecdheKey, and,
 // optionally, hs.session, hs.earlySecret and hs.binderKey to be set.
 func (hs *clientHandshakeStateTLS13) handshake() error {
+	if hs.steps == nil {
+		hs.steps = handshakeSteps{
+			hs.processHello,
+			hs.establishKeys,
+			hs.readServerParameters,
+			hs.readServerCertificate,
+			hs.readServerFinished,
+			hs.sendClientFlight,
+		}
+	}
+	return hs.steps.run()
+}
+
+// processHello processes the ServerHello, or the HelloRetryRequest in which case
+// the ClientHello is sent again.
+func (hs *clientHandshakeStateTLS13) processHello() error {
 	c := hs.c
 
 	if needFIPS() {
@@ -74,8 +92,16 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 		if err := hs.processHelloRetryRequest(); err != nil {
 			return err
 		}
+		hs.steps.then(hs.readRetriedServerHello)
 	}
 
+	return nil
+}
+
+// establishKeys processes the ServerHello and derives the handshake keys.
+func (hs *clientHandshakeStateTLS13) establishKeys() error {
+	c := hs.c
+
 	if err := transcriptMsg(hs.serverHello, hs.transcript); err != nil {
 		return err
 	}
@@ -87,18 +113,13 @@ func (hs *clientHandshakeStateTLS13) handshake() error {
 	if err := hs.sendDummyChangeCipherSpec(); err != nil {
 		return err
 	}
-	if err := hs.establishHandshakeKeys(); err != nil {
-		return err
-	}
-	if err := hs.readServerParameters(); err != nil {
-		return err
-	}
-	if err := hs.readServerCertificate(); err != nil {
-		return err
-	}
-	if err := hs.readServerFinished(); err != nil {
-		return err
-	}
+	return hs.establishHandshakeKeys()
+}
+
+// sendClientFlight sends the client's Certificate and Finished, which completes the handshake.
+func (hs *clientHandshakeStateTLS13) sendClientFlight() error {
+	c := hs.c
+
 	if err := hs.sendClientCertificate(); err != nil {
 		return err
 	}
@@ -185,7 +206,8 @@ func (hs *clientHandshakeStateTLS13) sendDummyChangeCipherSpec() error {
 }
 
 // processHelloRetryRequest handles the HRR in hs.serverHello, modifies and
-// resends hs.hello, and reads the new ServerHello into hs.serverHello.
+// resends hs.hello, the new ServerHello is read into hs.serverHello by
+// readRetriedServerHello.
 func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 	c := hs.c
 
@@ -287,9 +309,14 @@ func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
 		c.quicRejectedEarlyData()
 	}
 
-	if _, err := hs.c.writeHandshakeRecord(hs.hello, hs.transcript); err != nil {
-		return err
-	}
+	_, err := hs.c.writeHandshakeRecord(hs.hello, hs.transcript)
+	return err
+}
+
+// readRetriedServerHello reads the ServerHello in response to the ClientHello
+// that was sent again for the HelloRetryRequest.
+func (hs *clientHandshakeStateTLS13) readRetriedServerHello() error {
+	c := hs.c
 
 	// serverHelloMsg is not included in the transcript
 	msg, err := c.readHandshake(nil)
@@ -508,12 +535,25 @@ func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
 	certReq, ok := msg.(*certificateRequestMsgTLS13)
 	if ok {
 		hs.certReq = certReq
+		hs.steps.then(hs.readCertificate)
+		return nil
+	}
 
-		msg, err = c.readHandshake(hs.transcript)
-		if err != nil {
-			return err
-		}
+	return hs.processCertificate(msg)
+}
+
+// readCertificate reads the server's Certificate that follows the CertificateRequest.
+func (hs *clientHandshakeStateTLS13) readCertificate() error {
+	msg, err := hs.c.readHandshake(hs.transcript)
+	if err != nil {
+		return err
 	}
+	return hs.processCertificate(msg)
+}
+
+// processCertificate verifies the server's Certificate.
+func (hs *clientHandshakeStateTLS13) processCertificate(msg any) error {
+	c := hs.c
 
 	certMsg, ok := msg.(*certificateMsgTLS13)
 	if !ok {
@@ -532,10 +572,18 @@ func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
 		return err
 	}
 
+	hs.steps.then(hs.readCertificateVerify)
+	return nil
+}
+
+// readCertificateVerify reads the server's CertificateVerify and checks the handshake signature.
+func (hs *clientHandshakeStateTLS13) readCertificateVerify() error {
+	c := hs.c
+
 	// certificateVerifyMsg is included in the transcript, but not until
 	// after we verify the handshake signature, since the state before
 	// this message was sent is used.
-	msg, err = c.readHandshake(nil)
+	msg, err := c.readHandshake(nil)
 	if err != nil {
 		return err
 	}
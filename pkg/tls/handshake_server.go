@@ -35,7 +35,12 @@ type serverHandshakeState struct {
 	finishedHash    finishedHash
 	masterSecret    []byte
 	cert            *Certificate
-	nextHandshakeFn func() error
+	keyAgreement    keyAgreement
+	skx             *serverKeyExchangeMsg
+	certReq         *certificateRequestMsg
+	ckx             *clientKeyExchangeMsg
+	preMasterSecret []byte
+	steps           handshakeSteps
 }
 
 // serverHandshake performs a TLS handshake as a server.
@@ -47,19 +52,39 @@ func (c *Conn) serverHandshake(ctx context.Context) error {
 		}
 
 		if c.vers == VersionTLS13 {
-			hs := serverHandshakeStateTLS13{
+			hs := &serverHandshakeStateTLS13{
 				c:           c,
 				ctx:         ctx,
 				clientHello: clientHello,
 			}
-			c.hs = &hs
+			// For an overview of the TLS 1.3 handshake, see RFC 8446, Section 2.
+			hs.steps = handshakeSteps{
+				hs.processClientHello,
+				hs.generateSharedKey,
+				hs.negotiateProtocols,
+				hs.checkForResumption,
+				hs.pickCertificate,
+				hs.sendServerParameters,
+				hs.sendServerCertificate,
+				hs.sendServerCertificateVerify,
+				hs.sendServerFinished,
+				hs.readClientCertificate,
+				hs.readClientCertificateVerify,
+				hs.readClientFinished,
+			}
+			c.hs = hs
 		} else {
-			hs := serverHandshakeState{
+			hs := &serverHandshakeState{
 				c:           c,
 				ctx:         ctx,
 				clientHello: clientHello,
 			}
-			c.hs = &hs
+			hs.steps = handshakeSteps{
+				hs.processClientHello,
+				hs.pickCertificate,
+				hs.chooseHandshake,
+			}
+			c.hs = hs
 		}
 	}
 	return c.hs.handshake()
@@ -68,72 +93,48 @@ func (c *Conn) serverHandshake(ctx context.Context) error {
 func (hs *serverHandshakeState) handshake() error {
 	c := hs.c
 
-	if hs.nextHandshakeFn == nil {
-		if err := hs.processClientHello(); err != nil {
-			return err
-		}
-
-		// For an overview of TLS handshaking, see RFC 5246, Section 7.3.
-		c.buffering = true
-		if err := hs.checkForResumption(); err != nil {
-			return err
-		}
+	if err := hs.steps.run(); err != nil {
+		return err
 	}
 
-	if hs.sessionState != nil {
-		// The client has included a session ticket and so we do an abbreviated handshake.
-		if hs.nextHandshakeFn == nil {
-			if err := hs.doResumeHandshake(); err != nil {
-				return err
-			}
-			if err := hs.establishKeys(); err != nil {
-				return err
-			}
-			if err := hs.sendSessionTicket(); err != nil {
-				return err
-			}
-			if err := hs.sendFinished(c.serverFinished[:]); err != nil {
-				return err
-			}
-			if _, err := c.flush(); err != nil {
-				return err
-			}
-
-			hs.nextHandshakeFn = func() error {
-				c.clientFinishedIsFirst = false
-				if err := hs.readFinished(nil); err != nil {
-					return err
-				}
-
-				c.ekm = ekmFromMasterSecret(c.vers, hs.suite, hs.masterSecret, hs.clientHello.random, hs.hello.random)
-				c.isHandshakeComplete.Store(true)
-				return nil
-			}
+	c.ekm = ekmFromMasterSecret(c.vers, hs.suite, hs.masterSecret, hs.clientHello.random, hs.hello.random)
+	c.isHandshakeComplete.Store(true)
+	return nil
+}
 
-			return nil
-		}
-	} else {
-		// The client didn't include a session ticket, or it wasn't
-		// valid so we do a full handshake.
-		if hs.nextHandshakeFn == nil {
-			hs.nextHandshakeFn = func() error {
-				if err := hs.pickCipherSuite(); err != nil {
-					return err
-				}
-				if err := hs.doFullHandshake(); err != nil {
-					return err
-				}
-				return nil
-			}
-		}
+// chooseHandshake continues with an abbreviated handshake if the session of the
+// client can be resumed, or with a full handshake otherwise.
+func (hs *serverHandshakeState) chooseHandshake() error {
+	// For an overview of TLS handshaking, see RFC 5246, Section 7.3.
+	hs.c.buffering = true
+	if err := hs.checkForResumption(); err != nil {
+		return err
 	}
 
-	if hs.nextHandshakeFn != nil {
-		if err := hs.nextHandshakeFn(); err != nil {
-			return err
-		}
+	if hs.sessionState != nil {
+		// The client has included a session ticket and so we do an abbreviated handshake.
+		hs.steps.then(
+			hs.doResumeHandshake,
+			hs.readChangeCipherSpec,
+			hs.readResumeFinished,
+		)
+		return nil
 	}
 
+	// The client didn't include a session ticket, or it wasn't
+	// valid so we do a full handshake.
+	hs.steps.then(
+		hs.pickCipherSuite,
+		hs.generateServerKeyExchange,
+		hs.doFullHandshake,
+		hs.readClientCertificate,
+		hs.readClientKeyExchange,
+		hs.processClientKeyExchange,
+		hs.readCertificateVerify,
+		hs.establishKeys,
+		hs.readChangeCipherSpec,
+		hs.readClientFinished,
+	)
 	return nil
 }
 
@@ -242,13 +243,13 @@ func (hs *serverHandshakeState) processClientHello() error {
 	hs.hello.alpnProtocol = selectedProto
 	c.clientProtocol = selectedProto
 
-	hs.cert, err = c.config.getCertificate(clientHelloInfo(hs.ctx, c, hs.clientHello))
-	if err != nil {
-		if err == errNoCertificates {
-			c.sendAlert(alertUnrecognizedName)
-		} else {
-			c.sendAlert(alertInternalError)
-		}
+	return nil
+}
+
+func (hs *serverHandshakeState) pickCertificate() error {
+	c := hs.c
+
+	if err := c.getCertificate(clientHelloInfo(hs.ctx, c, hs.clientHello), &hs.cert); err != nil {
 		return err
 	}
 	if hs.clientHello.scts {
@@ -547,9 +548,42 @@ func (hs *serverHandshakeState) doResumeHandshake() error {
 
 	hs.masterSecret = hs.sessionState.secret
 
+	if err := hs.establishKeys(); err != nil {
+		return err
+	}
+	if err := hs.sendSessionTicket(); err != nil {
+		return err
+	}
+	if err := hs.sendFinished(c.serverFinished[:]); err != nil {
+		return err
+	}
+	if _, err := c.flush(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+func (hs *serverHandshakeState) readResumeFinished() error {
+	hs.c.clientFinishedIsFirst = false
+	return hs.readFinished(nil)
+}
+
+// generateServerKeyExchange is offloaded as it takes the key generation of ECDHE
+// and the signature of the server key exchange.
+func (hs *serverHandshakeState) generateServerKeyExchange() error {
+	c := hs.c
+
+	return c.offload(func() (err error) {
+		hs.keyAgreement = hs.suite.ka(c.vers)
+		hs.skx, err = hs.keyAgreement.generateServerKeyExchange(c.config, hs.cert, hs.clientHello, hs.hello)
+		if err != nil {
+			return &alertError{alertHandshakeFailure, err}
+		}
+		return nil
+	})
+}
+
 func (hs *serverHandshakeState) doFullHandshake() error {
 	c := hs.c
 
@@ -587,22 +621,15 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 		}
 	}
 
-	keyAgreement := hs.suite.ka(c.vers)
-	skx, err := keyAgreement.generateServerKeyExchange(c.config, hs.cert, hs.clientHello, hs.hello)
-	if err != nil {
-		c.sendAlert(alertHandshakeFailure)
-		return err
-	}
-	if skx != nil {
-		if _, err := hs.c.writeHandshakeRecord(skx, &hs.finishedHash); err != nil {
+	if hs.skx != nil {
+		if _, err := hs.c.writeHandshakeRecord(hs.skx, &hs.finishedHash); err != nil {
 			return err
 		}
 	}
 
-	var certReq *certificateRequestMsg
 	if c.config.ClientAuth >= RequestClientCert {
 		// Request a client certificate
-		certReq = new(certificateRequestMsg)
+		certReq := new(certificateRequestMsg)
 		certReq.certificateTypes = []byte{
 			byte(certTypeRSASign),
 			byte(certTypeECDSASign),
@@ -623,6 +650,7 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 		if _, err := hs.c.writeHandshakeRecord(certReq, &hs.finishedHash); err != nil {
 			return err
 		}
+		hs.certReq = certReq
 	}
 
 	helloDone := new(serverHelloDoneMsg)
@@ -634,158 +662,167 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 		return err
 	}
 
-	// next read finished hash
-	hs.nextHandshakeFn = func() error {
-		msg, err := c.readHandshake(&hs.finishedHash)
-		if err != nil {
+	return nil
+}
+
+func (hs *serverHandshakeState) readClientCertificate() error {
+	c := hs.c
+
+	// If we requested a client certificate, then the client must send a
+	// certificate message, even if it's empty.
+	if c.config.ClientAuth < RequestClientCert {
+		return nil
+	}
+
+	msg, err := c.readHandshake(&hs.finishedHash)
+	if err != nil {
+		return err
+	}
+	certMsg, ok := msg.(*certificateMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(certMsg, msg)
+	}
+
+	return c.processCertsFromClient(Certificate{
+		Certificate: certMsg.certificates,
+	})
+}
+
+func (hs *serverHandshakeState) readClientKeyExchange() error {
+	c := hs.c
+
+	msg, err := c.readHandshake(&hs.finishedHash)
+	if err != nil {
+		return err
+	}
+
+	if c.config.VerifyConnection != nil {
+		if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
+			c.sendAlert(alertBadCertificate)
 			return err
 		}
+	}
 
-		// next
-		hs.nextHandshakeFn = func() error {
-			var pub crypto.PublicKey // public key for client auth, if any
-			// If we requested a client certificate, then the client must send a
-			// certificate message, even if it's empty.
-			if c.config.ClientAuth >= RequestClientCert {
-				certMsg, ok := msg.(*certificateMsg)
-				if !ok {
-					c.sendAlert(alertUnexpectedMessage)
-					return unexpectedMessageError(certMsg, msg)
-				}
-
-				if err := c.processCertsFromClient(Certificate{
-					Certificate: certMsg.certificates,
-				}); err != nil {
-					return err
-				}
-				if len(certMsg.certificates) != 0 {
-					pub = c.peerCertificates[0].PublicKey
-				}
-
-				msg, err = c.readHandshake(&hs.finishedHash)
-				if err != nil {
-					return err
-				}
-			}
-			if c.config.VerifyConnection != nil {
-				if err := c.config.VerifyConnection(c.connectionStateLocked()); err != nil {
-					c.sendAlert(alertBadCertificate)
-					return err
-				}
-			}
+	// Get client key exchange
+	ckx, ok := msg.(*clientKeyExchangeMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(ckx, msg)
+	}
+	hs.ckx = ckx
 
-			// Get client key exchange
-			ckx, ok := msg.(*clientKeyExchangeMsg)
-			if !ok {
-				c.sendAlert(alertUnexpectedMessage)
-				return unexpectedMessageError(ckx, msg)
-			}
+	return nil
+}
 
-			preMasterSecret, err := keyAgreement.processClientKeyExchange(c.config, hs.cert, ckx, c.vers)
-			if err != nil {
-				c.sendAlert(alertHandshakeFailure)
-				return err
+func (hs *serverHandshakeState) processClientKeyExchange() error {
+	c := hs.c
+
+	// The client key exchange is offloaded as it takes the ECDH or the RSA decryption.
+	err := c.offload(func() (err error) {
+		hs.preMasterSecret, err = hs.keyAgreement.processClientKeyExchange(c.config, hs.cert, hs.ckx, c.vers)
+		if err != nil {
+			return &alertError{alertHandshakeFailure, err}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if hs.hello.extendedMasterSecret {
+		c.extMasterSecret = true
+		hs.masterSecret = extMasterFromPreMasterSecret(c.vers, hs.suite, hs.preMasterSecret,
+			hs.finishedHash.Sum())
+	} else {
+		hs.masterSecret = masterFromPreMasterSecret(c.vers, hs.suite, hs.preMasterSecret,
+			hs.clientHello.random, hs.hello.random)
+	}
+	if err := c.config.writeKeyLog(keyLogLabelTLS12, hs.clientHello.random, hs.masterSecret); err != nil {
+		c.sendAlert(alertInternalError)
+		return err
+	}
+
+	return nil
+}
+
+func (hs *serverHandshakeState) readCertificateVerify() error {
+	c := hs.c
+
+	// If we received a client cert in response to our certificate request message,
+	// the client will send us a certificateVerifyMsg immediately after the
+	// clientKeyExchangeMsg. This message is a digest of all preceding
+	// handshake-layer messages that is signed using the private key corresponding
+	// to the client's certificate. This allows us to verify that the client is in
+	// possession of the private key of the certificate.
+	if len(c.peerCertificates) > 0 {
+		// certificateVerifyMsg is included in the transcript, but not until
+		// after we verify the handshake signature, since the state before
+		// this message was sent is used.
+		msg, err := c.readHandshake(nil)
+		if err != nil {
+			return err
+		}
+		certVerify, ok := msg.(*certificateVerifyMsg)
+		if !ok {
+			c.sendAlert(alertUnexpectedMessage)
+			return unexpectedMessageError(certVerify, msg)
+		}
+
+		pub := c.peerCertificates[0].PublicKey
+		var sigType uint8
+		var sigHash crypto.Hash
+		if c.vers >= VersionTLS12 {
+			if !isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, hs.certReq.supportedSignatureAlgorithms) {
+				c.sendAlert(alertIllegalParameter)
+				return errors.New("tls: client certificate used with invalid signature algorithm")
 			}
-			if hs.hello.extendedMasterSecret {
-				c.extMasterSecret = true
-				hs.masterSecret = extMasterFromPreMasterSecret(c.vers, hs.suite, preMasterSecret,
-					hs.finishedHash.Sum())
-			} else {
-				hs.masterSecret = masterFromPreMasterSecret(c.vers, hs.suite, preMasterSecret,
-					hs.clientHello.random, hs.hello.random)
+			sigType, sigHash, err = typeAndHashFromSignatureScheme(certVerify.signatureAlgorithm)
+			if err != nil {
+				return c.sendAlert(alertInternalError)
 			}
-			if err := c.config.writeKeyLog(keyLogLabelTLS12, hs.clientHello.random, hs.masterSecret); err != nil {
-				c.sendAlert(alertInternalError)
+		} else {
+			sigType, sigHash, err = legacyTypeAndHashFromPublicKey(pub)
+			if err != nil {
+				c.sendAlert(alertIllegalParameter)
 				return err
 			}
+		}
 
-			// If we received a client cert in response to our certificate request message,
-			// the client will send us a certificateVerifyMsg immediately after the
-			// clientKeyExchangeMsg. This message is a digest of all preceding
-			// handshake-layer messages that is signed using the private key corresponding
-			// to the client's certificate. This allows us to verify that the client is in
-			// possession of the private key of the certificate.
-			if len(c.peerCertificates) > 0 {
-				// certificateVerifyMsg is included in the transcript, but not until
-				// after we verify the handshake signature, since the state before
-				// this message was sent is used.
-				msg, err = c.readHandshake(nil)
-				if err != nil {
-					return err
-				}
-				certVerify, ok := msg.(*certificateVerifyMsg)
-				if !ok {
-					c.sendAlert(alertUnexpectedMessage)
-					return unexpectedMessageError(certVerify, msg)
-				}
-
-				var sigType uint8
-				var sigHash crypto.Hash
-				if c.vers >= VersionTLS12 {
-					if !isSupportedSignatureAlgorithm(certVerify.signatureAlgorithm, certReq.supportedSignatureAlgorithms) {
-						c.sendAlert(alertIllegalParameter)
-						return errors.New("tls: client certificate used with invalid signature algorithm")
-					}
-					sigType, sigHash, err = typeAndHashFromSignatureScheme(certVerify.signatureAlgorithm)
-					if err != nil {
-						return c.sendAlert(alertInternalError)
-					}
-				} else {
-					sigType, sigHash, err = legacyTypeAndHashFromPublicKey(pub)
-					if err != nil {
-						c.sendAlert(alertIllegalParameter)
-						return err
-					}
-				}
-
-				signed := hs.finishedHash.hashForClientCertificate(sigType, sigHash)
-				if err := verifyHandshakeSignature(sigType, pub, sigHash, signed, certVerify.signature); err != nil {
-					c.sendAlert(alertDecryptError)
-					return errors.New("tls: invalid signature by the client certificate: " + err.Error())
-				}
-
-				if err := transcriptMsg(certVerify, &hs.finishedHash); err != nil {
-					return err
-				}
-			}
-
-			hs.finishedHash.discardHandshakeBuffer()
-			hs.nextHandshakeFn = func() error {
-				if err := hs.establishKeys(); err != nil {
-					return err
-				}
-
-				if err := c.readChangeCipherSpec(); err != nil {
-					return err
-				}
-
-				hs.nextHandshakeFn = func() error {
-					if err := hs.readFinished(c.clientFinished[:]); err != nil {
-						return err
-					}
-					c.clientFinishedIsFirst = true
-					c.buffering = true
-					if err := hs.sendSessionTicket(); err != nil {
-						return err
-					}
-					if err := hs.sendFinished(nil); err != nil {
-						return err
-					}
-					if _, err := c.flush(); err != nil {
-						return err
-					}
-					c.ekm = ekmFromMasterSecret(c.vers, hs.suite, hs.masterSecret, hs.clientHello.random, hs.hello.random)
-					c.isHandshakeComplete.Store(true)
-					return nil
-				}
-
-				return nil
-			}
+		signed := hs.finishedHash.hashForClientCertificate(sigType, sigHash)
+		if err := verifyHandshakeSignature(sigType, pub, sigHash, signed, certVerify.signature); err != nil {
+			c.sendAlert(alertDecryptError)
+			return errors.New("tls: invalid signature by the client certificate: " + err.Error())
+		}
 
-			return nil
+		if err := transcriptMsg(certVerify, &hs.finishedHash); err != nil {
+			return err
 		}
+	}
 
-		return nil
+	hs.finishedHash.discardHandshakeBuffer()
+	return nil
+}
+
+func (hs *serverHandshakeState) readChangeCipherSpec() error {
+	return hs.c.readChangeCipherSpec()
+}
+
+func (hs *serverHandshakeState) readClientFinished() error {
+	c := hs.c
+
+	if err := hs.readFinished(c.clientFinished[:]); err != nil {
+		return err
+	}
+	c.clientFinishedIsFirst = true
+	c.buffering = true
+	if err := hs.sendSessionTicket(); err != nil {
+		return err
+	}
+	if err := hs.sendFinished(nil); err != nil {
+		return err
+	}
+	if _, err := c.flush(); err != nil {
+		return err
 	}
 
 	return nil
@@ -812,6 +849,8 @@ func (hs *serverHandshakeState) establishKeys() error {
 
 	c.in.prepareCipherSpec(c.vers, clientCipher, clientHash)
 	c.out.prepareCipherSpec(c.vers, serverCipher, serverHash)
+	c.in.prepareKeys(clientKey, clientIV)
+	c.out.prepareKeys(serverKey, serverIV)
 
 	return nil
 }
@@ -990,6 +1029,24 @@ func (c *Conn) processCertsFromClient(certificate Certificate) error {
 	return nil
 }
 
+// getCertificate picks the certificate for the client, Config.GetCertificate is
+// offloaded as it may take a while, e.g. when it fetches the certificate remotely.
+func (c *Conn) getCertificate(clientHello *ClientHelloInfo, cert **Certificate) error {
+	getCertificate := func() (err error) {
+		*cert, err = c.config.getCertificate(clientHello)
+		if err == errNoCertificates {
+			return &alertError{alertUnrecognizedName, err}
+		} else if err != nil {
+			return &alertError{alertInternalError, err}
+		}
+		return nil
+	}
+	if c.config.GetCertificate == nil {
+		return c.handleAlertError(getCertificate())
+	}
+	return c.offload(getCertificate)
+}
+
 func clientHelloInfo(ctx context.Context, c *Conn, clientHello *clientHelloMsg) *ClientHelloInfo {
 	supportedVersions := clientHello.supportedVersions
 	if len(clientHello.supportedVersions) == 0 {
@@ -40,60 +40,28 @@ type serverHandshakeStateTLS13 struct {
 	trafficSecret   []byte // client_application_traffic_secret_0
 	transcript      hash.Hash
 	clientFinished  []byte
+	selectedGroup   CurveID
+	clientKeyShare  *keyShare
+	signature       []byte // signature of the CertificateVerify
+	steps           handshakeSteps
 }
 
 func (hs *serverHandshakeStateTLS13) handshake() error {
-	c := hs.c
-
-	switch c.handshakeState {
-	case 0:
-		if needFIPS() {
-			return errors.New("tls: internal error: TLS 1.3 reached in FIPS mode")
-		}
-
-		// For an overview of the TLS 1.3 handshake, see RFC 8446, Section 2.
-		if err := hs.processClientHello(); err != nil {
-			return err
-		}
-		if err := hs.checkForResumption(); err != nil {
-			return err
-		}
-		if err := hs.pickCertificate(); err != nil {
-			return err
-		}
-		c.buffering = true
-		if err := hs.sendServerParameters(); err != nil {
-			return err
-		}
-		if err := hs.sendServerCertificate(); err != nil {
-			return err
-		}
-		if err := hs.sendServerFinished(); err != nil {
-			return err
-		}
-		// Note that at this point we could start sending application data without
-		// waiting for the client's second flight, but the application might not
-		// expect the lack of replay protection of the ClientHello parameters.
-		if _, err := c.flush(); err != nil {
-			return err
-		}
-		c.handshakeState = 1
-	case 1:
-		if err := hs.readClientCertificate(); err != nil {
-			return err
-		}
-		if err := hs.readClientFinished(); err != nil {
-			return err
-		}
-		c.isHandshakeComplete.Store(true)
+	if err := hs.steps.run(); err != nil {
+		return err
 	}
 
+	hs.c.isHandshakeComplete.Store(true)
 	return nil
 }
 
 func (hs *serverHandshakeStateTLS13) processClientHello() error {
 	c := hs.c
 
+	if needFIPS() {
+		return errors.New("tls: internal error: TLS 1.3 reached in FIPS mode")
+	}
+
 	hs.hello = new(serverHelloMsg)
 
 	// TLS 1.3 froze the ServerHello.legacy_version field, and uses
@@ -208,33 +176,47 @@ GroupSelection:
 		c.sendAlert(alertHandshakeFailure)
 		return errors.New("tls: no ECDHE curve supported by both client and server")
 	}
+	hs.selectedGroup = selectedGroup
 	if clientKeyShare == nil {
 		if err := hs.doHelloRetryRequest(selectedGroup); err != nil {
 			return err
 		}
-		clientKeyShare = &hs.clientHello.keyShares[0]
+		hs.steps.then(hs.readRetryClientHello)
+		return nil
 	}
+	hs.clientKeyShare = clientKeyShare
 
-	if _, ok := curveForCurveID(selectedGroup); !ok {
+	return nil
+}
+
+// generateSharedKey is offloaded as it takes the key generation and the ECDH.
+func (hs *serverHandshakeStateTLS13) generateSharedKey() error {
+	c := hs.c
+
+	if _, ok := curveForCurveID(hs.selectedGroup); !ok {
 		c.sendAlert(alertInternalError)
 		return errors.New("tls: CurvePreferences includes unsupported curve")
 	}
-	key, err := generateECDHEKey(c.config.rand(), selectedGroup)
-	if err != nil {
-		c.sendAlert(alertInternalError)
-		return err
-	}
-	hs.hello.serverShare = keyShare{group: selectedGroup, data: key.PublicKey().Bytes()}
-	peerKey, err := key.Curve().NewPublicKey(clientKeyShare.data)
-	if err != nil {
-		c.sendAlert(alertIllegalParameter)
-		return errors.New("tls: invalid client key share")
-	}
-	hs.sharedKey, err = key.ECDH(peerKey)
-	if err != nil {
-		c.sendAlert(alertIllegalParameter)
-		return errors.New("tls: invalid client key share")
-	}
+	return c.offload(func() error {
+		key, err := generateECDHEKey(c.config.rand(), hs.selectedGroup)
+		if err != nil {
+			return &alertError{alertInternalError, err}
+		}
+		hs.hello.serverShare = keyShare{group: hs.selectedGroup, data: key.PublicKey().Bytes()}
+		peerKey, err := key.Curve().NewPublicKey(hs.clientKeyShare.data)
+		if err != nil {
+			return &alertError{alertIllegalParameter, errors.New("tls: invalid client key share")}
+		}
+		hs.sharedKey, err = key.ECDH(peerKey)
+		if err != nil {
+			return &alertError{alertIllegalParameter, errors.New("tls: invalid client key share")}
+		}
+		return nil
+	})
+}
+
+func (hs *serverHandshakeStateTLS13) negotiateProtocols() error {
+	c := hs.c
 
 	selectedProto, err := negotiateALPN(c.config.NextProtos, hs.clientHello.alpnProtocols, c.quic != nil)
 	if err != nil {
@@ -443,23 +425,17 @@ func (hs *serverHandshakeStateTLS13) pickCertificate() error {
 		return c.sendAlert(alertMissingExtension)
 	}
 
-	certificate, err := c.config.getCertificate(clientHelloInfo(hs.ctx, c, hs.clientHello))
-	if err != nil {
-		if err == errNoCertificates {
-			c.sendAlert(alertUnrecognizedName)
-		} else {
-			c.sendAlert(alertInternalError)
-		}
+	if err := c.getCertificate(clientHelloInfo(hs.ctx, c, hs.clientHello), &hs.cert); err != nil {
 		return err
 	}
-	hs.sigAlg, err = selectSignatureScheme(c.vers, certificate, hs.clientHello.supportedSignatureAlgorithms)
+	sigAlg, err := selectSignatureScheme(c.vers, hs.cert, hs.clientHello.supportedSignatureAlgorithms)
 	if err != nil {
 		// getCertificate returned a certificate that is unsupported or
 		// incompatible with the client's signature algorithms.
 		c.sendAlert(alertHandshakeFailure)
 		return err
 	}
-	hs.cert = certificate
+	hs.sigAlg = sigAlg
 
 	return nil
 }
@@ -479,8 +455,6 @@ func (hs *serverHandshakeStateTLS13) sendDummyChangeCipherSpec() error {
 }
 
 func (hs *serverHandshakeStateTLS13) doHelloRetryRequest(selectedGroup CurveID) error {
-	c := hs.c
-
 	// The first ClientHello gets double-hashed into the transcript upon a
 	// HelloRetryRequest. See RFC 8446, Section 4.4.1.
 	if err := transcriptMsg(hs.clientHello, hs.transcript); err != nil {
@@ -505,9 +479,12 @@ func (hs *serverHandshakeStateTLS13) doHelloRetryRequest(selectedGroup CurveID)
 		return err
 	}
 
-	if err := hs.sendDummyChangeCipherSpec(); err != nil {
-		return err
-	}
+	return hs.sendDummyChangeCipherSpec()
+}
+
+// readRetryClientHello reads the second ClientHello sent in response to the HelloRetryRequest.
+func (hs *serverHandshakeStateTLS13) readRetryClientHello() error {
+	c := hs.c
 
 	// clientHelloMsg is not included in the transcript.
 	msg, err := c.readHandshake(nil)
@@ -521,7 +498,7 @@ func (hs *serverHandshakeStateTLS13) doHelloRetryRequest(selectedGroup CurveID)
 		return unexpectedMessageError(clientHello, msg)
 	}
 
-	if len(clientHello.keyShares) != 1 || clientHello.keyShares[0].group != selectedGroup {
+	if len(clientHello.keyShares) != 1 || clientHello.keyShares[0].group != hs.selectedGroup {
 		c.sendAlert(alertIllegalParameter)
 		return errors.New("tls: client sent invalid key share in second ClientHello")
 	}
@@ -537,6 +514,7 @@ func (hs *serverHandshakeStateTLS13) doHelloRetryRequest(selectedGroup CurveID)
 	}
 
 	hs.clientHello = clientHello
+	hs.clientKeyShare = &hs.clientHello.keyShares[0]
 	return nil
 }
 
@@ -601,6 +579,7 @@ func illegalClientHelloChange(ch, ch1 *clientHelloMsg) bool {
 func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
 	c := hs.c
 
+	c.buffering = true
 	if err := transcriptMsg(hs.clientHello, hs.transcript); err != nil {
 		return err
 	}
@@ -701,9 +680,17 @@ func (hs *serverHandshakeStateTLS13) sendServerCertificate() error {
 		return err
 	}
 
-	certVerifyMsg := new(certificateVerifyMsg)
-	certVerifyMsg.hasSignatureAlgorithm = true
-	certVerifyMsg.signatureAlgorithm = hs.sigAlg
+	return nil
+}
+
+// sendServerCertificateVerify offloads the signature of the CertificateVerify.
+func (hs *serverHandshakeStateTLS13) sendServerCertificateVerify() error {
+	c := hs.c
+
+	// Only one of PSK and certificates are used at a time.
+	if hs.usingPSK {
+		return nil
+	}
 
 	sigType, sigHash, err := typeAndHashFromSignatureScheme(hs.sigAlg)
 	if err != nil {
@@ -711,22 +698,31 @@ func (hs *serverHandshakeStateTLS13) sendServerCertificate() error {
 	}
 
 	signed := signedMessage(sigHash, serverSignatureContext, hs.transcript)
-	signOpts := crypto.SignerOpts(sigHash)
-	if sigType == signatureRSAPSS {
-		signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
-	}
-	sig, err := hs.cert.PrivateKey.(crypto.Signer).Sign(c.config.rand(), signed, signOpts)
-	if err != nil {
-		public := hs.cert.PrivateKey.(crypto.Signer).Public()
-		if rsaKey, ok := public.(*rsa.PublicKey); ok && sigType == signatureRSAPSS &&
-			rsaKey.N.BitLen()/8 < sigHash.Size()*2+2 { // key too small for RSA-PSS
-			c.sendAlert(alertHandshakeFailure)
-		} else {
-			c.sendAlert(alertInternalError)
+	err = c.offload(func() (err error) {
+		signOpts := crypto.SignerOpts(sigHash)
+		if sigType == signatureRSAPSS {
+			signOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: sigHash}
 		}
-		return errors.New("tls: failed to sign handshake: " + err.Error())
+		hs.signature, err = hs.cert.PrivateKey.(crypto.Signer).Sign(c.config.rand(), signed, signOpts)
+		if err != nil {
+			err = errors.New("tls: failed to sign handshake: " + err.Error())
+			public := hs.cert.PrivateKey.(crypto.Signer).Public()
+			if rsaKey, ok := public.(*rsa.PublicKey); ok && sigType == signatureRSAPSS &&
+				rsaKey.N.BitLen()/8 < sigHash.Size()*2+2 { // key too small for RSA-PSS
+				return &alertError{alertHandshakeFailure, err}
+			}
+			return &alertError{alertInternalError, err}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	certVerifyMsg.signature = sig
+
+	certVerifyMsg := new(certificateVerifyMsg)
+	certVerifyMsg.hasSignatureAlgorithm = true
+	certVerifyMsg.signatureAlgorithm = hs.sigAlg
+	certVerifyMsg.signature = hs.signature
 
 	if _, err := hs.c.writeHandshakeRecord(certVerifyMsg, hs.transcript); err != nil {
 		return err
@@ -787,6 +783,13 @@ func (hs *serverHandshakeStateTLS13) sendServerFinished() error {
 		}
 	}
 
+	// Note that at this point we could start sending application data without
+	// waiting for the client's second flight, but the application might not
+	// expect the lack of replay protection of the ClientHello parameters.
+	if _, err := c.flush(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -927,11 +930,22 @@ func (hs *serverHandshakeStateTLS13) readClientCertificate() error {
 		}
 	}
 
-	if len(certMsg.certificate.Certificate) != 0 {
+	return nil
+}
+
+func (hs *serverHandshakeStateTLS13) readClientCertificateVerify() error {
+	c := hs.c
+
+	if !hs.requestClientCert() {
+		return nil
+	}
+
+	// If the client sent an empty certificate message, no CertificateVerify is sent.
+	if len(c.peerCertificates) != 0 {
 		// certificateVerifyMsg is included in the transcript, but not until
 		// after we verify the handshake signature, since the state before
 		// this message was sent is used.
-		msg, err = c.readHandshake(nil)
+		msg, err := c.readHandshake(nil)
 		if err != nil {
 			return err
 		}
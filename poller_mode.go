@@ -1,4 +1,4 @@
-// Copyright (c) 2021 The Gnet Authors. All rights reserved.
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
 //
 // Licensed under the Apache License, Version 2.0 (the "License");
 // you may not use this file except in compliance with the License.
@@ -15,8 +15,7 @@
 //go:build !poll_opt
 // +build !poll_opt
 
-package netpoll
+package gnet
 
-import "golang.org/x/sys/unix"
-
-type epollevent = unix.EpollEvent
+// defaultPollerMode is the poller mode that PollerAuto stands for.
+const defaultPollerMode = PollerDefault
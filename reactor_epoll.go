@@ -12,8 +12,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:build linux && !poll_opt
-// +build linux,!poll_opt
+//go:build linux
+// +build linux
 
 package gnet
 
@@ -31,7 +31,12 @@ func (el *eventloop) rotate() error {
 		defer runtime.UnlockOSThread()
 	}
 
-	err := el.poller.Polling(el.accept0)
+	err := el.poller.Polling(func(fd int, ev netpoll.IOEvent, flags netpoll.IOFlags) error {
+		if el.ring != nil && fd == el.ring.Fd() {
+			return el.ring.process(fd, ev, flags)
+		}
+		return el.accept0(fd, ev, flags)
+	})
 	if errors.Is(err, errorx.ErrEngineShutdown) {
 		el.getLogger().Debugf("main reactor is exiting in terms of the demand from user, %v", err)
 		err = nil
@@ -53,6 +58,9 @@ func (el *eventloop) orbit() error {
 	err := el.poller.Polling(func(fd int, ev netpoll.IOEvent, flags netpoll.IOFlags) error {
 		c := el.connections.getConn(fd)
 		if c == nil {
+			if el.ring != nil && fd == el.ring.Fd() {
+				return el.ring.process(fd, ev, flags)
+			}
 			// Somehow epoll notified with an event for a stale fd that is not in our connection set.
 			// We need to delete it from the epoll set.
 			return el.poller.Delete(fd)
@@ -84,6 +92,12 @@ func (el *eventloop) run() error {
 			if _, ok := el.listeners[fd]; ok {
 				return el.accept(fd, ev, flags)
 			}
+			if el.ring != nil && fd == el.ring.Fd() {
+				return el.ring.process(fd, ev, flags)
+			}
+			if d, ok := el.dialing[fd]; ok {
+				return el.connected(d)
+			}
 			// Somehow epoll notified with an event for a stale fd that is not in our connection set.
 			// We need to delete it from the epoll set.
 			return el.poller.Delete(fd)
@@ -12,9 +12,8 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-//go:build (freebsd || dragonfly || netbsd || openbsd || darwin) && !poll_opt
+//go:build freebsd || dragonfly || netbsd || openbsd || darwin
 // +build freebsd dragonfly netbsd openbsd darwin
-// +build !poll_opt
 
 package gnet
 
@@ -87,6 +86,9 @@ func (el *eventloop) run() error {
 			if _, ok := el.listeners[fd]; ok {
 				return el.accept(fd, filter, flags)
 			}
+			if d, ok := el.dialing[fd]; ok {
+				return el.connected(d)
+			}
 			// This might happen when the connection has already been closed,
 			// the file descriptor will be deleted from kqueue automatically
 			// as documented in the manual pages, So we just print a warning log.
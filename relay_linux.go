@@ -0,0 +1,363 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+
+	gio "github.com/panjf2000/gnet/v2/internal/io"
+	"github.com/panjf2000/gnet/v2/internal/netpoll"
+	"github.com/panjf2000/gnet/v2/internal/queue"
+	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
+)
+
+const spliceFlags = unix.SPLICE_F_MOVE | unix.SPLICE_F_NONBLOCK
+
+// Relay forwards the data between a and b in both directions until either of them is closed,
+// the data is moved by the kernel through a pipe with splice(2) without being copied into the
+// user space, and the connections can live on different event-loops. It's concurrency-safe.
+//
+// Once the relay is established, the EventHandler.OnTraffic of a and b will not be invoked and
+// the data that has been read into the inbound buffer of either connection but not consumed yet
+// is forwarded ahead of the data from the socket. Reading from a connection pauses while its pipe
+// is full until the other connection catches up. When either connection reaches EOF, the data in
+// flight is delivered to the other connection, then the former is closed and the latter is handed
+// back to the EventHandler. Any I/O error closes the failed connection and hands back the other one.
+//
+// Don't read from or write to a or b while they are relayed.
+//
+// ErrUnsupportedOp is returned for UDP, SOCK_SEQPACKET, TLS connections and connections driven by io_uring.
+func Relay(a, b Conn) error {
+	ca, ok := a.(*conn)
+	if !ok {
+		return errorx.ErrUnsupportedOp
+	}
+	cb, ok := b.(*conn)
+	if !ok || ca == cb || ca.isDatagram || ca.isPacket || cb.isDatagram || cb.isPacket || ca.inRing || cb.inRing {
+		return errorx.ErrUnsupportedOp
+	}
+
+	r := &relay{a: ca, b: cb, refs: 2}
+	r.ab.src, r.ab.dst = ca, cb
+	r.ba.src, r.ba.dst = cb, ca
+	if err := r.ab.open(); err != nil {
+		return err
+	}
+	if err := r.ba.open(); err != nil {
+		r.ab.close()
+		return err
+	}
+	if err := ca.loop.poller.Trigger(queue.HighPriority, r.attach, ca); err != nil {
+		r.close()
+		return err
+	}
+	if err := cb.loop.poller.Trigger(queue.HighPriority, r.attach, cb); err != nil {
+		// The relay can't be stopped by the event-loop of b, do it here.
+		atomic.StoreInt32(&r.stopped, 1)
+		r.release()
+		return err
+	}
+	return nil
+}
+
+// relay forwards the data between two connections with splice(2).
+//
+// Each connection is only accessed on its own event-loop, the states of a relayPipe shared by the
+// event-loops of the reader and the writer are synchronized by atomic operations, and the event-loops
+// notify each other by the asynchronous tasks of poller.
+type relay struct {
+	a, b    *conn
+	ab, ba  relayPipe
+	stopped int32 // whether either connection has left the relay
+	refs    int32 // number of connections that haven't left the relay
+}
+
+// relayPipe is a pipe that moves the data from src to dst.
+type relayPipe struct {
+	src, dst *conn
+	rfd, wfd int
+	size     int64 // capacity of the pipe
+	buffered int64 // number of bytes in the pipe
+	paused   int32 // whether the reading from src is paused since the pipe might be full
+	held     int32 // whether the reading from src is held until the data read ahead has been forwarded
+	draining int32 // whether the draining of the pipe has been scheduled on the event-loop of dst
+	eof      int32 // whether src has reached EOF
+}
+
+func (p *relayPipe) open() error {
+	var fds [2]int
+	if err := unix.Pipe2(fds[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		return os.NewSyscallError("pipe2", err)
+	}
+	p.rfd, p.wfd = fds[0], fds[1]
+	size, err := unix.FcntlInt(uintptr(p.wfd), unix.F_GETPIPE_SZ, 0)
+	if err != nil {
+		p.close()
+		return os.NewSyscallError("fcntl", err)
+	}
+	p.size = int64(size)
+	return nil
+}
+
+func (p *relayPipe) close() {
+	_ = unix.Close(p.rfd)
+	_ = unix.Close(p.wfd)
+}
+
+func (r *relay) close() {
+	r.ab.close()
+	r.ba.close()
+}
+
+func (r *relay) peer(c *conn) *conn {
+	if c == r.a {
+		return r.b
+	}
+	return r.a
+}
+
+// outbound returns the pipe that c is the reader of.
+func (r *relay) outbound(c *conn) *relayPipe {
+	if c == r.a {
+		return &r.ab
+	}
+	return &r.ba
+}
+
+// inbound returns the pipe that c is the writer of.
+func (r *relay) inbound(c *conn) *relayPipe {
+	if c == r.a {
+		return &r.ba
+	}
+	return &r.ab
+}
+
+func (r *relay) attach(itf interface{}) error {
+	c := itf.(*conn)
+	if !c.opened || atomic.LoadInt32(&r.stopped) == 1 {
+		r.leave(c, nil)
+		return nil
+	}
+
+	c.relay = r
+	// Forward the data that has been read but not consumed yet ahead of the data in the socket,
+	// the reading is held until the data has been put into the outbound buffer of the peer.
+	if n := c.inboundBuffer.Buffered(); n > 0 {
+		data := make([]byte, n)
+		_, _ = c.inboundBuffer.Read(data)
+		p := r.outbound(c)
+		atomic.StoreInt32(&p.held, 1)
+		err := p.dst.loop.poller.Trigger(queue.HighPriority, func(_ interface{}) (err error) {
+			switch {
+			case p.dst.relay == r:
+				_, _ = p.dst.bufferOutbound(data)
+				err = r.drain(p.dst)
+			case p.dst.opened:
+				_, err = p.dst.write(data)
+			}
+			atomic.StoreInt32(&p.held, 0)
+			r.resume(p)
+			return
+		}, nil)
+		if err != nil {
+			return r.detach(c, err, nil)
+		}
+	}
+	// Switch to edge-triggered mode regardless of the mode of event-loop, then we don't
+	// have to modify the events when the reading is paused and resumed.
+	if err := c.loop.poller.ModReadWrite(&c.pollAttachment, true); err != nil {
+		return r.detach(c, err, nil)
+	}
+	if err := r.drain(c); err != nil || c.relay != r {
+		return err
+	}
+	return r.pump(c)
+}
+
+func (r *relay) process(c *conn, ev netpoll.IOEvent) error {
+	if ev&(unix.EPOLLOUT|unix.EPOLLERR) != 0 {
+		if err := r.drain(c); err != nil || c.relay != r {
+			return err
+		}
+	}
+	// Exceptional events are reported by splice(2) when reading from the socket.
+	if ev&(unix.EPOLLIN|netpoll.ErrEvents) != 0 {
+		return r.pump(c)
+	}
+	return nil
+}
+
+// pump moves the data from c into its outbound pipe, it must be called on the event-loop of c.
+func (r *relay) pump(c *conn) error {
+	p := r.outbound(c)
+	if atomic.LoadInt32(&p.held) == 1 {
+		return nil
+	}
+	for atomic.LoadInt32(&p.eof) == 0 {
+		buffered := atomic.LoadInt64(&p.buffered)
+		space := p.size - buffered
+		if space <= 0 {
+			if p.pause(func() bool { return atomic.LoadInt64(&p.buffered) < p.size }) {
+				return nil
+			}
+			continue
+		}
+		n, err := unix.Splice(c.fd, nil, p.wfd, nil, int(space), spliceFlags)
+		if n > 0 {
+			atomic.AddInt64(&p.buffered, int64(n))
+			r.scheduleDrain(p)
+		}
+		switch {
+		case err == unix.EINTR:
+		case err == unix.EAGAIN:
+			// The pipe might have run out of its slots before reaching its capacity if it
+			// wasn't empty, let the writer resume the reading after it has drained the pipe.
+			if buffered == 0 || p.pause(func() bool { return atomic.LoadInt64(&p.buffered) == 0 }) {
+				return nil
+			}
+		case err != nil:
+			return r.detach(c, os.NewSyscallError("splice", err), nil)
+		case n == 0:
+			atomic.StoreInt32(&p.eof, 1)
+			r.scheduleDrain(p)
+		}
+	}
+	return nil
+}
+
+// pause marks the reading as paused and reports whether it stays paused,
+// resume is checked after that in case that the writer has missed the mark.
+func (p *relayPipe) pause(resume func() bool) bool {
+	atomic.StoreInt32(&p.paused, 1)
+	return !resume() || !atomic.CompareAndSwapInt32(&p.paused, 1, 0)
+}
+
+func (r *relay) scheduleDrain(p *relayPipe) {
+	if atomic.CompareAndSwapInt32(&p.draining, 0, 1) {
+		_ = p.dst.loop.poller.Trigger(queue.HighPriority, func(_ interface{}) error {
+			atomic.StoreInt32(&p.draining, 0)
+			if p.dst.relay != r {
+				return nil
+			}
+			return r.drain(p.dst)
+		}, nil)
+	}
+}
+
+// resume resumes the reading from the source of p on its event-loop.
+func (r *relay) resume(p *relayPipe) {
+	_ = p.src.loop.poller.Trigger(queue.HighPriority, func(_ interface{}) error {
+		if p.src.relay != r {
+			return nil
+		}
+		return r.pump(p.src)
+	}, nil)
+}
+
+// drain moves the data from the inbound pipe of c into c after the pending data
+// in outbound buffer has been sent, it must be called on the event-loop of c.
+func (r *relay) drain(c *conn) error {
+	for !c.outboundBuffer.IsEmpty() {
+		iov, _ := c.outboundBuffer.Peek(-1)
+		if len(iov) > iovMax {
+			iov = iov[:iovMax]
+		}
+		n, err := gio.Writev(c.fd, iov)
+		if n > 0 {
+			_, _ = c.outboundBuffer.Discard(n)
+		}
+		switch err {
+		case nil:
+		case unix.EAGAIN:
+			return nil
+		default:
+			return r.detach(c, os.NewSyscallError("writev", err), nil)
+		}
+	}
+
+	p := r.inbound(c)
+	for {
+		// Load eof ahead of buffered, the last bytes are always added before reaching EOF.
+		eof := atomic.LoadInt32(&p.eof) == 1
+		buffered := atomic.LoadInt64(&p.buffered)
+		if buffered == 0 {
+			if eof {
+				// All data from the peer has been delivered, close the peer and hand c back.
+				return r.detach(c, nil, io.EOF)
+			}
+			return nil
+		}
+		n, err := unix.Splice(p.rfd, nil, c.fd, nil, int(buffered), spliceFlags)
+		if n > 0 {
+			atomic.AddInt64(&p.buffered, -int64(n))
+			if atomic.CompareAndSwapInt32(&p.paused, 1, 0) {
+				r.resume(p)
+			}
+		}
+		switch err {
+		case nil, unix.EINTR:
+		case unix.EAGAIN:
+			return nil
+		default:
+			return r.detach(c, os.NewSyscallError("splice", err), nil)
+		}
+	}
+}
+
+// detach removes c from the relay, c is closed with err if it's not nil, otherwise c is
+// handed back to the EventHandler, the peer is going to be detached with peerErr if it's
+// still relayed. It must be called on the event-loop of c.
+func (r *relay) detach(c *conn, err, peerErr error) error {
+	if c.relay != r {
+		return nil
+	}
+	r.leave(c, peerErr)
+	if err != nil {
+		return c.loop.close(c, err)
+	}
+
+	// Renew the events even in edge-triggered mode, so that the data arrived
+	// during the relay will be notified again.
+	if c.loop.engine.opts.EdgeTriggeredIO {
+		return c.loop.poller.ModReadWrite(&c.pollAttachment, true)
+	}
+	if c.outboundPending() {
+		return c.loop.poller.ModReadWrite(&c.pollAttachment, false)
+	}
+	return c.loop.poller.ModRead(&c.pollAttachment, false)
+}
+
+// leave removes c from the relay and stops the relay, the pipes are closed
+// after both connections have left. It must be called on the event-loop of c.
+func (r *relay) leave(c *conn, peerErr error) {
+	c.relay = nil
+	if atomic.CompareAndSwapInt32(&r.stopped, 0, 1) {
+		peer := r.peer(c)
+		_ = peer.loop.poller.Trigger(queue.HighPriority, func(_ interface{}) error {
+			return r.detach(peer, peerErr, nil)
+		}, nil)
+	}
+	r.release()
+}
+
+func (r *relay) release() {
+	if atomic.AddInt32(&r.refs, -1) == 0 {
+		r.close()
+	}
+}
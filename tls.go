@@ -1,57 +1,115 @@
 package gnet
 
 import (
-	"bytes"
 	"errors"
 	"io"
 	"net"
+	"os"
 	"runtime/debug"
+	"sync/atomic"
 	"time"
 
+	"github.com/panjf2000/gnet/v2/pkg/buffer/ring"
+	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
 	bbPool "github.com/panjf2000/gnet/v2/pkg/pool/bytebuffer"
 	"github.com/panjf2000/gnet/v2/pkg/tls"
 )
 
 type tlsConn struct {
-	raw           Conn
-	rawTLSConn    *tls.Conn
-	inboundBuffer *bytes.Buffer
+	raw        Conn
+	rawTLSConn *tls.Conn
+	// inboundBuffer holds the plaintext decrypted from the records, it's owned by the
+	// connection rather than borrowed from a pool, so that the slices returned by Peek
+	// and Next stay valid until the next OnTraffic.
+	inboundBuffer *ring.Buffer
 	ctx           interface{}
+	// handshakePending indicates that a step of the handshake is running on the goroutine
+	// pool, the handshake must be resumed even if no more data has arrived.
+	handshakePending bool
+	// handshakeTimer closes the connection if the handshake doesn't complete in time.
+	handshakeTimer *time.Timer
+	// handshakeFailed indicates that the failure of the handshake has been reported.
+	handshakeFailed bool
+	// handler is the EventHandler that TLSRouter has routed the connection to.
+	handler EventHandler
+	// closeNotified indicates that the peer has closed the connection with close_notify.
+	closeNotified bool
+	// started indicates that the connection has been upgraded by Conn.StartTLS,
+	// whose OnOpen has fired before TLS was started.
+	started bool
+	// kernel indicates that the record layer has been offloaded to the kernel (kTLS),
+	// the plaintext is read from and written to the raw connection since then, it's
+	// atomic since SendFile is concurrency-safe.
+	kernel atomic.Bool
+	// kernelTried indicates that it has tried to offload the record layer to the kernel.
+	kernelTried bool
+	// kernelCloseNotified indicates that close_notify has been sent through kTLS.
+	kernelCloseNotified bool
 }
 
 func (c *tlsConn) Read(p []byte) (n int, err error) {
+	if c.inboundBuffer.IsEmpty() {
+		if len(p) > 0 {
+			err = io.ErrShortBuffer
+		}
+		return
+	}
 	return c.inboundBuffer.Read(p)
 }
 
 func (c *tlsConn) WriteTo(w io.Writer) (n int64, err error) {
+	if c.inboundBuffer.IsEmpty() {
+		return
+	}
 	return c.inboundBuffer.WriteTo(w)
 }
 
 func (c *tlsConn) Next(n int) (buf []byte, err error) {
-	if n < 0 || n > c.inboundBuffer.Len() {
-		n = c.inboundBuffer.Len()
+	if buf, err = c.Peek(n); err != nil {
+		return
 	}
-	return c.inboundBuffer.Next(n), nil
+	_, _ = c.inboundBuffer.Discard(len(buf))
+	return
 }
 
 func (c *tlsConn) Peek(n int) (buf []byte, err error) {
-	if c.inboundBuffer.Len() < n {
+	inBufferLen := c.inboundBuffer.Buffered()
+	if n > inBufferLen {
 		return nil, io.ErrShortBuffer
+	} else if n <= 0 {
+		n = inBufferLen
+	}
+	head, tail := c.inboundBuffer.Peek(n)
+	if len(head) == n {
+		return head, nil
 	}
-	return c.inboundBuffer.Bytes()[:n], nil
+	cache := &c.raw.(*conn).loop.cache
+	cache.Reset()
+	cache.Write(head)
+	cache.Write(tail)
+	return cache.Bytes(), nil
 }
 
 func (c *tlsConn) Discard(n int) (discarded int, err error) {
-	r, err := io.CopyN(io.Discard, c.inboundBuffer, int64(n))
-	return int(r), err
+	inBufferLen := c.inboundBuffer.Buffered()
+	if n <= 0 || n > inBufferLen {
+		n = inBufferLen
+	}
+	if n == 0 {
+		return
+	}
+	return c.inboundBuffer.Discard(n)
 }
 
 func (c *tlsConn) InboundBuffered() (n int) {
-	return c.inboundBuffer.Len()
+	return c.inboundBuffer.Buffered()
 }
 
 func (c *tlsConn) Write(p []byte) (n int, err error) {
+	if c.kernel.Load() {
+		return c.raw.Write(p)
+	}
 	return c.rawTLSConn.Write(p)
 }
 
@@ -59,7 +117,18 @@ func (c *tlsConn) ReadFrom(r io.Reader) (n int64, err error) {
 	return c.inboundBuffer.ReadFrom(r)
 }
 
+func (c *tlsConn) WriteWithFDs(_ []byte, _ []int) (n int, err error) {
+	return 0, errorx.ErrUnsupportedOp
+}
+
+func (c *tlsConn) ReadFDs() (fds []int) {
+	return c.raw.ReadFDs()
+}
+
 func (c *tlsConn) Writev(bs [][]byte) (n int, err error) {
+	if c.kernel.Load() {
+		return c.raw.Writev(bs)
+	}
 	bb := bbPool.Get()
 	defer bbPool.Put(bb)
 	for i := range bs {
@@ -76,14 +145,47 @@ func (c *tlsConn) OutboundBuffered() (n int) {
 	return c.raw.OutboundBuffered()
 }
 
-func (c *tlsConn) AsyncWrite(buf []byte, callback AsyncCallback) (err error) {
-	_, err = c.Write(buf)
-	return callback(c, err)
+// AsyncWrite encrypts and writes the data in the event-loop, as the tls.Conn and the
+// buffers of the raw connection are only used by the event-loop.
+func (c *tlsConn) AsyncWrite(buf []byte, callback AsyncCallback) error {
+	return c.raw.(*conn).runInLoop(func(err error) error {
+		if err == nil {
+			_, err = c.Write(buf)
+		}
+		if callback != nil {
+			_ = callback(c, err)
+		}
+		return err
+	})
 }
 
-func (c *tlsConn) AsyncWritev(bs [][]byte, callback AsyncCallback) (err error) {
-	_, err = c.Writev(bs)
-	return callback(c, err)
+func (c *tlsConn) AsyncWritev(bs [][]byte, callback AsyncCallback) error {
+	return c.raw.(*conn).runInLoop(func(err error) error {
+		if err == nil {
+			_, err = c.Writev(bs)
+		}
+		if callback != nil {
+			_ = callback(c, err)
+		}
+		return err
+	})
+}
+
+// SendFile works only after the record layer has been offloaded to the kernel (kTLS),
+// see Options.KernelTLS, it returns errors.ErrUnsupportedOp otherwise.
+func (c *tlsConn) SendFile(f *os.File, offset, n int64, callback AsyncCallback) error {
+	if !c.kernel.Load() {
+		return errorx.ErrUnsupportedOp
+	}
+	return c.raw.SendFile(f, offset, n, c.wrapCallback(callback))
+}
+
+func (c *tlsConn) AsyncWriteZeroCopy(_ []byte, _ AsyncCallback) error {
+	return errorx.ErrUnsupportedOp
+}
+
+func (c *tlsConn) Go(fn func() []byte) error {
+	return c.raw.(*conn).offload(fn, c.Write)
 }
 
 func (c *tlsConn) Fd() int {
@@ -114,6 +216,10 @@ func (c *tlsConn) SetNoDelay(noDelay bool) error {
 	return c.raw.SetNoDelay(noDelay)
 }
 
+func (c *tlsConn) PeerCred() (pid, uid, gid int, err error) {
+	return c.raw.PeerCred()
+}
+
 func (c *tlsConn) Context() (ctx interface{}) {
 	return c.ctx
 }
@@ -131,15 +237,56 @@ func (c *tlsConn) RemoteAddr() (addr net.Addr) {
 }
 
 func (c *tlsConn) Wake(callback AsyncCallback) (err error) {
-	return c.raw.Wake(callback)
+	return c.raw.Wake(c.wrapCallback(callback))
 }
 
+// CloseWithCallback sends close_notify to the peer if the handshake has completed, and then
+// closes the connection after flushing the pending data, the callback is invoked with c.
 func (c *tlsConn) CloseWithCallback(callback AsyncCallback) (err error) {
-	return c.raw.CloseWithCallback(callback)
+	gc := c.raw.(*conn)
+	return gc.runInLoop(func(err error) error {
+		if err == nil {
+			c.closeNotify()
+		}
+		err = gc.loop.close(gc, nil)
+		if callback != nil {
+			_ = callback(c, err)
+		}
+		return err
+	})
 }
 
+// wrapCallback makes the callback of the raw connection be invoked with c.
+func (c *tlsConn) wrapCallback(callback AsyncCallback) AsyncCallback {
+	if callback == nil {
+		return nil
+	}
+	return func(_ Conn, err error) error {
+		return callback(c, err)
+	}
+}
+
+// Close sends close_notify to the peer if the handshake has completed, and then
+// closes the connection after flushing the pending data.
 func (c *tlsConn) Close() (err error) {
-	return c.raw.Close()
+	return c.CloseWithCallback(nil)
+}
+
+// closeNotify sends close_notify to the peer, which tells the peer that no more data
+// is to be sent and the connection is not truncated, it must be called in the event-loop.
+func (c *tlsConn) closeNotify() {
+	if c.kernel.Load() {
+		c.kernelCloseNotify()
+		return
+	}
+	if c.rawTLSConn.HandshakeCompleted() {
+		_ = c.rawTLSConn.CloseWrite()
+	}
+}
+
+// StartTLS returns errors.ErrTLSStarted since c is served by TLS already.
+func (*tlsConn) StartTLS(_ *tls.Config) error {
+	return errorx.ErrTLSStarted
 }
 
 func (c *tlsConn) SetDeadline(t time.Time) (err error) {
@@ -151,32 +298,307 @@ func (c *tlsConn) SetReadDeadline(t time.Time) (err error) {
 }
 
 func (c *tlsConn) SetWriteDeadline(t time.Time) (err error) {
-	return c.SetWriteDeadline(t)
+	return c.raw.SetWriteDeadline(t)
+}
+
+// TLSConn is the Conn passed to the EventHandler that works with Options.TLSConfig,
+// it exposes the state of the TLS connection negotiated by the handshake.
+type TLSConn interface {
+	Conn
+
+	// ConnectionState returns the details of the TLS connection, such as the certificates of the peer,
+	// the negotiated application protocol (ALPN), the server name indicated by the client (SNI) and
+	// whether the session was resumed. Only ServerName is set before the handshake completes.
+	ConnectionState() tls.ConnectionState
+}
+
+func (c *tlsConn) ConnectionState() tls.ConnectionState {
+	// The state is being built by the handshake, which might be running on the goroutine pool.
+	if !c.rawTLSConn.HandshakeCompleted() {
+		return tls.ConnectionState{ServerName: c.rawTLSConn.ServerName()}
+	}
+	return c.rawTLSConn.ConnectionState()
+}
+
+// TLSConnectionState returns the state of the TLS connection of c, which is either the TLSConn passed
+// to the EventHandler or the raw connection beneath it, e.g. the one passed to OnClose, ok is false if
+// c doesn't work with TLS.
+func TLSConnectionState(c Conn) (state tls.ConnectionState, ok bool) {
+	if gc, isRaw := c.(*conn); isRaw {
+		c = gc.upgraded()
+	}
+	tc, ok := c.(TLSConn)
+	if !ok {
+		return
+	}
+	return tc.ConnectionState(), true
+}
+
+// TLSHandshakeFailure is the reason why a TLS handshake has failed.
+type TLSHandshakeFailure int
+
+const (
+	// HandshakeFailureTimeout means that the handshake didn't complete within Options.TLSHandshakeTimeout.
+	HandshakeFailureTimeout TLSHandshakeFailure = iota
+	// HandshakeFailureClosed means that the connection was closed before the handshake completed,
+	// the connections closed by the shutdown of the engine or the client aren't counted.
+	HandshakeFailureClosed
+	// HandshakeFailureBadRecord means that the peer sent something other than TLS records, e.g. plaintext HTTP.
+	HandshakeFailureBadRecord
+	// HandshakeFailureLocalAlert means that the handshake was aborted with an alert sent to the peer,
+	// e.g. when the peer doesn't support any of the configured versions or cipher suites.
+	HandshakeFailureLocalAlert
+	// HandshakeFailureRemoteAlert means that the handshake was aborted by an alert from the peer,
+	// e.g. when the peer doesn't trust the certificate.
+	HandshakeFailureRemoteAlert
+	// HandshakeFailureOther covers the rest of the failures.
+	HandshakeFailureOther
+
+	numHandshakeFailures
+)
+
+var handshakeFailureNames = [...]string{
+	HandshakeFailureTimeout:     "timeout",
+	HandshakeFailureClosed:      "closed",
+	HandshakeFailureBadRecord:   "bad record",
+	HandshakeFailureLocalAlert:  "local alert",
+	HandshakeFailureRemoteAlert: "remote alert",
+	HandshakeFailureOther:       "other",
+}
+
+func (f TLSHandshakeFailure) String() string {
+	if f < 0 || f >= numHandshakeFailures {
+		return "unknown"
+	}
+	return handshakeFailureNames[f]
+}
+
+// TLSHandshakeError describes a failed TLS handshake.
+type TLSHandshakeError struct {
+	// Reason is the reason why the handshake failed.
+	Reason TLSHandshakeFailure
+
+	// Alert is the TLS alert sent to or received from the peer,
+	// it's only valid when Reason is HandshakeFailureLocalAlert or HandshakeFailureRemoteAlert.
+	Alert tls.AlertError
+
+	// ServerName is the server name indicated by the client (SNI), it's empty
+	// if the ClientHello hasn't been received or doesn't indicate one.
+	ServerName string
+
+	// Err is the error that failed the handshake.
+	Err error
+}
+
+func (e *TLSHandshakeError) Error() string {
+	if e.ServerName == "" {
+		return "gnet: TLS handshake failed (" + e.Reason.String() + "): " + e.Err.Error()
+	}
+	return "gnet: TLS handshake with " + e.ServerName + " failed (" + e.Reason.String() + "): " + e.Err.Error()
+}
+
+func (e *TLSHandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// TLSHandshakeErrorHandler can be implemented by the EventHandler that works with Options.TLSConfig,
+// to be notified of the failed TLS handshakes, which helps with spotting scanners and misconfigured peers.
+type TLSHandshakeErrorHandler interface {
+	// OnHandshakeError fires when the TLS handshake of a connection has failed,
+	// the connection is going to be closed after it returns.
+	OnHandshakeError(c Conn, err *TLSHandshakeError)
 }
 
 type tlsEventHandler struct {
 	EventHandler
-	tlsConfig *tls.Config
+	tlsConfig         *tls.Config
+	isClient          bool                                // whether the handler serves the connections of Client
+	offloadHandshakes bool                                // whether the CPU-intensive steps of the server handshakes are offloaded
+	handshakeTimeout  time.Duration                       // the maximum duration of a handshake
+	kernelTLS         bool                                // whether the record layer is offloaded to the kernel
+	failures          [numHandshakeFailures]atomic.Uint64 // the number of the failed handshakes by reason
+}
+
+func newTLSEventHandler(eh EventHandler, opts *Options, isClient bool) *tlsEventHandler {
+	return &tlsEventHandler{
+		EventHandler:      eh,
+		tlsConfig:         opts.TLSConfig,
+		isClient:          isClient,
+		offloadHandshakes: !isClient && opts.TLSHandshakeWorkers > 0,
+		handshakeTimeout:  opts.TLSHandshakeTimeout,
+		kernelTLS:         opts.KernelTLS,
+	}
+}
+
+// handshakeFailures returns the number of the failed handshakes by reason.
+func (h *tlsEventHandler) handshakeFailures() map[TLSHandshakeFailure]uint64 {
+	failures := make(map[TLSHandshakeFailure]uint64, numHandshakeFailures)
+	for i := range h.failures {
+		failures[TLSHandshakeFailure(i)] = h.failures[i].Load()
+	}
+	return failures
+}
+
+// handshakeFailed counts the failed handshake of tc and reports it to OnHandshakeError.
+func (h *tlsEventHandler) handshakeFailed(c Conn, tc *tlsConn, reason TLSHandshakeFailure, err error) *TLSHandshakeError {
+	tc.handshakeFailed = true
+	tc.stopHandshakeTimer()
+	e := &TLSHandshakeError{Reason: reason, ServerName: tc.rawTLSConn.ServerName(), Err: err}
+	if reason == HandshakeFailureOther {
+		var rhe tls.RecordHeaderError
+		switch a, remote, ok := tls.ErrorAlert(err); {
+		case ok && remote:
+			e.Reason, e.Alert = HandshakeFailureRemoteAlert, a
+		case ok:
+			e.Reason, e.Alert = HandshakeFailureLocalAlert, a
+		case errors.As(err, &rhe):
+			e.Reason = HandshakeFailureBadRecord
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			e.Reason = HandshakeFailureClosed
+		}
+	}
+	h.failures[e.Reason].Add(1)
+	if eh, ok := h.EventHandler.(TLSHandshakeErrorHandler); ok {
+		eh.OnHandshakeError(c, e)
+	}
+	return e
+}
+
+func (c *tlsConn) stopHandshakeTimer() {
+	if c.handshakeTimer != nil {
+		c.handshakeTimer.Stop()
+		c.handshakeTimer = nil
+	}
 }
 
 func (h *tlsEventHandler) OnOpen(c Conn) (out []byte, action Action) {
-	// upgrade Conn to TLSConn
-	tc := tls.Server(c, h.tlsConfig)
-	c.SetContext(&tlsConn{
-		raw:           c,
-		rawTLSConn:    tc,
-		inboundBuffer: bytes.NewBuffer(make([]byte, 0, 512)),
-	})
+	if _, err := h.upgrade(c, h.tlsConfig); err != nil {
+		return nil, Close
+	}
 	// The code here does not need call OnOpen now; it can be deferred until the handshake complete
 	return
 }
 
+// upgrade upgrades c to the TLS connection with config, the client sends the ClientHello right away.
+func (h *tlsEventHandler) upgrade(c Conn, config *tls.Config) (*tlsConn, error) {
+	var tc *tls.Conn
+	if h.isClient {
+		tc = tls.Client(c, h.clientConfig(c, config))
+	} else {
+		tc = tls.Server(c, config)
+		if h.offloadHandshakes {
+			tc.SetHandshakeExecutor(func(step func()) {
+				c.(*conn).offloadHandshake(step, func() Action { return h.OnTraffic(c) })
+			})
+		}
+	}
+	tc.SetNonBlocking()
+	tlsc := &tlsConn{
+		raw:           c,
+		rawTLSConn:    tc,
+		inboundBuffer: ring.New(0),
+		ctx:           c.Context(),
+	}
+	c.SetContext(tlsc)
+	if h.handshakeTimeout > 0 {
+		gc := c.(*conn)
+		tlsc.handshakeTimer = time.AfterFunc(h.handshakeTimeout, func() {
+			_ = gc.runInLoop(func(err error) error {
+				if err != nil || tc.HandshakeCompleted() || tlsc.handshakeFailed {
+					return nil
+				}
+				h.handshakeFailed(c, tlsc, HandshakeFailureTimeout, errorx.ErrTLSHandshakeTimeout)
+				return gc.loop.close(gc, errorx.ErrTLSHandshakeTimeout)
+			})
+		})
+	}
+	// The client speaks first, send the ClientHello right away, the rest of the
+	// handshake is driven by OnTraffic as the messages from the server arrive.
+	if h.isClient {
+		if err := tc.Handshake(); err != nil {
+			e := h.handshakeFailed(c, tlsc, HandshakeFailureOther, err)
+			logging.Error(e)
+			return tlsc, e
+		}
+	}
+	return tlsc, nil
+}
+
+// startTLS upgrades the plaintext connection c to TLS with config in the middle of the stream,
+// see Conn.StartTLS. It's nil if the engine or the client works with Options.TLSConfig.
+func (h *tlsEventHandler) startTLS(c *conn, config *tls.Config) error {
+	if h == nil || c.tlsHandler != nil {
+		return errorx.ErrTLSStarted
+	}
+	if config == nil {
+		return errorx.ErrNilTLSConfig
+	}
+	tc, err := h.upgrade(c, config)
+	if err != nil {
+		c.ctx = tc.ctx
+		return err
+	}
+	tc.started = true
+	c.tlsHandler = h
+	// Resume the handshake with the bytes that have been buffered, e.g. the ClientHello
+	// sent right after the command to start TLS, after the current event has been handled.
+	return c.Wake(nil)
+}
+
+// handler returns the EventHandler of c, which serves TLS after c has started TLS by Conn.StartTLS.
+func (el *eventloop) handler(c *conn) EventHandler {
+	if c.tlsHandler != nil {
+		return c.tlsHandler
+	}
+	return el.eventHandler
+}
+
+// clientConfig returns the TLS config for the client connection, the server name
+// given on dialing overrides the one in the shared config, while the one in the
+// config passed to Conn.StartTLS is preferred over the one given on dialing.
+func (h *tlsEventHandler) clientConfig(c Conn, config *tls.Config) *tls.Config {
+	gc, ok := c.(*conn)
+	if !ok || gc.serverName == "" || gc.serverName == config.ServerName ||
+		(h.tlsConfig == nil && config.ServerName != "") {
+		return config
+	}
+	// The clone shares the ClientSessionCache with the original config,
+	// so the sessions can be resumed across connections.
+	cfg := config.Clone()
+	cfg.ServerName = gc.serverName
+	return cfg
+}
+
+func (h *tlsEventHandler) OnClose(c Conn, err error) (action Action) {
+	if tc, ok := c.Context().(*tlsConn); ok {
+		tc.stopHandshakeTimer()
+		// The connection is closed in the middle of the handshake, e.g. by a scanner,
+		// rather than by the shutdown of the engine or the client.
+		if !tc.rawTLSConn.HandshakeCompleted() && !tc.handshakeFailed && !c.(*conn).loop.closing {
+			cause := err
+			if cause == nil {
+				cause = io.EOF
+			}
+			h.handshakeFailed(c, tc, HandshakeFailureClosed, cause)
+		}
+		// The peer has closed the connection cleanly rather than truncated it.
+		if tc.closeNotified {
+			err = errorx.ErrTLSCloseNotify
+		}
+	}
+	return h.EventHandler.OnClose(c, err)
+}
+
 func (h *tlsEventHandler) OnTraffic(c Conn) (action Action) {
 	tc := c.Context().(*tlsConn)
+	established := false
 
 	// TLS handshake
 	if !tc.rawTLSConn.HandshakeCompleted() {
-		for tc.raw.InboundBuffered() > 0 {
+		for tc.raw.InboundBuffered() > 0 || tc.handshakePending {
+			buffered := tc.raw.InboundBuffered()
+			resumed := tc.handshakePending
+			tc.handshakePending = false
 			err := tc.rawTLSConn.Handshake()
 
 			// data not enough wait for next round
@@ -184,20 +606,42 @@ func (h *tlsEventHandler) OnTraffic(c Conn) (action Action) {
 				return None
 			}
 
+			// wait for the offloaded step of the handshake, which resumes the handshake after it's done
+			if errors.Is(err, tls.ErrHandshakePending) {
+				tc.handshakePending = true
+				return None
+			}
+
 			if err != nil {
-				logging.Error(err)
+				logging.Error(h.handshakeFailed(c, tc, HandshakeFailureOther, err))
 				return Close
 			}
 
 			if tc.rawTLSConn.HandshakeCompleted() {
+				tc.stopHandshakeTimer()
+				// OnOpen has fired on the plaintext connection that started TLS,
+				// fire OnTraffic instead to tell that the handshake has completed.
+				if tc.started {
+					established = true
+					break
+				}
 				// fire OnOpen when handshake completed
 				out, act := h.EventHandler.OnOpen(tc)
 				if act != None {
+					if act == Close {
+						tc.closeNotify()
+					}
 					return act
 				}
 				if _, err := tc.Write(out); err != nil {
 					return Close
 				}
+				break
+			}
+
+			// the record being read is incomplete, wait for the rest of it
+			if !resumed && tc.raw.InboundBuffered() == buffered {
+				return None
 			}
 		}
 	}
@@ -206,24 +650,59 @@ func (h *tlsEventHandler) OnTraffic(c Conn) (action Action) {
 		return None
 	}
 
-	bb := bbPool.Get()
-	defer bbPool.Put(bb)
-	n, err := bb.ReadFrom(tc.rawTLSConn)
-	if err != nil {
-		// close when the error is not ErrNotEnough or EOF
-		if !(errors.Is(err, io.EOF) || errors.Is(err, tls.ErrNotEnough)) {
+	// The records are decrypted in place in the inbound buffer of the raw connection,
+	// and the plaintext is written into the inbound buffer of the TLS connection.
+	// An EOF means that the peer has sent close_notify, the plaintext received before
+	// it is still delivered, and then the connection is closed with close_notify too.
+	// The raw connection has read the plaintext already with kTLS.
+	if tc.kernel.Load() {
+		_, _ = tc.raw.WriteTo(tc.inboundBuffer)
+	} else if _, err := tc.rawTLSConn.WriteTo(tc.inboundBuffer); err != nil {
+		if !errors.Is(err, io.EOF) {
 			logging.Errorf("tls conn OnTraffic err: %v, stack: %s", err, debug.Stack())
 			return Close
 		}
+		tc.closeNotified = true
 	}
 
-	if n > 0 {
-		tc.inboundBuffer.Write(bb.Bytes())
+	if !tc.inboundBuffer.IsEmpty() || established {
+		action = h.EventHandler.OnTraffic(tc)
+	}
+	if tc.closeNotified && action == None {
+		action = Close
+	}
+	if action == None && h.kernelTLS && !tc.kernelTried && !tc.offloadToKernel() {
+		action = Close
 	}
+	// Shutdown leaves the connections to be closed abruptly as before.
+	if action == Close {
+		tc.closeNotify()
+	}
+	return
+}
 
-	if tc.inboundBuffer.Len() > 0 {
-		return h.EventHandler.OnTraffic(tc)
+// tlsServerName returns the server name to verify the certificate of the server for the
+// connection dialed to address, the name given on dialing takes precedence, then the
+// ServerName in the TLS config, otherwise it's the host in address like tls.Dial does.
+// cfg is nil if the client has no TLS config, whose connections may start TLS by Conn.StartTLS.
+func tlsServerName(cfg *tls.Config, serverName, address string) string {
+	if serverName != "" {
+		return serverName
+	}
+	if cfg != nil && cfg.ServerName != "" {
+		return ""
 	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return ""
+	}
+	return host
+}
 
-	return None
+// upgraded returns the TLS connection on top of c if it has been upgraded, otherwise c itself.
+func (c *conn) upgraded() Conn {
+	if tc, ok := c.ctx.(*tlsConn); ok && tc.raw == Conn(c) {
+		return tc
+	}
+	return c
 }
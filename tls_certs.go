@@ -0,0 +1,241 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panjf2000/gnet/v2/pkg/tls"
+)
+
+// DefaultCertPollInterval is the default interval of checking the certificate files for changes in CertManager.
+const DefaultCertPollInterval = time.Minute
+
+// CertManagerOptions are the options for CertManager.
+type CertManagerOptions struct {
+	// PollInterval is the interval of checking the certificate and key files for changes,
+	// the default value is DefaultCertPollInterval.
+	PollInterval time.Duration
+
+	// OnReloadError is invoked with the files of the certificate that has failed to be reloaded,
+	// the previous certificate is kept in use until the files are changed and loaded successfully.
+	// Note that it's invoked on the goroutine that polls the files or calls Reload.
+	OnReloadError func(certFile, keyFile string, err error)
+}
+
+// CertManager is a store of certificates for TLS servers, which picks the certificate by the server name
+// indicated by the client (SNI) through GetCertificate, it's meant for tls.Config.GetCertificate.
+// The certificate and key files are polled for changes and reloaded without restarting the server,
+// the new certificates are swapped in atomically and serve the handshakes from then on, while the
+// established connections stay untouched.
+type CertManager struct {
+	opts  CertManagerOptions
+	mu    sync.Mutex // serializes the loading of the certificates
+	certs []*managedCert
+	store atomic.Pointer[certStore]
+	once  sync.Once
+	done  chan struct{}
+}
+
+// managedCert is a certificate loaded from the files.
+type managedCert struct {
+	certFile, keyFile string
+	names             []string // the names given to Add
+	cert              *tls.Certificate
+	stamp             certStamp // the stamp of the files that were loaded or failed to be loaded lastly
+}
+
+// certStamp tells whether the certificate and key files have been changed.
+type certStamp struct {
+	certMod, keyMod   time.Time
+	certSize, keySize int64
+}
+
+// certStore maps the server names to the certificates.
+type certStore struct {
+	exact    map[string]*tls.Certificate
+	wildcard map[string]*tls.Certificate // keyed by the parent domain, e.g. "example.com" for "*.example.com"
+	fallback *tls.Certificate
+}
+
+// NewCertManager creates a CertManager and starts polling the certificate files, it must be closed by Close.
+func NewCertManager(opts CertManagerOptions) *CertManager {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultCertPollInterval
+	}
+	m := &CertManager{opts: opts, done: make(chan struct{})}
+	m.store.Store(&certStore{})
+	go m.poll()
+	return m
+}
+
+// Add loads the certificate from the PEM encoded certFile and keyFile, which serves the given server
+// names, the names can be wildcards like "*.example.com" that match the names one level below
+// "example.com". The names default to the DNS names of the certificate, or the common name if it
+// has none, which are refreshed on reloading. The exact names take precedence over the wildcards,
+// and the certificate added later takes precedence over the earlier ones for the same name.
+// The first certificate serves the clients that indicate no server name or an unknown one.
+func (m *CertManager) Add(certFile, keyFile string, names ...string) error {
+	mc := &managedCert{certFile: certFile, keyFile: keyFile, names: names}
+	stamp, err := statCert(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	if mc.cert, err = loadCert(certFile, keyFile); err != nil {
+		return err
+	}
+	mc.stamp = stamp
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.certs = append(m.certs, mc)
+	m.swap()
+	return nil
+}
+
+// Reload checks the certificate files for changes and reloads the changed ones right away,
+// it returns the errors of the certificates that have failed to be reloaded.
+func (m *CertManager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var (
+		errs    []error
+		changed bool
+	)
+	for _, mc := range m.certs {
+		stamp, err := statCert(mc.certFile, mc.keyFile)
+		if err == nil && stamp == mc.stamp {
+			continue
+		}
+		if err == nil {
+			// Don't retry the files until they're changed again, e.g. the key file
+			// that hasn't been updated yet along with the certificate file.
+			mc.stamp = stamp
+			var cert *tls.Certificate
+			if cert, err = loadCert(mc.certFile, mc.keyFile); err == nil {
+				mc.cert = cert
+				changed = true
+				continue
+			}
+		}
+		errs = append(errs, err)
+		if m.opts.OnReloadError != nil {
+			m.opts.OnReloadError(mc.certFile, mc.keyFile, err)
+		}
+	}
+	if changed {
+		m.swap()
+	}
+	return errors.Join(errs...)
+}
+
+// GetCertificate returns the certificate for the server name in hello, it's meant for tls.Config.GetCertificate.
+func (m *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	store := m.store.Load()
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if cert, ok := store.exact[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := store.wildcard[name[i+1:]]; ok {
+			return cert, nil
+		}
+	}
+	if store.fallback == nil {
+		return nil, errors.New("gnet: no certificates in CertManager")
+	}
+	return store.fallback, nil
+}
+
+// Close stops polling the certificate files.
+func (m *CertManager) Close() {
+	m.once.Do(func() { close(m.done) })
+}
+
+func (m *CertManager) poll() {
+	ticker := time.NewTicker(m.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = m.Reload()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// swap builds the store of the current certificates and swaps it in, it must be called with m.mu held.
+func (m *CertManager) swap() {
+	store := &certStore{
+		exact:    make(map[string]*tls.Certificate),
+		wildcard: make(map[string]*tls.Certificate),
+	}
+	for _, mc := range m.certs {
+		if store.fallback == nil {
+			store.fallback = mc.cert
+		}
+		names := mc.names
+		if len(names) == 0 {
+			names = mc.cert.Leaf.DNSNames
+			if len(names) == 0 && mc.cert.Leaf.Subject.CommonName != "" {
+				names = []string{mc.cert.Leaf.Subject.CommonName}
+			}
+		}
+		for _, name := range names {
+			name = strings.TrimSuffix(strings.ToLower(name), ".")
+			if strings.HasPrefix(name, "*.") {
+				store.wildcard[name[2:]] = mc.cert
+			} else {
+				store.exact[name] = mc.cert
+			}
+		}
+	}
+	m.store.Store(store)
+}
+
+func statCert(certFile, keyFile string) (stamp certStamp, err error) {
+	certInfo, err := os.Stat(certFile)
+	if err != nil {
+		return
+	}
+	keyInfo, err := os.Stat(keyFile)
+	if err != nil {
+		return
+	}
+	return certStamp{
+		certMod:  certInfo.ModTime(),
+		keyMod:   keyInfo.ModTime(),
+		certSize: certInfo.Size(),
+		keySize:  keyInfo.Size(),
+	}, nil
+}
+
+func loadCert(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
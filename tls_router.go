@@ -0,0 +1,210 @@
+// Copyright (c) 2024 The Gnet Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gnet
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// TLSRouter is an EventHandler that serves several protocols or tenants behind one TLS listener,
+// it routes each connection to the EventHandler registered for the application protocol negotiated
+// by ALPN, or else the one registered for the server name indicated by the client (SNI), or else the
+// fallback one, after the handshake has completed. The events of the connection, i.e. OnOpen, OnTraffic
+// and OnClose, are dispatched to that EventHandler, while OnBoot, OnShutdown and OnTick are dispatched
+// to all of them. The connections without any EventHandler to route to are closed.
+//
+// TLSRouter must work with Options.TLSConfig, whose NextProtos should contain the protocols registered
+// by HandleProtocol, see Protocols. The EventHandlers must be registered before the engine is started.
+type TLSRouter struct {
+	protocols   map[string]EventHandler
+	protoOrder  []string
+	serverNames map[string]EventHandler
+	fallback    EventHandler
+	handlers    []EventHandler // the distinct EventHandlers in the order of registration
+	ticks       []time.Time    // the next time to call OnTick of the handlers
+}
+
+// NewTLSRouter creates a TLSRouter, fallback serves the connections that match none of
+// the registered protocols and server names, it can be nil.
+func NewTLSRouter(fallback EventHandler) *TLSRouter {
+	r := &TLSRouter{
+		protocols:   make(map[string]EventHandler),
+		serverNames: make(map[string]EventHandler),
+		fallback:    fallback,
+	}
+	if fallback != nil {
+		r.register(fallback)
+	}
+	return r
+}
+
+// HandleProtocol routes the connections that have negotiated the application protocol proto by ALPN to eh,
+// e.g. "h2" or "http/1.1".
+func (r *TLSRouter) HandleProtocol(proto string, eh EventHandler) *TLSRouter {
+	if _, ok := r.protocols[proto]; !ok {
+		r.protoOrder = append(r.protoOrder, proto)
+	}
+	r.protocols[proto] = eh
+	r.register(eh)
+	return r
+}
+
+// HandleServerName routes the connections whose clients indicated the server name to eh.
+// The name can be a wildcard like "*.example.com" that matches the names one level below
+// "example.com", the exact names take precedence over the wildcards.
+func (r *TLSRouter) HandleServerName(name string, eh EventHandler) *TLSRouter {
+	r.serverNames[strings.ToLower(name)] = eh
+	r.register(eh)
+	return r
+}
+
+// Protocols returns the application protocols registered by HandleProtocol in the order of registration,
+// which is meant for tls.Config.NextProtos.
+func (r *TLSRouter) Protocols() []string {
+	return append([]string(nil), r.protoOrder...)
+}
+
+func (r *TLSRouter) register(eh EventHandler) {
+	for _, h := range r.handlers {
+		if sameEventHandler(h, eh) {
+			return
+		}
+	}
+	r.handlers = append(r.handlers, eh)
+	r.ticks = append(r.ticks, time.Time{})
+}
+
+func sameEventHandler(a, b EventHandler) bool {
+	if ta, tb := reflect.TypeOf(a), reflect.TypeOf(b); ta != tb || !ta.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// route returns the EventHandler for the connection with the negotiated protocol and the server name.
+func (r *TLSRouter) route(proto, serverName string) EventHandler {
+	if eh, ok := r.protocols[proto]; ok && proto != "" {
+		return eh
+	}
+	if serverName != "" {
+		serverName = strings.ToLower(serverName)
+		if eh, ok := r.serverNames[serverName]; ok {
+			return eh
+		}
+		if i := strings.IndexByte(serverName, '.'); i > 0 {
+			if eh, ok := r.serverNames["*"+serverName[i:]]; ok {
+				return eh
+			}
+		}
+	}
+	return r.fallback
+}
+
+// routed returns the EventHandler that the connection has been routed to, if any.
+func routed(c Conn) EventHandler {
+	if gc, ok := c.(*conn); ok {
+		c = gc.upgraded()
+	}
+	if tc, ok := c.(*tlsConn); ok {
+		return tc.handler
+	}
+	return nil
+}
+
+// OnBoot fires OnBoot of all EventHandlers, the engine is shut down if any of them returns Shutdown.
+func (r *TLSRouter) OnBoot(eng Engine) (action Action) {
+	for _, eh := range r.handlers {
+		if eh.OnBoot(eng) == Shutdown {
+			action = Shutdown
+		}
+	}
+	return
+}
+
+// OnShutdown fires OnShutdown of all EventHandlers.
+func (r *TLSRouter) OnShutdown(eng Engine) {
+	for _, eh := range r.handlers {
+		eh.OnShutdown(eng)
+	}
+}
+
+// OnOpen routes the connection after the TLS handshake and fires OnOpen of its EventHandler.
+func (r *TLSRouter) OnOpen(c Conn) (out []byte, action Action) {
+	tc, ok := c.(*tlsConn)
+	if !ok {
+		return nil, Close
+	}
+	state := tc.ConnectionState()
+	eh := r.route(state.NegotiatedProtocol, state.ServerName)
+	if eh == nil {
+		return nil, Close
+	}
+	tc.handler = eh
+	return eh.OnOpen(c)
+}
+
+// OnClose fires OnClose of the EventHandler of the connection, the connections that
+// haven't been routed, e.g. the ones whose handshakes failed, are not reported.
+func (r *TLSRouter) OnClose(c Conn, err error) (action Action) {
+	if eh := routed(c); eh != nil {
+		return eh.OnClose(c, err)
+	}
+	return
+}
+
+// OnTraffic fires OnTraffic of the EventHandler of the connection.
+func (r *TLSRouter) OnTraffic(c Conn) (action Action) {
+	if eh := routed(c); eh != nil {
+		return eh.OnTraffic(c)
+	}
+	return Close
+}
+
+// OnTick fires OnTick of the EventHandlers whose delays have elapsed, and returns the delay
+// until the next one of them, the engine is shut down if any of them returns Shutdown.
+func (r *TLSRouter) OnTick() (delay time.Duration, action Action) {
+	now := time.Now()
+	var next time.Time
+	for i, eh := range r.handlers {
+		if now.Before(r.ticks[i]) {
+			if next.IsZero() || r.ticks[i].Before(next) {
+				next = r.ticks[i]
+			}
+			continue
+		}
+		d, act := eh.OnTick()
+		if act == Shutdown {
+			action = Shutdown
+		}
+		r.ticks[i] = now.Add(d)
+		if next.IsZero() || r.ticks[i].Before(next) {
+			next = r.ticks[i]
+		}
+	}
+	if !next.IsZero() {
+		delay = next.Sub(now)
+	}
+	return
+}
+
+// OnHandshakeError fires OnHandshakeError of the EventHandler for the server name of the failed
+// handshake, or else the fallback one, if the EventHandler implements TLSHandshakeErrorHandler.
+func (r *TLSRouter) OnHandshakeError(c Conn, err *TLSHandshakeError) {
+	if eh, ok := r.route("", err.ServerName).(TLSHandshakeErrorHandler); ok {
+		eh.OnHandshakeError(c, err)
+	}
+}
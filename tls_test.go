@@ -2,16 +2,33 @@ package gnet
 
 import (
 	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
 	tls2 "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
 	"io"
+	"math/big"
 	"math/rand"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	errorx "github.com/panjf2000/gnet/v2/pkg/errors"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
 	goPool "github.com/panjf2000/gnet/v2/pkg/pool/goroutine"
 	"github.com/panjf2000/gnet/v2/pkg/tls"
@@ -144,6 +161,14 @@ func getGoClientTLSConfig() *tls2.Config {
 	return &tls2.Config{Certificates: []tls2.Certificate{crt}, InsecureSkipVerify: true}
 }
 
+func TestTLSConnDeadlines(t *testing.T) {
+	// The deadlines are delegated to the raw connection, which doesn't support them.
+	c := &tlsConn{raw: &conn{}}
+	require.ErrorIs(t, c.SetDeadline(time.Now()), errorx.ErrUnsupportedOp)
+	require.ErrorIs(t, c.SetReadDeadline(time.Now()), errorx.ErrUnsupportedOp)
+	require.ErrorIs(t, c.SetWriteDeadline(time.Now()), errorx.ErrUnsupportedOp)
+}
+
 type testTLSServer struct {
 	*testServer
 }
@@ -535,3 +560,1446 @@ func startTLSClient(t *testing.T, network, addr string, multicore, async bool) {
 		}
 	}
 }
+
+type testTLSClient struct {
+	*BuiltinEventEngine
+	tester  *testing.T
+	msg     []byte
+	opened  chan *tls.ConnectionState
+	echoed  chan []byte
+	closed  chan error
+	dialCtx interface{}
+}
+
+func newTestTLSClient(t *testing.T) *testTLSClient {
+	return &testTLSClient{
+		tester: t,
+		msg:    []byte("hello, gnet over tls"),
+		opened: make(chan *tls.ConnectionState, 8),
+		echoed: make(chan []byte, 8),
+		closed: make(chan error, 8),
+	}
+}
+
+func (cli *testTLSClient) OnOpen(c Conn) (out []byte, action Action) {
+	tc, ok := c.(*tlsConn)
+	require.True(cli.tester, ok, "want *tlsConn")
+	require.True(cli.tester, tc.rawTLSConn.HandshakeCompleted())
+	require.Equal(cli.tester, cli.dialCtx, c.Context())
+	state := tc.rawTLSConn.ConnectionState()
+	cli.opened <- &state
+	return cli.msg, None
+}
+
+func (cli *testTLSClient) OnTraffic(c Conn) (action Action) {
+	if c.InboundBuffered() < len(cli.msg) {
+		return
+	}
+	buf, err := c.Next(len(cli.msg))
+	require.NoError(cli.tester, err)
+	cli.echoed <- append([]byte(nil), buf...)
+	return
+}
+
+func (cli *testTLSClient) OnClose(_ Conn, err error) (action Action) {
+	cli.closed <- err
+	return
+}
+
+// startGoTLSEchoServer starts an echo server of crypto/tls, which sends the server
+// names indicated by the clients to the returned channel.
+func startGoTLSEchoServer(t *testing.T, config *tls2.Config) (net.Listener, chan string) {
+	cert, err := tls2.X509KeyPair([]byte(serverCRT), []byte(serverKey))
+	require.NoError(t, err)
+	config.Certificates = []tls2.Certificate{cert}
+	serverNames := make(chan string, 8)
+	config.GetConfigForClient = func(hello *tls2.ClientHelloInfo) (*tls2.Config, error) {
+		serverNames <- hello.ServerName
+		return nil, nil
+	}
+	ln, err := tls2.Listen("tcp", "127.0.0.1:0", config)
+	require.NoError(t, err)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln, serverNames
+}
+
+// startFragmentingProxy starts a proxy that forwards the data to the backend and back
+// one byte at a time, which breaks the TLS records apart.
+func startFragmentingProxy(t *testing.T, backend string) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	forward := func(dst, src net.Conn) {
+		defer dst.Close()
+		var b [1]byte
+		for {
+			if _, err := src.Read(b[:]); err != nil {
+				return
+			}
+			if _, err := dst.Write(b[:]); err != nil {
+				return
+			}
+		}
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			bc, err := net.Dial("tcp", backend)
+			if err != nil {
+				_ = c.Close()
+				continue
+			}
+			_ = c.(*net.TCPConn).SetNoDelay(true)
+			_ = bc.(*net.TCPConn).SetNoDelay(true)
+			go forward(bc, c)
+			go forward(c, bc)
+		}
+	}()
+	return ln
+}
+
+func TestTLSClient(t *testing.T) {
+	versions := map[string]uint16{"tls1.2": tls.VersionTLS12, "tls1.3": tls.VersionTLS13}
+	for name, version := range versions {
+		t.Run(name, func(t *testing.T) {
+			t.Run("resumption", func(t *testing.T) {
+				testTLSClientResumption(t, version, false)
+			})
+			t.Run("fragmented", func(t *testing.T) {
+				testTLSClientResumption(t, version, true)
+			})
+			t.Run("client-auth", func(t *testing.T) {
+				testTLSClientAuth(t, version)
+			})
+		})
+	}
+	t.Run("server-name", testTLSClientServerName)
+	t.Run("handshake-failure", testTLSClientHandshakeFailure)
+	t.Run("gnet-server", testTLSClientWithGnetServer)
+}
+
+func testTLSClientResumption(t *testing.T, version uint16, fragmented bool) {
+	ln, _ := startGoTLSEchoServer(t, &tls2.Config{MinVersion: version, MaxVersion: version})
+	defer ln.Close()
+	addr := ln.Addr().String()
+	if fragmented {
+		proxy := startFragmentingProxy(t, addr)
+		defer proxy.Close()
+		addr = proxy.Addr().String()
+	}
+
+	config := getClientTLSConfig()
+	config.MinVersion, config.MaxVersion = version, version
+	config.ClientSessionCache = tls.NewLRUClientSessionCache(8)
+	// The cached sessions are dropped once the certificate of the server has expired.
+	config.Time = func() time.Time { return time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC) }
+	handler := newTestTLSClient(t)
+	handler.dialCtx = "tls-client"
+	client, err := NewClient(handler, WithTLSConfig(config))
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+	defer client.Stop() //nolint:errcheck
+
+	for i := 0; i < 2; i++ {
+		c, err := client.DialContext("tcp", addr, handler.dialCtx)
+		require.NoError(t, err)
+		require.IsType(t, (*tlsConn)(nil), c)
+
+		state := <-handler.opened
+		require.EqualValues(t, version, state.Version)
+		require.Equalf(t, i > 0, state.DidResume, "the session should be resumed by the second connection")
+		require.Equal(t, handler.msg, <-handler.echoed)
+		require.NoError(t, c.Close())
+		<-handler.closed
+	}
+}
+
+func testTLSClientAuth(t *testing.T, version uint16) {
+	// The server requests the client certificate, and it prefers the curve that the
+	// client doesn't send the key share for, which makes a HelloRetryRequest in TLS 1.3.
+	ln, _ := startGoTLSEchoServer(t, &tls2.Config{
+		MinVersion:       version,
+		MaxVersion:       version,
+		ClientAuth:       tls2.RequireAnyClientCert,
+		CurvePreferences: []tls2.CurveID{tls2.CurveP384},
+	})
+	defer ln.Close()
+	proxy := startFragmentingProxy(t, ln.Addr().String())
+	defer proxy.Close()
+
+	handler := newTestTLSClient(t)
+	client, err := NewClient(handler, WithTLSConfig(getClientTLSConfig()))
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+	defer client.Stop() //nolint:errcheck
+
+	_, err = client.Dial("tcp", proxy.Addr().String())
+	require.NoError(t, err)
+	state := <-handler.opened
+	require.EqualValues(t, version, state.Version)
+	require.Equal(t, handler.msg, <-handler.echoed)
+}
+
+func testTLSClientServerName(t *testing.T) {
+	ln, serverNames := startGoTLSEchoServer(t, &tls2.Config{})
+	defer ln.Close()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	handler := newTestTLSClient(t)
+	client, err := NewClient(handler, WithTLSConfig(getClientTLSConfig()))
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+	defer client.Stop() //nolint:errcheck
+
+	// The host in the address is indicated to the server.
+	_, err = client.Dial("tcp", net.JoinHostPort("localhost", port))
+	require.NoError(t, err)
+	require.Equal(t, "localhost", <-serverNames)
+	<-handler.opened
+	require.Equal(t, handler.msg, <-handler.echoed)
+
+	// The server name given on dialing overrides it.
+	dialed := make(chan Conn, 1)
+	err = client.DialWithContext(context.Background(), "tcp", net.JoinHostPort("localhost", port), &DialOptions{
+		ServerName: "gnet.test",
+		Callback: func(c Conn, err error) error {
+			require.NoError(t, err)
+			dialed <- c
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "gnet.test", <-serverNames)
+	require.IsType(t, (*tlsConn)(nil), <-dialed)
+	state := <-handler.opened
+	require.Equal(t, "gnet.test", state.ServerName)
+	require.Equal(t, handler.msg, <-handler.echoed)
+}
+
+func testTLSClientHandshakeFailure(t *testing.T) {
+	ln, _ := startGoTLSEchoServer(t, &tls2.Config{MaxVersion: tls2.VersionTLS12})
+	defer ln.Close()
+
+	config := getClientTLSConfig()
+	config.MinVersion = tls.VersionTLS13
+	handler := newTestTLSClient(t)
+	client, err := NewClient(handler, WithTLSConfig(config))
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+	defer client.Stop() //nolint:errcheck
+
+	_, err = client.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	select {
+	case <-handler.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection should be closed after the handshake failed")
+	}
+	require.Empty(t, handler.opened, "OnOpen should not fire without a successful handshake")
+}
+
+type testTLSEchoServer struct {
+	*BuiltinEventEngine
+	eng   chan Engine
+	ready chan struct{}
+}
+
+func (s *testTLSEchoServer) OnBoot(eng Engine) (action Action) {
+	s.eng <- eng
+	return
+}
+
+func (s *testTLSEchoServer) OnTick() (time.Duration, Action) {
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+	return time.Hour, None
+}
+
+func (s *testTLSEchoServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(buf)
+	return
+}
+
+func testTLSClientWithGnetServer(t *testing.T) {
+	server := &testTLSEchoServer{eng: make(chan Engine, 1), ready: make(chan struct{})}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9969", WithTicker(true), WithTLSConfig(getServerConfig()))
+	}()
+	eng := <-server.eng
+	<-server.ready
+
+	handler := newTestTLSClient(t)
+	client, err := NewClient(handler, WithTLSConfig(getClientTLSConfig()), WithNumEventLoop(2))
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+
+	for i := 0; i < 4; i++ {
+		_, err = client.Dial("tcp", "127.0.0.1:9969")
+		require.NoError(t, err)
+	}
+	for i := 0; i < 4; i++ {
+		<-handler.opened
+		require.Equal(t, handler.msg, <-handler.echoed)
+	}
+	require.NoError(t, client.Stop())
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+}
+
+type testTLSAsyncServer struct {
+	*BuiltinEventEngine
+	tester *testing.T
+	eng    chan Engine
+	ready  chan struct{}
+	lines  int
+}
+
+func (s *testTLSAsyncServer) OnBoot(eng Engine) (action Action) {
+	s.eng <- eng
+	return
+}
+
+func (s *testTLSAsyncServer) OnTick() (time.Duration, Action) {
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+	return time.Hour, None
+}
+
+func (s *testTLSAsyncServer) OnOpen(c Conn) (out []byte, action Action) {
+	// Push the data from another goroutine while the event-loop is reading from the connection.
+	go func() {
+		for i := 0; i < s.lines; i++ {
+			var err error
+			if i%2 == 0 {
+				err = c.AsyncWrite([]byte("line-"+strconv.Itoa(i)+"\n"), nil)
+			} else {
+				err = c.AsyncWritev([][]byte{[]byte("line-"), []byte(strconv.Itoa(i) + "\n")}, nil)
+			}
+			assert.NoError(s.tester, err)
+		}
+		woken := make(chan Conn, 1)
+		assert.NoError(s.tester, c.Wake(func(c Conn, err error) error {
+			assert.NoError(s.tester, err)
+			woken <- c
+			return nil
+		}))
+		assert.IsType(s.tester, (*tlsConn)(nil), <-woken)
+		assert.NoError(s.tester, c.CloseWithCallback(func(c Conn, err error) error {
+			assert.NoError(s.tester, err)
+			assert.IsType(s.tester, (*tlsConn)(nil), c)
+			return nil
+		}))
+	}()
+	return
+}
+
+func (s *testTLSAsyncServer) OnTraffic(c Conn) (action Action) {
+	_, _ = c.Discard(-1)
+	return
+}
+
+func TestTLSAsyncWrite(t *testing.T) {
+	server := &testTLSAsyncServer{tester: t, eng: make(chan Engine, 1), ready: make(chan struct{}), lines: 1000}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9968", WithTicker(true), WithTLSConfig(getServerConfig()))
+	}()
+	eng := <-server.eng
+	<-server.ready
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := tls2.Dial("tcp", "127.0.0.1:9968", getGoClientTLSConfig())
+			require.NoError(t, err)
+			defer c.Close()
+			go func() {
+				data := make([]byte, 512)
+				for {
+					if _, err := c.Write(data); err != nil {
+						return
+					}
+				}
+			}()
+			rd := bufio.NewReader(c)
+			for i := 0; i < server.lines; i++ {
+				line, err := rd.ReadString('\n')
+				require.NoError(t, err)
+				require.Equal(t, "line-"+strconv.Itoa(i)+"\n", line)
+			}
+			// The connection is closed after all data, it may be reset as the client keeps writing.
+			_, err = rd.ReadByte()
+			require.Error(t, err)
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+}
+
+type testTLSFrameServer struct {
+	*BuiltinEventEngine
+	tester *testing.T
+	eng    chan Engine
+	ready  chan struct{}
+}
+
+func (s *testTLSFrameServer) OnBoot(eng Engine) (action Action) {
+	s.eng <- eng
+	return
+}
+
+func (s *testTLSFrameServer) OnTick() (time.Duration, Action) {
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+	return time.Hour, None
+}
+
+// OnTraffic decodes the length-prefixed frames that span multiple TLS records
+// and echoes them back.
+func (s *testTLSFrameServer) OnTraffic(c Conn) (action Action) {
+	for {
+		hdr, err := c.Peek(4)
+		if err != nil {
+			assert.ErrorIs(s.tester, err, io.ErrShortBuffer)
+			break
+		}
+		n := int(binary.BigEndian.Uint32(hdr))
+		if c.InboundBuffered() < 4+n {
+			_, err = c.Peek(4 + n)
+			assert.ErrorIs(s.tester, err, io.ErrShortBuffer)
+			break
+		}
+		frame, err := c.Peek(4 + n)
+		assert.NoError(s.tester, err)
+		assert.Len(s.tester, frame, 4+n)
+		discarded, err := c.Discard(4)
+		assert.NoError(s.tester, err)
+		assert.Equal(s.tester, 4, discarded)
+		frame, err = c.Next(n)
+		assert.NoError(s.tester, err)
+		assert.Len(s.tester, frame, n)
+		_, err = c.Write(frame)
+		assert.NoError(s.tester, err)
+	}
+	buffered := c.InboundBuffered()
+	n, err := c.Read(make([]byte, 0))
+	assert.NoError(s.tester, err)
+	assert.Zero(s.tester, n)
+	assert.Equal(s.tester, buffered, c.InboundBuffered())
+	return
+}
+
+func TestTLSInboundFrames(t *testing.T) {
+	server := &testTLSFrameServer{tester: t, eng: make(chan Engine, 1), ready: make(chan struct{})}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9967", WithTicker(true), WithTLSConfig(getServerConfig()))
+	}()
+	eng := <-server.eng
+	<-server.ready
+
+	c, err := tls2.Dial("tcp", "127.0.0.1:9967", getGoClientTLSConfig())
+	require.NoError(t, err)
+	defer c.Close()
+
+	const frames = 200
+	sent := make(chan []byte, frames)
+	go func() {
+		for i := 0; i < frames; i++ {
+			// Frames are up to 64KiB, larger than the maximum size of a TLS record.
+			frame := make([]byte, 4+1+rand.Intn(64*1024))
+			binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+			_, _ = rand.Read(frame[4:])
+			sent <- frame[4:]
+			// Split the frame at a random offset so that it straddles the records.
+			at := rand.Intn(len(frame))
+			if _, err := c.Write(frame[:at]); err != nil {
+				return
+			}
+			if _, err := c.Write(frame[at:]); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < frames; i++ {
+		frame := <-sent
+		echo := make([]byte, len(frame))
+		_, err = io.ReadFull(c, echo)
+		require.NoError(t, err)
+		require.Equal(t, frame, echo)
+	}
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+}
+
+type testTLSHandshakeServer struct {
+	*BuiltinEventEngine
+	eng   chan Engine
+	ready chan struct{}
+}
+
+func (s *testTLSHandshakeServer) OnBoot(eng Engine) (action Action) {
+	s.eng <- eng
+	return
+}
+
+func (s *testTLSHandshakeServer) OnTick() (time.Duration, Action) {
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+	return time.Hour, None
+}
+
+func (s *testTLSHandshakeServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(buf)
+	return
+}
+
+func TestTLSOffloadedHandshakes(t *testing.T) {
+	const perLoop = 2
+	var running, maxRunning int32
+	cert, err := tls.X509KeyPair([]byte(serverCRT), []byte(serverKey))
+	require.NoError(t, err)
+	config := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			// Simulate a slow certificate lookup.
+			time.Sleep(20 * time.Millisecond)
+			return &cert, nil
+		},
+		ClientAuth: tls.RequireAnyClientCert,
+		// The certificates of the sessions are checked on resumption, which must be valid.
+		Time: func() time.Time { return time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC) },
+		// Clients send no key share of P-384, which forces a HelloRetryRequest in TLS 1.3.
+		CurvePreferences: []tls.CurveID{tls.CurveP384},
+	}
+	server := &testTLSHandshakeServer{eng: make(chan Engine, 1), ready: make(chan struct{})}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9966",
+			WithNumEventLoop(1),
+			WithTicker(true),
+			WithTLSConfig(config),
+			WithTLSHandshakeWorkers(8),
+			WithTLSHandshakesPerLoop(perLoop))
+	}()
+	eng := <-server.eng
+	<-server.ready
+
+	echo := func(c net.Conn, data string) {
+		_, err := c.Write([]byte(data))
+		require.NoError(t, err)
+		buf := make([]byte, len(data))
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t, err)
+		require.Equal(t, data, string(buf))
+	}
+
+	established, err := tls2.Dial("tcp", "127.0.0.1:9966", getGoClientTLSConfig())
+	require.NoError(t, err)
+	defer established.Close()
+	echo(established, "ping")
+
+	// Keep the established connection busy during the handshake storm.
+	stop := make(chan struct{})
+	var maxLatency time.Duration
+	pinged := make(chan struct{})
+	go func() {
+		defer close(pinged)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			start := time.Now()
+			echo(established, "ping")
+			if d := time.Since(start); d > maxLatency {
+				maxLatency = d
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			config := getGoClientTLSConfig()
+			if i%2 == 0 {
+				config.MaxVersion = tls2.VersionTLS12
+			} else {
+				config.MinVersion = tls2.VersionTLS13
+			}
+			c, err := tls2.Dial("tcp", "127.0.0.1:9966", config)
+			require.NoError(t, err)
+			defer c.Close()
+			echo(c, "hello-"+strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-pinged
+
+	// 64 handshakes take more than a second in total, the established connection
+	// must not wait for them.
+	assert.Less(t, maxLatency, 500*time.Millisecond)
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(perLoop))
+
+	// The resumed handshake of TLS 1.2 skips the offloaded steps of the full handshake.
+	config12 := getGoClientTLSConfig()
+	config12.MaxVersion = tls2.VersionTLS12
+	config12.ClientSessionCache = tls2.NewLRUClientSessionCache(1)
+	// Sessions are not resumed after the certificate of the server has expired.
+	config12.Time = func() time.Time { return time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC) }
+	for i := 0; i < 2; i++ {
+		c, err := tls2.Dial("tcp", "127.0.0.1:9966", config12)
+		require.NoError(t, err)
+		echo(c, "resume")
+		require.Equal(t, i == 1, c.ConnectionState().DidResume)
+		_ = c.Close()
+	}
+
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+}
+
+type testTLSHandshakeErrorServer struct {
+	*testTLSHandshakeServer
+	failures chan *TLSHandshakeError
+}
+
+func (s *testTLSHandshakeErrorServer) OnHandshakeError(_ Conn, err *TLSHandshakeError) {
+	s.failures <- err
+}
+
+func TestTLSHandshakeFailures(t *testing.T) {
+	// The timeout leaves room for the handshakes that fail otherwise, which are slow with -race.
+	const timeout = time.Second
+	server := &testTLSHandshakeErrorServer{
+		testTLSHandshakeServer: &testTLSHandshakeServer{eng: make(chan Engine, 1), ready: make(chan struct{})},
+		failures:               make(chan *TLSHandshakeError, 1),
+	}
+	config := getServerConfig()
+	config.NextProtos = []string{"h2"}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9964",
+			WithTicker(true),
+			WithTLSConfig(config),
+			WithTLSHandshakeTimeout(timeout))
+	}()
+	eng := <-server.eng
+	<-server.ready
+
+	// The established connections are not affected by the timeout.
+	c, err := tls2.Dial("tcp", "127.0.0.1:9964", getGoClientTLSConfig())
+	require.NoError(t, err)
+	defer c.Close()
+	time.Sleep(2 * timeout)
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	// waitClosed waits for the server to close the raw connection.
+	waitClosed := func(c net.Conn) {
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := io.Copy(io.Discard, c)
+		require.NoError(t, err)
+		_ = c.Close()
+	}
+
+	// The peer sends nothing after connecting.
+	start := time.Now()
+	raw, err := net.Dial("tcp", "127.0.0.1:9964")
+	require.NoError(t, err)
+	waitClosed(raw)
+	require.GreaterOrEqual(t, time.Since(start), timeout)
+	failure := <-server.failures
+	require.Equal(t, HandshakeFailureTimeout, failure.Reason)
+	require.ErrorIs(t, failure, errorx.ErrTLSHandshakeTimeout)
+
+	// The peer speaks plaintext HTTP.
+	raw, err = net.Dial("tcp", "127.0.0.1:9964")
+	require.NoError(t, err)
+	_, err = raw.Write([]byte("GET / HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n"))
+	require.NoError(t, err)
+	waitClosed(raw)
+	failure = <-server.failures
+	require.Equal(t, HandshakeFailureBadRecord, failure.Reason)
+
+	// The peer hangs up in the middle of the ClientHello.
+	raw, err = net.Dial("tcp", "127.0.0.1:9964")
+	require.NoError(t, err)
+	_, err = raw.Write([]byte{22, 3, 1, 2, 0, 1})
+	require.NoError(t, err)
+	_ = raw.Close()
+	failure = <-server.failures
+	require.Equal(t, HandshakeFailureClosed, failure.Reason)
+
+	// The server rejects the application protocols of the peer.
+	clientConfig := getGoClientTLSConfig()
+	clientConfig.NextProtos = []string{"gnet"}
+	_, err = tls2.Dial("tcp", "127.0.0.1:9964", clientConfig)
+	require.Error(t, err)
+	failure = <-server.failures
+	require.Equal(t, HandshakeFailureLocalAlert, failure.Reason)
+	require.Equal(t, tls.AlertError(120), failure.Alert) // no_application_protocol
+
+	// The peer doesn't trust the certificate of the server.
+	clientConfig = getGoClientTLSConfig()
+	clientConfig.InsecureSkipVerify = false
+	clientConfig.ServerName = "gnet.example"
+	_, err = tls2.Dial("tcp", "127.0.0.1:9964", clientConfig)
+	require.Error(t, err)
+	failure = <-server.failures
+	require.Equal(t, HandshakeFailureRemoteAlert, failure.Reason)
+	require.Equal(t, tls.AlertError(42), failure.Alert) // bad_certificate
+	require.Equal(t, "gnet.example", failure.ServerName)
+
+	failures, err := eng.TLSHandshakeFailures()
+	require.NoError(t, err)
+	require.Equal(t, map[TLSHandshakeFailure]uint64{
+		HandshakeFailureTimeout:     1,
+		HandshakeFailureClosed:      1,
+		HandshakeFailureBadRecord:   1,
+		HandshakeFailureLocalAlert:  1,
+		HandshakeFailureRemoteAlert: 1,
+		HandshakeFailureOther:       0,
+	}, failures)
+
+	// The handshakes in flight at the shutdown are not counted as failed.
+	raw, err = net.Dial("tcp", "127.0.0.1:9964")
+	require.NoError(t, err)
+	defer raw.Close()
+	_, err = raw.Write([]byte{22, 3, 1, 2, 0, 1})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return eng.CountConnections() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+	require.Empty(t, server.failures)
+}
+
+type testTLSStateServer struct {
+	*testTLSHandshakeServer
+	opened chan tls.ConnectionState
+	closed chan tls.ConnectionState
+}
+
+func (s *testTLSStateServer) OnOpen(c Conn) (out []byte, action Action) {
+	tc, ok := c.(TLSConn)
+	if !ok {
+		return nil, Close
+	}
+	s.opened <- tc.ConnectionState()
+	return
+}
+
+func (s *testTLSStateServer) OnClose(c Conn, _ error) (action Action) {
+	state, ok := TLSConnectionState(c)
+	if ok {
+		s.closed <- state
+	}
+	return
+}
+
+func TestTLSConnectionState(t *testing.T) {
+	server := &testTLSStateServer{
+		testTLSHandshakeServer: &testTLSHandshakeServer{eng: make(chan Engine, 1), ready: make(chan struct{})},
+		opened:                 make(chan tls.ConnectionState, 1),
+		closed:                 make(chan tls.ConnectionState, 1),
+	}
+	// The certificates of the sessions are checked on resumption, which must be valid.
+	now := func() time.Time { return time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC) }
+	config := getServerConfig()
+	config.ClientAuth = tls.RequireAnyClientCert
+	config.NextProtos = []string{"h2", "http/1.1"}
+	config.Time = now
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9963", WithTicker(true), WithTLSConfig(config))
+	}()
+	eng := <-server.eng
+	<-server.ready
+
+	clientConfig := getGoClientTLSConfig()
+	clientConfig.ServerName = "gnet.example"
+	clientConfig.NextProtos = []string{"h2"}
+	clientConfig.ClientSessionCache = tls2.NewLRUClientSessionCache(1)
+	clientConfig.Time = now
+	for i := 0; i < 2; i++ {
+		c, err := tls2.Dial("tcp", "127.0.0.1:9963", clientConfig)
+		require.NoError(t, err)
+		// The session ticket of TLS 1.3 arrives after the handshake.
+		_, err = c.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t, err)
+
+		state := <-server.opened
+		require.True(t, state.HandshakeComplete)
+		require.EqualValues(t, tls2.VersionTLS13, state.Version)
+		require.Equal(t, "h2", state.NegotiatedProtocol)
+		require.Equal(t, "gnet.example", state.ServerName)
+		require.Equal(t, i == 1, state.DidResume)
+		require.Len(t, state.PeerCertificates, 1)
+		require.Equal(t, []string{"Default Company Ltd"}, state.PeerCertificates[0].Subject.Organization)
+
+		require.NoError(t, c.Close())
+		state = <-server.closed
+		require.Equal(t, "gnet.example", state.ServerName)
+		require.Equal(t, "h2", state.NegotiatedProtocol)
+	}
+
+	// The connections without TLS have no TLS state.
+	_, ok := TLSConnectionState(&conn{})
+	require.False(t, ok)
+
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+}
+
+type testTLSRoutedServer struct {
+	*BuiltinEventEngine
+	name   string
+	booted int32
+	eng    chan Engine
+	closed chan string
+}
+
+func (s *testTLSRoutedServer) OnBoot(eng Engine) (action Action) {
+	atomic.AddInt32(&s.booted, 1)
+	s.eng <- eng
+	return
+}
+
+func (s *testTLSRoutedServer) OnOpen(_ Conn) (out []byte, action Action) {
+	return []byte(s.name + ":"), None
+}
+
+func (s *testTLSRoutedServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	_, _ = c.Write(buf)
+	return
+}
+
+func (s *testTLSRoutedServer) OnClose(_ Conn, _ error) (action Action) {
+	s.closed <- s.name
+	return
+}
+
+func TestTLSRouter(t *testing.T) {
+	engines, closed := make(chan Engine, 3), make(chan string, 1)
+	web := &testTLSRoutedServer{name: "web", eng: engines, closed: closed}
+	tenant := &testTLSRoutedServer{name: "tenant", eng: engines, closed: closed}
+	fallback := &testTLSRoutedServer{name: "fallback", eng: engines, closed: closed}
+	router := NewTLSRouter(fallback).
+		HandleProtocol("h2", web).
+		HandleProtocol("http/1.1", web).
+		HandleServerName("*.tenant.example", tenant)
+	require.Equal(t, []string{"h2", "http/1.1"}, router.Protocols())
+
+	config := getServerConfig()
+	config.NextProtos = router.Protocols()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(router, "tcp://127.0.0.1:9962", WithTLSConfig(config))
+	}()
+	eng := <-engines
+	<-engines
+	<-engines
+	// OnBoot of the EventHandler registered for several protocols fires once.
+	require.EqualValues(t, 1, atomic.LoadInt32(&fallback.booted))
+	require.EqualValues(t, 1, atomic.LoadInt32(&web.booted))
+	require.EqualValues(t, 1, atomic.LoadInt32(&tenant.booted))
+
+	for _, tc := range []struct {
+		serverName string
+		protos     []string
+		want       string
+	}{
+		{"a.tenant.example", []string{"h2"}, "web"}, // ALPN takes precedence over SNI
+		{"a.tenant.example", nil, "tenant"},
+		{"A.Tenant.Example", nil, "tenant"},
+		{"b.a.tenant.example", nil, "fallback"},
+		{"gnet.example", []string{"http/1.1"}, "web"},
+		{"gnet.example", nil, "fallback"},
+	} {
+		clientConfig := getGoClientTLSConfig()
+		clientConfig.ServerName = tc.serverName
+		clientConfig.NextProtos = tc.protos
+		c, err := tls2.Dial("tcp", "127.0.0.1:9962", clientConfig)
+		require.NoError(t, err)
+		greeting := make([]byte, len(tc.want)+1)
+		_, err = io.ReadFull(c, greeting)
+		require.NoError(t, err)
+		require.Equal(t, tc.want+":", string(greeting))
+		_, err = c.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf))
+		require.NoError(t, c.Close())
+		require.Equal(t, tc.want, <-closed)
+	}
+
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+}
+
+// writeTestCert writes a self-signed certificate with the serial number for the DNS names into dir.
+func writeTestCert(t *testing.T, dir, name string, serial int64, dnsNames ...string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return
+}
+
+func TestTLSCertManager(t *testing.T) {
+	dir := t.TempDir()
+	defaultCert, defaultKey := writeTestCert(t, dir, "default", 1, "gnet.example")
+	tenantCert, tenantKey := writeTestCert(t, dir, "tenant", 2, "*.tenant.example", "tenant.example")
+	reloadErrs := make(chan error, 8)
+	m := NewCertManager(CertManagerOptions{
+		PollInterval: 50 * time.Millisecond,
+		OnReloadError: func(_, _ string, err error) {
+			reloadErrs <- err
+		},
+	})
+	defer m.Close()
+	require.NoError(t, m.Add(defaultCert, defaultKey))
+	require.NoError(t, m.Add(tenantCert, tenantKey))
+	require.Error(t, m.Add(filepath.Join(dir, "missing.crt"), defaultKey))
+
+	server := &testTLSHandshakeServer{eng: make(chan Engine, 1), ready: make(chan struct{})}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9961",
+			WithTicker(true),
+			WithTLSConfig(&tls.Config{GetCertificate: m.GetCertificate}))
+	}()
+	eng := <-server.eng
+	<-server.ready
+
+	dial := func(serverName string) (*tls2.Conn, int64) {
+		config := getGoClientTLSConfig()
+		config.ServerName = serverName
+		c, err := tls2.Dial("tcp", "127.0.0.1:9961", config)
+		require.NoError(t, err)
+		return c, c.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	serial := func(serverName string) int64 {
+		c, serial := dial(serverName)
+		require.NoError(t, c.Close())
+		return serial
+	}
+	require.EqualValues(t, 1, serial("gnet.example"))
+	require.EqualValues(t, 2, serial("tenant.example"))
+	require.EqualValues(t, 2, serial("a.Tenant.Example"))
+	require.EqualValues(t, 1, serial("b.a.tenant.example")) // wildcards match one level
+	require.EqualValues(t, 1, serial("unknown.example"))
+
+	established, _ := dial("a.tenant.example")
+	defer established.Close()
+
+	// Rotate the certificate of the tenant.
+	writeTestCert(t, dir, "tenant", 3, "*.tenant.example", "tenant.example")
+	require.Eventually(t, func() bool { return serial("a.tenant.example") == 3 }, 5*time.Second, 20*time.Millisecond)
+
+	// The established connection is not affected.
+	_, err := established.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(established, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	// The rotation may have been polled between the writes of the certificate and the key,
+	// drop the transient errors of it before the key is broken.
+	for len(reloadErrs) > 0 {
+		<-reloadErrs
+	}
+
+	// A broken certificate is reported and the previous one is kept.
+	require.NoError(t, os.WriteFile(tenantKey, []byte("broken"), 0o600))
+	select {
+	case err := <-reloadErrs:
+		require.ErrorContains(t, err, "failed to find any PEM data in key input")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload error")
+	}
+	require.EqualValues(t, 3, serial("a.tenant.example"))
+
+	// The certificate is reloaded after it has been fixed.
+	writeTestCert(t, dir, "tenant", 4, "*.tenant.example")
+	require.NoError(t, m.Reload())
+	require.EqualValues(t, 4, serial("a.tenant.example"))
+	require.EqualValues(t, 1, serial("tenant.example")) // the names follow the certificate
+
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+}
+
+type testTLSCloseServer struct {
+	*testTLSHandshakeServer
+	closed chan error
+}
+
+func (s *testTLSCloseServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	switch string(buf) {
+	case "close":
+		return Close
+	case "conn.Close":
+		_ = c.Close()
+	case "shutdown":
+		return Shutdown
+	default:
+		_, _ = c.Write(buf)
+	}
+	return
+}
+
+func (s *testTLSCloseServer) OnClose(_ Conn, err error) (action Action) {
+	s.closed <- err
+	return
+}
+
+// countingConn counts the bytes read from the connection.
+type countingConn struct {
+	net.Conn
+	n int
+}
+
+func (c *countingConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	c.n += n
+	return
+}
+
+func TestTLSCloseNotify(t *testing.T) {
+	server := &testTLSCloseServer{
+		testTLSHandshakeServer: &testTLSHandshakeServer{eng: make(chan Engine, 1), ready: make(chan struct{})},
+		closed:                 make(chan error, 1),
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9960", WithTicker(true), WithTLSConfig(getServerConfig()))
+	}()
+	<-server.eng
+	<-server.ready
+
+	dial := func() (*tls2.Conn, *countingConn) {
+		raw, err := net.Dial("tcp", "127.0.0.1:9960")
+		require.NoError(t, err)
+		cc := &countingConn{Conn: raw}
+		c := tls2.Client(cc, getGoClientTLSConfig())
+		_, err = c.Write([]byte("ping"))
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf))
+		return c, cc
+	}
+	// closedByServer tells whether the server has sent close_notify before closing the connection.
+	closedByServer := func(c *tls2.Conn, cc *countingConn, cmd string) bool {
+		defer c.Close()
+		n := cc.n
+		_, err := c.Write([]byte(cmd))
+		require.NoError(t, err)
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = c.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+		return cc.n > n
+	}
+
+	// The Close action and Conn.Close send close_notify.
+	for _, cmd := range []string{"close", "conn.Close"} {
+		c, cc := dial()
+		require.True(t, closedByServer(c, cc, cmd), cmd)
+		require.NoError(t, <-server.closed, cmd)
+	}
+
+	// The peer closes the connection with close_notify, and the server replies with close_notify.
+	c, cc := dial()
+	n := cc.n
+	require.NoError(t, c.CloseWrite())
+	require.ErrorIs(t, <-server.closed, errorx.ErrTLSCloseNotify)
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Greater(t, cc.n, n)
+	_ = c.Close()
+
+	// The peer hangs up without close_notify.
+	_, cc = dial()
+	_ = cc.Conn.Close()
+	err = <-server.closed
+	require.Error(t, err)
+	require.NotErrorIs(t, err, errorx.ErrTLSCloseNotify)
+
+	// The Shutdown action closes the connections abruptly.
+	c, cc = dial()
+	require.False(t, closedByServer(c, cc, "shutdown"))
+	require.NoError(t, <-errCh)
+}
+
+type testStartTLSServer struct {
+	*testTLSHandshakeServer
+	started     chan error
+	established chan tls.ConnectionState
+}
+
+func (s *testStartTLSServer) OnTraffic(c Conn) (action Action) {
+	if tc, ok := c.(TLSConn); ok {
+		// OnTraffic fires once the handshake has completed, along with the data if any.
+		if c.Context() == nil {
+			c.SetContext(true)
+			s.established <- tc.ConnectionState()
+		}
+		buf, _ := c.Next(-1)
+		_, _ = c.Write(buf)
+		return
+	}
+	// The commands in plaintext end with '\n'.
+	for {
+		buf, _ := c.Peek(-1)
+		i := strings.IndexByte(string(buf), '\n')
+		if i < 0 {
+			return
+		}
+		cmd := string(buf[:i+1])
+		_, _ = c.Discard(i + 1)
+		if cmd != "STARTTLS\n" {
+			_, _ = c.Write([]byte(cmd))
+			continue
+		}
+		_, _ = c.Write([]byte("OK\n"))
+		s.started <- c.StartTLS(getServerConfig())
+		s.started <- c.StartTLS(getServerConfig())
+		return
+	}
+}
+
+// pipeliningConn sends the command along with the first write, and skips the reply of it.
+type pipeliningConn struct {
+	net.Conn
+	cmd   []byte
+	reply int
+}
+
+func (c *pipeliningConn) Write(p []byte) (int, error) {
+	if c.cmd != nil {
+		_, err := c.Conn.Write(append(c.cmd, p...))
+		c.cmd = nil
+		return len(p), err
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *pipeliningConn) Read(p []byte) (int, error) {
+	if c.reply > 0 {
+		if _, err := io.ReadFull(c.Conn, make([]byte, c.reply)); err != nil {
+			return 0, err
+		}
+		c.reply = 0
+	}
+	return c.Conn.Read(p)
+}
+
+type testStartTLSClient struct {
+	*BuiltinEventEngine
+	serverName string // ServerName of the config passed to StartTLS
+	started    chan error
+	echoed     chan string
+}
+
+func (cli *testStartTLSClient) OnOpen(_ Conn) (out []byte, action Action) {
+	return []byte("STARTTLS\n"), None
+}
+
+func (cli *testStartTLSClient) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	if _, ok := c.(TLSConn); !ok {
+		config := getClientTLSConfig()
+		config.ServerName = cli.serverName
+		cli.started <- c.StartTLS(config)
+		return
+	}
+	if len(buf) == 0 {
+		_, _ = c.Write([]byte("ping"))
+		return
+	}
+	cli.echoed <- string(buf)
+	return
+}
+
+func TestTLSStartTLS(t *testing.T) {
+	server := &testStartTLSServer{
+		testTLSHandshakeServer: &testTLSHandshakeServer{eng: make(chan Engine, 1), ready: make(chan struct{})},
+		started:                make(chan error, 2),
+		established:            make(chan tls.ConnectionState, 1),
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9959", WithTicker(true))
+	}()
+	eng := <-server.eng
+	<-server.ready
+
+	echo := func(c net.Conn, msg string) {
+		_, err := c.Write([]byte(msg))
+		require.NoError(t, err)
+		buf := make([]byte, len(msg))
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t, err)
+		require.Equal(t, msg, string(buf))
+	}
+	checkStarted := func() {
+		require.NoError(t, <-server.started)
+		require.ErrorIs(t, <-server.started, errorx.ErrTLSStarted)
+	}
+	clientConfig := getGoClientTLSConfig()
+	clientConfig.ServerName = "gnet.example"
+
+	// The client waits for the reply to the command before the handshake.
+	raw, err := net.Dial("tcp", "127.0.0.1:9959")
+	require.NoError(t, err)
+	echo(raw, "hello\n")
+	_, err = raw.Write([]byte("STARTTLS\n"))
+	require.NoError(t, err)
+	reply := make([]byte, 3)
+	_, err = io.ReadFull(raw, reply)
+	require.NoError(t, err)
+	require.Equal(t, "OK\n", string(reply))
+	checkStarted()
+	c := tls2.Client(raw, clientConfig)
+	echo(c, "ping")
+	state := <-server.established
+	require.True(t, state.HandshakeComplete)
+	require.Equal(t, "gnet.example", state.ServerName)
+	_ = c.Close()
+
+	// The ClientHello is sent right after the command, which is buffered along with the command.
+	raw, err = net.Dial("tcp", "127.0.0.1:9959")
+	require.NoError(t, err)
+	c = tls2.Client(&pipeliningConn{Conn: raw, cmd: []byte("STARTTLS\n"), reply: len("OK\n")}, clientConfig)
+	echo(c, "ping")
+	checkStarted()
+	require.True(t, (<-server.established).HandshakeComplete)
+	_ = c.Close()
+
+	// The client side of Client starts TLS.
+	handler := &testStartTLSClient{started: make(chan error, 1), echoed: make(chan string, 1)}
+	client, err := NewClient(handler)
+	require.NoError(t, err)
+	require.NoError(t, client.Start())
+	defer client.Stop() //nolint:errcheck
+	_, err = client.Dial("tcp", "127.0.0.1:9959")
+	require.NoError(t, err)
+	checkStarted()
+	require.NoError(t, <-handler.started)
+	<-server.established
+	require.Equal(t, "ping", <-handler.echoed)
+
+	// The server name in the config passed to StartTLS is preferred over the one given on dialing,
+	// which is preferred over the host of the dialed address.
+	for _, tc := range []struct {
+		address, dialName, configName, want string
+	}{
+		{"localhost:9959", "", "", "localhost"},
+		{"localhost:9959", "gnet.dial", "", "gnet.dial"},
+		{"localhost:9959", "gnet.dial", "gnet.config", "gnet.config"},
+	} {
+		handler.serverName = tc.configName
+		err = client.DialWithContext(context.Background(), "tcp4", tc.address, &DialOptions{ServerName: tc.dialName})
+		require.NoError(t, err)
+		checkStarted()
+		require.NoError(t, <-handler.started)
+		require.Equal(t, tc.want, (<-server.established).ServerName)
+		require.Equal(t, "ping", <-handler.echoed)
+	}
+
+	failures, err := eng.TLSHandshakeFailures()
+	require.NoError(t, err)
+	for reason, n := range failures {
+		require.Zero(t, n, reason.String())
+	}
+
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+}
+
+type testKernelTLSServer struct {
+	*testTLSCloseServer
+	file *os.File
+}
+
+func (s *testKernelTLSServer) OnTraffic(c Conn) (action Action) {
+	buf, _ := c.Next(-1)
+	switch string(buf) {
+	case "state":
+		if c.(*tlsConn).kernel.Load() {
+			_, _ = c.Write([]byte("kernel"))
+		} else {
+			_, _ = c.Write([]byte("user  "))
+		}
+	case "file":
+		// SendFile works only if the record layer has been offloaded to the kernel.
+		if err := c.SendFile(s.file, 0, 0, nil); errors.Is(err, errorx.ErrUnsupportedOp) {
+			buf, _ = os.ReadFile(s.file.Name())
+			_, _ = c.Write(buf)
+		}
+	default:
+		_, _ = c.Write(buf)
+	}
+	return
+}
+
+// kernelTLSAvailable tells whether the kernel supports kTLS, which requires the tls module.
+func kernelTLSAvailable() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	ulp, _ := os.ReadFile("/proc/sys/net/ipv4/tcp_available_ulp")
+	return strings.Contains(string(ulp), "tls")
+}
+
+func TestTLSKernelTLS(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "ktls")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString("the content of the file")
+	require.NoError(t, err)
+
+	server := &testKernelTLSServer{
+		testTLSCloseServer: &testTLSCloseServer{
+			testTLSHandshakeServer: &testTLSHandshakeServer{eng: make(chan Engine, 1), ready: make(chan struct{})},
+			closed:                 make(chan error, 1),
+		},
+		file: f,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(server, "tcp://127.0.0.1:9958", WithTicker(true), WithTLSConfig(getServerConfig()), WithKernelTLS(true))
+	}()
+	eng := <-server.eng
+	<-server.ready
+
+	// The connections fall back to the record layer in user space without kTLS.
+	state := "user  "
+	if kernelTLSAvailable() {
+		state = "kernel"
+	}
+	t.Logf("the record layer is served by the %s", strings.TrimSpace(state))
+
+	for _, suite := range []uint16{
+		0, // TLS 1.3
+		tls2.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls2.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls2.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	} {
+		config := getGoClientTLSConfig()
+		if suite != 0 {
+			config.MaxVersion = tls2.VersionTLS12
+			config.CipherSuites = []uint16{suite}
+		}
+		raw, err := net.Dial("tcp", "127.0.0.1:9958")
+		require.NoError(t, err)
+		c := tls2.Client(raw, config)
+		_ = c.SetDeadline(time.Now().Add(10 * time.Second))
+		for _, msg := range []string{"ping", "pong"} {
+			_, err = c.Write([]byte(msg))
+			require.NoError(t, err)
+			buf := make([]byte, len(msg))
+			_, err = io.ReadFull(c, buf)
+			require.NoError(t, err)
+			require.Equal(t, msg, string(buf))
+		}
+		if suite != 0 {
+			require.Equal(t, suite, c.ConnectionState().CipherSuite)
+		}
+
+		_, err = c.Write([]byte("state"))
+		require.NoError(t, err)
+		buf := make([]byte, len(state))
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t, err)
+		require.Equal(t, state, string(buf), tls2.CipherSuiteName(suite))
+
+		_, err = c.Write([]byte("file"))
+		require.NoError(t, err)
+		buf = make([]byte, len("the content of the file"))
+		_, err = io.ReadFull(c, buf)
+		require.NoError(t, err)
+		require.Equal(t, "the content of the file", string(buf))
+
+		// The peer closes the connection with close_notify, and the server replies with close_notify.
+		require.NoError(t, c.CloseWrite())
+		require.ErrorIs(t, <-server.closed, errorx.ErrTLSCloseNotify)
+		_, err = c.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+		_ = c.Close()
+	}
+	require.NoError(t, eng.Stop(context.Background()))
+	require.NoError(t, <-errCh)
+}